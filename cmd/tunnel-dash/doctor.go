@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/app"
+)
+
+// runDoctor implements "tunnel-dash doctor", which checks the connection and
+// the server's prerequisites without starting the dashboard. It returns the
+// exit code: 1 when a check failed.
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tunnel-dash doctor --host <alias> | --server <addr> --user <name> [options]\n\n")
+		fmt.Fprintf(fs.Output(), "Checks SSH access, remote tools, Docker, port forwarding and local ports.\n\n")
+		fs.PrintDefaults()
+	}
+	conn := addConnectionFlags(fs)
+	dashboardPort := fs.Int("dashboard-port", 8080, "Port for the web dashboard")
+	dashboardBind := fs.String("dashboard-bind", "127.0.0.1", "Address the web dashboard listens on (0.0.0.0 exposes it to the network)")
+	tunnelStartPort := fs.Int("tunnel-start-port", 9000, "Starting port for local tunnel ports")
+	_ = fs.Parse(args) //nolint:errcheck // ExitOnError exits instead
+
+	conn.check(fs)
+
+	config := app.Config{
+		DashboardPort:   *dashboardPort,
+		DashboardBind:   *dashboardBind,
+		TunnelStartPort: *tunnelStartPort,
+	}
+	conn.apply(&config)
+
+	controller, err := app.NewController(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing controller: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := controller.Doctor(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/app"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/hostkey"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/scanner"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// stringList is a flag that may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// connectionFlags say how to reach the server and run commands there. The
+// dashboard and the doctor command share them.
+type connectionFlags struct {
+	host         *string
+	serverAddr   *string
+	user         *string
+	keyPath      *string
+	insecure     *bool
+	hostKeyCheck *string
+	knownHosts   *string
+	sshBackend   *string
+	escalate     *string
+	escalateCmds *string
+	scanOrder    *string
+	askpass      *string
+	certCommand  *string
+	proxy        *string
+	hostKeyPins  stringList
+	jumps        stringList
+}
+
+func addConnectionFlags(fs *flag.FlagSet) *connectionFlags {
+	defaultKnownHosts, _ := hostkey.DefaultStorePath() //nolint:errcheck // Checked when TOFU is enabled
+
+	f := &connectionFlags{
+		host:         fs.String("host", "", "SSH host alias from ~/.ssh/config (alternative to --server/--user)"),
+		serverAddr:   fs.String("server", "", "SSH server address (required if --host not set)"),
+		user:         fs.String("user", "", "SSH username (required if --host not set)"),
+		keyPath:      fs.String("key", "", "Path to SSH private key (optional, overrides SSH config)"),
+		insecure:     fs.Bool("insecure", false, "Disable strict host key checking (WARNING: Man-in-the-Middle risk)"),
+		hostKeyCheck: fs.String("host-key-check", app.HostKeyStrict, "Host key verification: strict (your known_hosts) or tofu (confirm new keys once, refuse changed keys)"),
+		knownHosts:   fs.String("known-hosts", defaultKnownHosts, "known_hosts file where --host-key-check tofu remembers accepted keys"),
+		sshBackend:   fs.String("ssh-backend", app.BackendOpenSSH, "SSH implementation: openssh (the ssh binary) or native (built-in, one connection, no ssh binary needed)"),
+		escalate:     fs.String("escalate", "", "Run discovery commands with more privileges: sudo, doas, or sudo:user / doas:user (never asks for a password; overrides Escalate in ssh config)"),
+		escalateCmds: fs.String("escalate-commands", "", "Comma-separated programs that --escalate applies to, "+strings.Join(transport.DefaultEscalateCommands, ",")+" when unset (add cat,ls for --scan-strategies proc; overrides EscalateCommands in ssh config)"),
+		scanOrder:    fs.String("scan-strategies", strings.Join(defaultScanStrategies(), ","), "Comma-separated order in which to list listening ports: ss, netstat, proc (reads /proc/net/tcp, for hosts with neither)"),
+		certCommand:  fs.String("cert-command", "", "Command that writes a short-lived certificate next to the key as <key>-cert.pub, run before connecting and before it expires (overrides CertCommand in ssh config)"),
+		proxy:        fs.String("proxy", "", "Reach the SSH server through an HTTP CONNECT or SOCKS5 proxy, as http://[user:password@]host:port or socks5://[user:password@]host:port"),
+		askpass:      fs.String("askpass", app.AskpassTerminal, "Where to answer ssh prompts such as key passphrases and one-time codes: terminal, dashboard (a modal on --dashboard-port), or off"),
+	}
+	fs.Var(&f.hostKeyPins, "host-key-fingerprint", "Accept only this host key, as SHA256:... or host=SHA256:... for one host (repeatable, implies --host-key-check tofu)")
+	fs.Var(&f.jumps, "jump", "Connect through this jump host as [user@]host[:port], an ssh config alias works too (repeatable, in order; replaces ProxyJump)")
+	return f
+}
+
+// remote reports whether any flag names a server or a way to reach one.
+func (f *connectionFlags) remote() bool {
+	return *f.host != "" || *f.serverAddr != "" || len(f.jumps) > 0 || *f.proxy != ""
+}
+
+// check exits with usage when no server was given.
+func (f *connectionFlags) check(fs *flag.FlagSet) {
+	if *f.host != "" || (*f.serverAddr != "" && *f.user != "") {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: either --host or both --server and --user are required\n")
+	fmt.Fprintf(os.Stderr, "  Use --host to read from ~/.ssh/config\n")
+	fmt.Fprintf(os.Stderr, "  Or use --server and --user for direct connection\n")
+	fs.Usage()
+	os.Exit(1)
+}
+
+// apply copies the flags into config.
+func (f *connectionFlags) apply(config *app.Config) {
+	config.Host = *f.host
+	config.ServerAddr = *f.serverAddr
+	config.User = *f.user
+	config.KeyPath = *f.keyPath
+	config.Insecure = *f.insecure
+	config.HostKeyCheck = *f.hostKeyCheck
+	config.KnownHostsPath = *f.knownHosts
+	config.Backend = *f.sshBackend
+	config.HostKeyPins = f.hostKeyPins
+	config.Jumps = f.jumps
+	config.Escalate = *f.escalate
+	config.EscalateCommands = splitList(*f.escalateCmds)
+	config.ScanStrategies = splitList(*f.scanOrder)
+	config.Askpass = *f.askpass
+	config.CertCommand = *f.certCommand
+	config.Proxy = *f.proxy
+}
+
+// defaultScanStrategies names scanner.DefaultStrategies for the flag default.
+func defaultScanStrategies() []string {
+	names := make([]string, len(scanner.DefaultStrategies))
+	for i, strategy := range scanner.DefaultStrategies {
+		names[i] = string(strategy)
+	}
+	return names
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
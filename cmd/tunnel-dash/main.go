@@ -7,24 +7,57 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/app"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/askpass"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/tunnel"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/version"
 )
 
 func main() {
+	// ssh runs this binary as SSH_ASKPASS with the prompt as its argument
+	if socket := os.Getenv(askpass.SocketEnv); socket != "" {
+		var prompt string
+		if len(os.Args) > 1 {
+			prompt = os.Args[1]
+		}
+		os.Exit(askpass.RunHelper(socket, os.Getenv(askpass.TokenEnv), prompt, os.Getenv("SSH_ASKPASS_PROMPT")))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "proxy-connect" {
+		os.Exit(runProxyConnect(os.Args[2:]))
+	}
+
+	defaultPortState, _ := tunnel.DefaultPortStatePath() //nolint:errcheck // Empty disables the state file
+
+	conn := addConnectionFlags(flag.CommandLine)
 	var (
-		host            = flag.String("host", "", "SSH host alias from ~/.ssh/config (alternative to --server/--user)")
-		serverAddr      = flag.String("server", "", "SSH server address (required if --host not set)")
-		user            = flag.String("user", "", "SSH username (required if --host not set)")
-		keyPath         = flag.String("key", "", "Path to SSH private key (optional, overrides SSH config)")
 		scanPorts       = flag.String("scan-ports", "3000-9000", "Port range to scan (e.g., 3000-9000)")
 		dashboardPort   = flag.Int("dashboard-port", 8080, "Port for the web dashboard")
+		dashboardBind   = flag.String("dashboard-bind", "127.0.0.1", "Address the web dashboard listens on (0.0.0.0 exposes it to the network)")
 		tunnelStartPort = flag.Int("tunnel-start-port", 9000, "Starting port for local tunnel ports")
 		detectionMode   = flag.String("detection-mode", "both", "Service detection method: docker, direct, or both (default: both)")
-		insecure        = flag.Bool("insecure", false, "Disable strict host key checking (WARNING: Man-in-the-Middle risk)")
+		maxReconnects   = flag.Int("max-reconnects", 10, "Consecutive restart attempts for a dead tunnel before giving up (0 disables reconnecting)")
+		multiplex       = flag.Bool("multiplex", false, "Share a single SSH connection (ControlMaster) for all tunnels and remote commands")
+		lazy            = flag.Bool("lazy", false, "Bind local ports immediately and open SSH forwards only on first connection")
+		lazyIdle        = flag.Duration("lazy-idle", 5*time.Minute, "Close lazy forwards after this long without connections (0 keeps them open)")
+		ttl             = flag.Duration("ttl", 0, "Close tunnels this long after they are opened (0 keeps them open)")
+		idleTimeout     = flag.Duration("idle-timeout", 0, "Close tunnels after this long without connections (0 keeps them open)")
+		portState       = flag.String("port-state", defaultPortState, "File that remembers local ports per host across runs (empty disables)")
+		local           = flag.Bool("local", false, "Discover and serve services on this machine instead of an SSH server (no tunnels)")
+		socksPort       = flag.Int("socks-port", 0, "Start an ssh -D SOCKS5 proxy on this local port and serve /proxy.pac (0 disables)")
+		reverseForwards stringList
+		socketForwards  stringList
+		portTTLs        stringList
 		showVersion     = flag.Bool("version", false, "Show version information and exit")
 	)
+	flag.Var(&reverseForwards, "reverse", "Expose a local port on the SSH server as [bind_address:]remote_port:local_port (repeatable)")
+	flag.Var(&socketForwards, "socket", "Forward a remote Unix socket to a local port, or to a local socket as local_path:remote_path (repeatable)")
+	flag.Var(&portTTLs, "port-ttl", "Override --ttl for one remote port as port=duration, e.g. 5432=1h (repeatable)")
 	flag.Parse()
 
 	if *showVersion {
@@ -32,25 +65,35 @@ func main() {
 		os.Exit(0)
 	}
 
-	if *host == "" && (*serverAddr == "" || *user == "") {
-		fmt.Fprintf(os.Stderr, "Error: either --host or both --server and --user are required\n")
-		fmt.Fprintf(os.Stderr, "  Use --host to read from ~/.ssh/config\n")
-		fmt.Fprintf(os.Stderr, "  Or use --server and --user for direct connection\n")
-		flag.Usage()
-		os.Exit(1)
+	if *local {
+		if conn.remote() {
+			fmt.Fprintf(os.Stderr, "Error: --local cannot be combined with --host, --server, --jump or --proxy\n")
+			os.Exit(1)
+		}
+	} else {
+		conn.check(flag.CommandLine)
 	}
 
 	config := app.Config{
-		Host:            *host,
-		ServerAddr:      *serverAddr,
-		User:            *user,
-		KeyPath:         *keyPath,
 		ScanPorts:       *scanPorts,
 		DashboardPort:   *dashboardPort,
+		DashboardBind:   *dashboardBind,
 		TunnelStartPort: *tunnelStartPort,
 		DetectionMode:   *detectionMode,
-		Insecure:        *insecure,
+		MaxReconnects:   *maxReconnects,
+		Multiplex:       *multiplex,
+		Lazy:            *lazy,
+		LazyIdle:        *lazyIdle,
+		SOCKSPort:       *socksPort,
+		ReverseForwards: reverseForwards,
+		SocketForwards:  socketForwards,
+		TTL:             *ttl,
+		IdleTimeout:     *idleTimeout,
+		PortTTLs:        portTTLs,
+		PortStatePath:   *portState,
+		Local:           *local,
 	}
+	conn.apply(&config)
 
 	controller, err := app.NewController(config)
 	if err != nil {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/proxy"
+)
+
+// runProxyConnect implements "tunnel-dash proxy-connect", the ProxyCommand
+// that ssh runs for --proxy. It connects stdin and stdout to host:port
+// through the proxy in the TUNNEL_DASH_PROXY environment variable. Jump
+// hosts after the proxy are reached by ssh itself, see ssh.Config.ProxyCommand.
+func runProxyConnect(args []string) int {
+	fs := flag.NewFlagSet("proxy-connect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s=<url> tunnel-dash proxy-connect <host> <port>\n\n", proxy.URLEnv)
+		fmt.Fprintf(fs.Output(), "ProxyCommand used by ssh for --proxy; not meant to be run by hand.\n")
+	}
+	_ = fs.Parse(args) //nolint:errcheck // ExitOnError exits instead
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	addr := net.JoinHostPort(fs.Arg(0), fs.Arg(1))
+
+	p, err := proxy.Parse(os.Getenv(proxy.URLEnv))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel-dash proxy-connect: invalid %s: %v\n", proxy.URLEnv, err)
+		return 1
+	}
+	conn, err := p.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel-dash proxy-connect: %v\n", err)
+		return 1
+	}
+	defer func() {
+		_ = conn.Close() //nolint:errcheck // Exiting anyway
+	}()
+
+	if err := proxy.Relay(conn, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel-dash proxy-connect: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -1,3 +1,10 @@
 module github.com/azizoid/zero-trust-tunnel-dashboard
 
-go 1.23
+go 1.23.0
+
+require (
+	golang.org/x/crypto v0.36.0
+	golang.org/x/term v0.30.0
+)
+
+require golang.org/x/sys v0.31.0 // indirect
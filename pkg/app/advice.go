@@ -0,0 +1,89 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// describe formats err for the user, adding advice for classified remote
+// failures on the next line.
+func describe(err error) string {
+	if advice := remoteAdvice(err); advice != "" {
+		return fmt.Sprintf("%v\n  -> %s", err, advice)
+	}
+	return err.Error()
+}
+
+// remoteAdvice says what to do about a failed remote operation, or "" when
+// the failure was not classified.
+func remoteAdvice(err error) string {
+	var remoteErr *transport.Error
+	if !errors.As(err, &remoteErr) {
+		return ""
+	}
+	return adviceFor(remoteErr.Kind, program(remoteErr.Command))
+}
+
+// adviceFor returns the advice for kind. program is the remote command that
+// failed, if any, such as "docker".
+func adviceFor(kind transport.ErrorKind, program string) string {
+	switch kind {
+	case transport.KindAuth:
+		return "The server rejected the login: check the user name and pass the right key with --key, load it with ssh-add, or check that the certificate from --cert-command is still valid"
+	case transport.KindUnreachable:
+		return "The server could not be reached: check the address and port, your VPN, proxy (--proxy) or jump hosts (--jump), and that sshd is running"
+	case transport.KindHostKey:
+		return "The server's host key is unknown or has changed: verify its fingerprint with the administrator, then add it to known_hosts or use --host-key-check tofu"
+	case transport.KindTimeout:
+		return "The connection timed out: check firewalls between you and the server, or try a jump host with --jump"
+	case transport.KindForwarding:
+		return "The server refused the forward: AllowTcpForwarding (AllowStreamLocalForwarding for sockets) must be enabled in sshd_config and PermitOpen must allow the target"
+	case transport.KindNotFound:
+		switch program {
+		case "docker":
+			return "docker is not installed on the server or not in the PATH of non-interactive SSH sessions; use --detection-mode direct to skip Docker"
+		case "ss", "netstat":
+			return "Neither ss nor netstat works on the server: install iproute2 (ss) or net-tools (netstat)"
+		case "":
+			return "A command is not installed on the server or not in the PATH of non-interactive SSH sessions"
+		}
+		return fmt.Sprintf("%s is not installed on the server or not in the PATH of non-interactive SSH sessions", program)
+	case transport.KindEscalation:
+		if program == "" {
+			program = "these commands"
+		}
+		return fmt.Sprintf("sudo or doas would need a password: allow the SSH user to run %s without one (NOPASSWD in sudoers, nopass in doas.conf), or drop --escalate", program)
+	case transport.KindPermission:
+		if program == "docker" {
+			return "The SSH user cannot use the Docker socket: add it to the docker group (sudo usermod -aG docker $USER) and reconnect, or run docker through sudo with --escalate sudo"
+		}
+		if program == "" {
+			return "The SSH user lacks the permissions for this, connect as a user that has them"
+		}
+		return fmt.Sprintf("The SSH user lacks the permissions to run %s, connect as a user that has them", program)
+	}
+	return ""
+}
+
+// program returns the command name of a remote command line, or "" for
+// forwards and other operations that are not commands. A sudo or doas
+// prefix added by --escalate is skipped.
+func program(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) > 0 && (fields[0] == transport.EscalateSudo || fields[0] == transport.EscalateDoas) {
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			if fields[0] == "-u" && len(fields) > 1 {
+				fields = fields[1:]
+			}
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "-") {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+func TestAdviceFor(t *testing.T) {
+	tests := []struct {
+		kind    transport.ErrorKind
+		program string
+		// want is a part of the hint only that kind and program get, "" for none
+		want string
+	}{
+		{transport.KindAuth, "", "rejected the login"},
+		{transport.KindUnreachable, "", "could not be reached"},
+		{transport.KindHostKey, "", "--host-key-check tofu"},
+		{transport.KindTimeout, "", "timed out"},
+		{transport.KindForwarding, "", "AllowTcpForwarding"},
+		{transport.KindNotFound, "docker", "--detection-mode direct"},
+		{transport.KindNotFound, "ss", "install iproute2 (ss) or net-tools (netstat)"},
+		{transport.KindNotFound, "netstat", "install iproute2 (ss) or net-tools (netstat)"},
+		{transport.KindNotFound, "sqlite3", "sqlite3 is not installed"},
+		{transport.KindNotFound, "", "A command is not installed"},
+		{transport.KindEscalation, "docker", "allow the SSH user to run docker without one"},
+		{transport.KindEscalation, "", "allow the SSH user to run these commands without one"},
+		{transport.KindPermission, "docker", "add it to the docker group"},
+		{transport.KindPermission, "ss", "lacks the permissions to run ss"},
+		{transport.KindPermission, "", "lacks the permissions for this"},
+		{transport.KindUnknown, "docker", ""},
+	}
+
+	seen := make(map[string]transport.ErrorKind)
+	for _, tt := range tests {
+		t.Run(string(tt.kind)+"/"+tt.program, func(t *testing.T) {
+			got := adviceFor(tt.kind, tt.program)
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("adviceFor(%s, %q) = %q, want none", tt.kind, tt.program, got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("adviceFor(%s, %q) = %q, want it to contain %q", tt.kind, tt.program, got, tt.want)
+			}
+			if kind, ok := seen[got]; ok && kind != tt.kind {
+				t.Errorf("adviceFor(%s, %q) gives the same hint as %s", tt.kind, tt.program, kind)
+			}
+			seen[got] = tt.kind
+		})
+	}
+}
+
+func TestRemoteAdvice(t *testing.T) {
+	denied := &transport.Error{
+		Kind:    transport.KindPermission,
+		Command: "docker ps --format '{{.Names}}'",
+		Stderr:  "permission denied while trying to connect to the Docker daemon socket",
+		Err:     errors.New("exit status 1"),
+	}
+	if got, want := remoteAdvice(denied), adviceFor(transport.KindPermission, "docker"); got != want {
+		t.Errorf("remoteAdvice() = %q, want %q", got, want)
+	}
+	if got := remoteAdvice(errors.New("boom")); got != "" {
+		t.Errorf("remoteAdvice() of an unclassified error = %q, want none", got)
+	}
+}
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"docker ps", "docker"},
+		{"ss -tlnp", "ss"},
+		{"sudo -n docker ps", "docker"},
+		{"sudo -n -u deploy docker ps", "docker"},
+		{"doas -n -u deploy ss -tlnp", "ss"},
+		{"sudo -n", ""},
+		{"-L 8080:localhost:80", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := program(tt.command); got != tt.want {
+			t.Errorf("program(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
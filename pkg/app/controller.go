@@ -1,17 +1,33 @@
 package app
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/askpass"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/dashboard"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/detector"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/hostkey"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/localexec"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/nativessh"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/proxy"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/scanner"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/server"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/ssh"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/sshcert"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/sshconfig"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/tunnel"
 )
 
@@ -25,8 +41,88 @@ type Config struct {
 	TunnelStartPort int
 	DetectionMode   string
 	Insecure        bool
+	MaxReconnects   int
+	Multiplex       bool
+	Lazy            bool
+	LazyIdle        time.Duration
+	SOCKSPort       int
+	// ReverseForwards are "[bind_address:]remote_port:local_port" specs
+	ReverseForwards []string
+	// SocketForwards are "[local_path:]remote_path" Unix socket specs
+	SocketForwards []string
+	// TTL and IdleTimeout close tunnels after a fixed time or inactivity
+	TTL         time.Duration
+	IdleTimeout time.Duration
+	// PortTTLs are "port=duration" overrides of TTL for single tunnels
+	PortTTLs []string
+	// PortStatePath is the file that remembers local ports per host, empty to disable
+	PortStatePath string
+	// DashboardBind is the address the dashboard listens on, loopback when empty
+	DashboardBind string
+	// Backend selects the SSH implementation, BackendOpenSSH by default
+	Backend string
+	// HostKeyCheck selects how the server's key is verified, HostKeyStrict by default
+	HostKeyCheck string
+	// KnownHostsPath is tunnel-dash's own known_hosts file used by HostKeyTOFU
+	KnownHostsPath string
+	// HostKeyPins are "SHA256:..." or "host=SHA256:..." fingerprints to accept
+	HostKeyPins []string
+	// Jumps are jump hosts as [user@]host[:port], connected through in order.
+	// They replace a ProxyJump from ssh_config.
+	Jumps []string
+	// Escalate runs discovery commands through "sudo", "doas", or either as
+	// "method:user". It overrides Escalate from ssh_config; with neither
+	// they run as the login user.
+	Escalate string
+	// EscalateCommands are the programs Escalate applies to. They override
+	// EscalateCommands from ssh_config, transport.DefaultEscalateCommands
+	// when neither is set.
+	EscalateCommands []string
+	// ScanStrategies is the order in which ss, netstat and /proc are tried
+	// to list listening ports, scanner.DefaultStrategies when empty
+	ScanStrategies []string
+	// Local discovers and serves services on this machine without SSH.
+	// Ports are linked directly instead of tunneled.
+	Local bool
+	// Askpass selects where ssh prompts such as key passphrases and one-time
+	// codes are answered, AskpassTerminal by default
+	Askpass string
+	// CertCommand obtains a short-lived certificate for the server's key,
+	// replacing CertCommand from ssh_config
+	CertCommand string
+	// Proxy is an http:// or socks5:// proxy URL through which the server,
+	// or the first jump host, is reached
+	Proxy string
 }
 
+// Prompt modes selectable with Config.Askpass.
+const (
+	// AskpassTerminal asks on the terminal tunnel-dash runs in
+	AskpassTerminal = "terminal"
+	// AskpassDashboard asks in a modal of the web dashboard, which is
+	// served before connecting
+	AskpassDashboard = "dashboard"
+	// AskpassOff leaves ssh non-interactive, prompts fail
+	AskpassOff = "off"
+)
+
+// Host key checking modes selectable with Config.HostKeyCheck.
+const (
+	// HostKeyStrict leaves verification to the user's known_hosts
+	HostKeyStrict = "strict"
+	// HostKeyTOFU asks once about unknown keys and remembers them in
+	// KnownHostsPath, refusing keys that change later
+	HostKeyTOFU = "tofu"
+)
+
+// SSH backends selectable with Config.Backend.
+const (
+	// BackendOpenSSH runs the ssh binary for every command and forward
+	BackendOpenSSH = "openssh"
+	// BackendNative speaks SSH in-process over a single connection
+	BackendNative = "native"
+)
+
 type Controller struct {
 	config      Config
 	tunnelMgr   *tunnel.Manager
@@ -34,6 +130,21 @@ type Controller struct {
 	dashGen     *dashboard.Generator
 	httpServer  *server.Server
 	cancel      context.CancelFunc
+	// remote is the transport shared by the scanner, detectors and tunnels
+	remote transport.Transport
+	// discovery is remote with privilege escalation applied, used by the
+	// scanner and detectors
+	discovery transport.Transport
+	// askpass answers ssh prompts, nil when disabled
+	askpass *askpass.Bridge
+	// prompts holds the prompts answered in the dashboard
+	prompts *askpass.Queue
+	// certs keep the certificates of the server and jump hosts fresh
+	certs []*sshcert.Hook
+	// refreshingCerts is set while refreshCerts renews them
+	refreshingCerts atomic.Bool
+	// proxy is the parsed Config.Proxy, nil for direct connections
+	proxy *proxy.Proxy
 }
 
 func NewController(cfg Config) (*Controller, error) {
@@ -42,41 +153,531 @@ func NewController(cfg Config) (*Controller, error) {
 	}, nil
 }
 
-func (c *Controller) Run(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
-	c.cancel = cancel
-	defer cancel()
+// verifyHostKey checks the server's key in trust-on-first-use mode and
+// returns the known_hosts file the transports must use, or "" to keep the
+// user's own. Pinned fingerprints turn the check on as well. Jump hosts are
+// verified first, each one reached through the hops before it.
+func (c *Controller) verifyHostKey(ctx context.Context, target remoteTarget) (string, error) {
+	switch c.config.HostKeyCheck {
+	case "", HostKeyStrict:
+		if len(c.config.HostKeyPins) == 0 {
+			return "", nil
+		}
+	case HostKeyTOFU:
+	default:
+		return "", fmt.Errorf("unknown host key check %q (use %s or %s)", c.config.HostKeyCheck, HostKeyStrict, HostKeyTOFU)
+	}
+	if c.config.Insecure {
+		return "", nil
+	}
+	if c.config.KnownHostsPath == "" {
+		return "", fmt.Errorf("host key verification needs a known_hosts file, set --known-hosts")
+	}
 
-	var finalServer, finalUser, finalKey string
+	hops := target.hops
+	chain := append(slices.Clone(hops), target)
 
-	if c.config.Host != "" {
-		sshConfig, err := sshconfig.ParseSSHConfig(c.config.Host)
+	for i, hop := range chain {
+		verifier := &hostkey.Verifier{
+			StorePath: c.config.KnownHostsPath,
+			Pins:      c.config.HostKeyPins,
+			Confirm:   c.confirmHostKey(ctx),
+		}
+		if i == 0 && c.proxy != nil {
+			verifier.Dial = c.proxy.DialContext
+		}
+		if i > 0 {
+			via, err := c.newTransport(chain[i-1], c.config.KnownHostsPath)
+			if err != nil {
+				return "", err
+			}
+			if closer, ok := via.(io.Closer); ok {
+				defer func() {
+					_ = closer.Close() //nolint:errcheck // Only used for the key scan
+				}()
+			}
+			if dialer, ok := via.(transport.Dialer); ok {
+				verifier.Dial = dialer.Dial
+			}
+		}
+
+		fingerprint, err := verifier.Verify(ctx, hop.server, hop.port)
 		if err != nil {
-			return fmt.Errorf("error reading SSH config: %v (make sure you have a Host entry for '%s')", err, c.config.Host)
+			var mismatch *hostkey.MismatchError
+			if errors.As(err, &mismatch) {
+				fmt.Fprintln(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+				fmt.Fprintln(os.Stderr, mismatch.Error())
+				fmt.Fprintln(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+			}
+			return "", fmt.Errorf("host key verification failed: %v", err)
 		}
 
-		finalServer = sshConfig.HostName
-		finalUser = sshConfig.User
-		finalKey = sshConfig.IdentityFile
+		if i < len(hops) {
+			fmt.Printf("Host key (jump host %s): %s\n", target.jumps[i], fingerprint)
+		} else {
+			fmt.Printf("Host key: %s\n", fingerprint)
+		}
+	}
+	return c.config.KnownHostsPath, nil
+}
 
-		if c.config.KeyPath != "" {
-			finalKey = c.config.KeyPath
+// confirmHostKey returns the question whether to trust an unknown key. It
+// is asked through the askpass bridge when there is one, so --askpass
+// dashboard asks in the dashboard, and on the terminal otherwise. When
+// nobody can be asked the key is rejected and the fingerprint printed for
+// pinning.
+func (c *Controller) confirmHostKey(ctx context.Context) func(host, keyType, fingerprint string) bool {
+	return func(host, keyType, fingerprint string) bool {
+		question := fmt.Sprintf("The authenticity of host %s can't be established.\n%s key fingerprint is %s\n",
+			host, strings.ToUpper(strings.TrimPrefix(keyType, "ssh-")), fingerprint)
+		if c.askpass == nil {
+			return confirmOnTerminal(question, fingerprint)
 		}
 
-		if finalUser == "" {
-			finalUser = os.Getenv("USER")
-			if finalUser == "" {
-				finalUser = os.Getenv("USERNAME")
-				if finalUser == "" {
-					finalUser = "root" // fallback
-				}
+		req := askpass.Request{Prompt: question + "Trust this host key and remember it? (yes/no): ", Confirm: true}
+		answer, err := c.askpass.Ask(ctx, req, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not confirm the key (%v), verify it out of band and pass --host-key-fingerprint %s\n", err, fingerprint)
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// confirmOnTerminal asks question on the terminal with --askpass off.
+func confirmOnTerminal(question, fingerprint string) bool {
+	fmt.Print(question)
+
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		fmt.Fprintf(os.Stderr, "No terminal to confirm the key, verify it out of band and pass --host-key-fingerprint %s\n", fingerprint)
+		return false
+	}
+
+	fmt.Print("Trust this host key and remember it? [y/N]: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n') //nolint:errcheck // EOF counts as no
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// remoteTarget is the SSH destination after ssh_config has been applied.
+type remoteTarget struct {
+	// alias is the ssh_config host that OpenSSH resolves itself, if any
+	alias      string
+	server     string
+	user       string
+	port       int
+	identities []string
+	// jumps are the hops connected through in order, as [user@]host[:port]
+	jumps []string
+	// hops are jumps resolved through ssh_config
+	hops []remoteTarget
+	// certCommand obtains a certificate for the first identity
+	certCommand string
+	// escalate and escalateCommands configure discovery escalation, see
+	// transport.ParseEscalation
+	escalate         string
+	escalateCommands []string
+}
+
+// jumpTargets resolves jump host specs through ssh_config. Each hop is
+// reached through the ones before it.
+func jumpTargets(jumps []string) ([]remoteTarget, error) {
+	hops, err := sshconfig.ResolveJumps(strings.Join(jumps, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid jump hosts %q: %v", strings.Join(jumps, ","), err)
+	}
+
+	targets := make([]remoteTarget, 0, len(hops))
+	for i, hop := range hops {
+		user := hop.User
+		if user == "" {
+			user = localUserName()
+		}
+		targets = append(targets, remoteTarget{
+			server:      hop.HostName,
+			user:        user,
+			port:        hop.Port,
+			identities:  hop.IdentityFiles,
+			jumps:       jumps[:i],
+			hops:        targets[:i:i],
+			certCommand: hop.CertCommand,
+		})
+	}
+	return targets, nil
+}
+
+// splitJumps splits ProxyJump-style lists into single hops.
+func splitJumps(values []string) []string {
+	var jumps []string
+	for _, value := range values {
+		if strings.EqualFold(strings.TrimSpace(value), "none") {
+			continue
+		}
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				jumps = append(jumps, hop)
 			}
 		}
-	} else {
-		finalServer = c.config.ServerAddr
-		finalUser = c.config.User
-		finalKey = c.config.KeyPath
 	}
+	return jumps
+}
+
+// usesHostAlias reports whether OpenSSH resolves --host from ssh_config itself.
+func (c *Controller) usesHostAlias() bool {
+	return c.config.Host != "" && (c.config.Backend == "" || c.config.Backend == BackendOpenSSH)
+}
+
+// newTransport creates the transport for the configured SSH backend.
+// knownHosts replaces the user's known_hosts file when it is not empty.
+func (c *Controller) newTransport(target remoteTarget, knownHosts string) (transport.Transport, error) {
+	switch c.config.Backend {
+	case "", BackendOpenSSH:
+		if target.alias != "" {
+			// Let OpenSSH apply the whole ~/.ssh/config entry itself
+			return ssh.NewClient(ssh.Config{
+				UseHostAlias:   true,
+				HostAlias:      target.alias,
+				ProxyJump:      strings.Join(target.jumps, ","),
+				Insecure:       c.config.Insecure,
+				KnownHostsFile: knownHosts,
+				Env:            c.sshEnv(),
+				ProxyCommand:   c.proxyCommand(),
+			}), nil
+		}
+		return ssh.NewClient(ssh.Config{
+			Server:         target.server,
+			User:           target.user,
+			IdentityFiles:  target.identities,
+			Port:           target.port,
+			ProxyJump:      strings.Join(target.jumps, ","),
+			Insecure:       c.config.Insecure,
+			KnownHostsFile: knownHosts,
+			Env:            c.sshEnv(),
+			ProxyCommand:   c.proxyCommand(),
+		}), nil
+	case BackendNative:
+		var knownHostsFiles []string
+		if knownHosts != "" {
+			knownHostsFiles = append(knownHostsFiles, knownHosts)
+		}
+		var jumps []nativessh.Config
+		for _, hop := range target.hops {
+			jumps = append(jumps, nativessh.Config{
+				Server:        hop.server,
+				Port:          hop.port,
+				User:          hop.user,
+				IdentityFiles: hop.identities,
+			})
+		}
+		var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+		if c.proxy != nil {
+			dial = c.proxy.DialContext
+		}
+		return nativessh.NewClient(nativessh.Config{
+			Server:          target.server,
+			Port:            target.port,
+			User:            target.user,
+			IdentityFiles:   target.identities,
+			KnownHostsFiles: knownHostsFiles,
+			Insecure:        c.config.Insecure,
+			Jumps:           jumps,
+			Prompt:          c.nativePrompt(),
+			Dial:            dial,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown SSH backend %q (use %s or %s)", c.config.Backend, BackendOpenSSH, BackendNative)
+	}
+}
+
+// setupProxy parses Config.Proxy.
+func (c *Controller) setupProxy() error {
+	if c.config.Proxy == "" {
+		return nil
+	}
+	p, err := proxy.Parse(c.config.Proxy)
+	if err != nil {
+		return fmt.Errorf("invalid --proxy: %v", err)
+	}
+	c.proxy = p
+	return nil
+}
+
+// proxyCommand returns the ProxyCommand that takes the ssh binary to the
+// server, or its first jump host, through the proxy, "" without a proxy.
+func (c *Controller) proxyCommand() string {
+	if c.proxy == nil {
+		return ""
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: connecting without --proxy: %v\n", err)
+		return ""
+	}
+	return ssh.HelperProxyCommand(exe)
+}
+
+// sshEnv returns the environment of ssh processes: the askpass bridge and
+// the proxy URL for proxyCommand.
+func (c *Controller) sshEnv() []string {
+	env := c.askpassEnv()
+	if c.proxy != nil {
+		env = append(env, proxy.URLEnv+"="+c.config.Proxy)
+	}
+	return env
+}
+
+// startAskpass starts the bridge that answers ssh prompts in the given mode.
+// Prompts stay unanswered in AskpassOff mode.
+func (c *Controller) startAskpass(mode string) error {
+	var prompter askpass.Prompter
+	switch mode {
+	case "", AskpassTerminal:
+		prompter = &askpass.Terminal{}
+	case AskpassDashboard:
+		c.prompts = askpass.NewQueue()
+		prompter = c.prompts
+	case AskpassOff:
+		return nil
+	default:
+		return fmt.Errorf("unknown askpass mode %q (use %s, %s or %s)", mode, AskpassTerminal, AskpassDashboard, AskpassOff)
+	}
+
+	bridge := askpass.NewBridge(prompter)
+	if err := bridge.Listen(); err != nil {
+		return err
+	}
+	c.askpass = bridge
+	return nil
+}
+
+// askpassEnv makes ssh processes run this binary as their SSH_ASKPASS.
+func (c *Controller) askpassEnv() []string {
+	if c.askpass == nil {
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ssh prompts cannot be answered: %v\n", err)
+		return nil
+	}
+	return c.askpass.Env(exe)
+}
+
+// nativePrompt lets the native backend ask through the same bridge.
+func (c *Controller) nativePrompt() nativessh.PromptFunc {
+	if c.askpass == nil {
+		return nil
+	}
+	return func(prompt string, echo bool) (string, error) {
+		return c.askpass.Ask(context.Background(), askpass.Request{Prompt: prompt, Echo: echo}, 0)
+	}
+}
+
+// certHooks returns a certificate hook for every host on the route that has
+// a cert command, jump hosts first.
+func (c *Controller) certHooks(target remoteTarget) ([]*sshcert.Hook, error) {
+	var hooks []*sshcert.Hook
+	for _, hop := range append(slices.Clone(target.hops), target) {
+		if hop.certCommand == "" {
+			continue
+		}
+		if len(hop.identities) == 0 {
+			return nil, fmt.Errorf("cert_command for %s needs a key to certify, set IdentityFile or --key", hop.server)
+		}
+		hooks = append(hooks, &sshcert.Hook{
+			Host:     hop.server,
+			User:     hop.user,
+			Identity: hop.identities[0],
+			Command:  hop.certCommand,
+		})
+	}
+	return hooks, nil
+}
+
+// startCerts obtains the certificates the route needs and renews them in the
+// background until ctx ends.
+func (c *Controller) startCerts(ctx context.Context, target remoteTarget) error {
+	hooks, err := c.certHooks(target)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		expires, err := hook.Ensure(ctx)
+		if err != nil {
+			return fmt.Errorf("error obtaining SSH certificate: %v", err)
+		}
+		fmt.Printf("Certificate for %s: %s\n", hook.Host, validUntil(expires))
+
+		go hook.Watch(ctx, func(expires time.Time, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: certificate for %s not renewed, retrying: %v\n", hook.Host, err)
+				return
+			}
+			fmt.Printf("   Certificate for %s renewed, %s\n", hook.Host, validUntil(expires))
+		})
+	}
+	c.certs = hooks
+	return nil
+}
+
+// refreshCerts renews certificates that expired, e.g. while the machine
+// slept. It runs in the background, and failures while it runs share it.
+func (c *Controller) refreshCerts() {
+	if len(c.certs) == 0 || !c.refreshingCerts.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer c.refreshingCerts.Store(false)
+		for _, hook := range c.certs {
+			ran, err := hook.Refresh(context.Background())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: expired certificate for %s not renewed: %v\n", hook.Host, err)
+			} else if ran {
+				fmt.Printf("   Expired certificate for %s renewed\n", hook.Host)
+			}
+		}
+	}()
+}
+
+// remoteFailed passes authentication failures of remote commands, such as
+// scans, to authFailed.
+func (c *Controller) remoteFailed(err error) {
+	if transport.KindOf(err) == transport.KindAuth {
+		c.authFailed()
+	}
+}
+
+// authFailed makes the next login ask for prompts again and get a renewed
+// certificate if it expired.
+func (c *Controller) authFailed() {
+	if c.askpass != nil {
+		// A remembered passphrase or code may be stale
+		c.askpass.Forget()
+	}
+	c.refreshCerts()
+}
+
+func validUntil(expires time.Time) string {
+	if expires.IsZero() {
+		return "valid forever"
+	}
+	return "valid until " + expires.Format("2006-01-02 15:04")
+}
+
+// route returns the hops to the server as shown to the user, ending with the
+// server itself.
+func (c *Controller) route(target remoteTarget) []string {
+	name := c.config.Host
+	if name == "" {
+		name = target.server
+	}
+	return append(append([]string{}, target.jumps...), name)
+}
+
+// localUserName is the login used when ssh_config names no User.
+func localUserName() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	if user := os.Getenv("USERNAME"); user != "" {
+		return user
+	}
+	return "root" // fallback
+}
+
+// resolveTarget applies ssh_config and the command line to find the server,
+// along with the LocalForward entries of its config. ssh_config is read once
+// here, for --host or, like ssh user@server, for --server, and the jump
+// hosts are resolved along with it.
+func (c *Controller) resolveTarget() (remoteTarget, []sshconfig.LocalForward, error) {
+	if c.config.Local {
+		target := remoteTarget{server: "localhost", user: localUserName()}
+		target.escalate = c.config.Escalate
+		target.escalateCommands = c.config.EscalateCommands
+		return target, nil, nil
+	}
+
+	target := remoteTarget{port: 22}
+
+	name := c.config.Host
+	if name == "" {
+		name = c.config.ServerAddr
+	}
+	sshConfig, err := sshconfig.ParseSSHConfig(name)
+	if err != nil {
+		if c.config.Host != "" {
+			return target, nil, fmt.Errorf("error reading SSH config: %v (make sure you have a Host entry for '%s')", err, c.config.Host)
+		}
+		return target, nil, fmt.Errorf("error reading SSH config: %v", err)
+	}
+
+	target.server = sshConfig.HostName
+	target.user = sshConfig.User
+	target.port = sshConfig.Port
+	target.identities = sshConfig.IdentityFiles
+	target.jumps = splitJumps([]string{sshConfig.ProxyJump})
+	target.certCommand = sshConfig.CertCommand
+	target.escalate = sshConfig.Escalate
+	target.escalateCommands = sshConfig.EscalateCommands
+	if c.usesHostAlias() {
+		target.alias = c.config.Host
+	}
+
+	if c.config.Host == "" && c.config.User != "" {
+		target.user = c.config.User
+	}
+	if target.user == "" {
+		target.user = localUserName()
+	}
+	if c.config.KeyPath != "" {
+		// Like ssh -i, the key is tried before the config's identities
+		target.identities = append([]string{c.config.KeyPath}, target.identities...)
+	}
+	if len(c.config.Jumps) > 0 {
+		// Like ssh -J, jump hosts given here replace ProxyJump from ssh_config
+		target.jumps = splitJumps(c.config.Jumps)
+	}
+	if c.config.CertCommand != "" {
+		target.certCommand = c.config.CertCommand
+	}
+	if c.config.Escalate != "" {
+		target.escalate = c.config.Escalate
+	}
+	if len(c.config.EscalateCommands) > 0 {
+		target.escalateCommands = c.config.EscalateCommands
+	}
+
+	target.hops, err = jumpTargets(target.jumps)
+	if err != nil {
+		return target, nil, err
+	}
+	return target, sshConfig.LocalForwards, nil
+}
+
+func (c *Controller) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	defer cancel()
+
+	target, localForwards, err := c.resolveTarget()
+	if err != nil {
+		return err
+	}
+	escalation, err := transport.ParseEscalation(target.escalate, target.escalateCommands)
+	if err != nil {
+		return fmt.Errorf("invalid --escalate: %v", err)
+	}
+	strategies, err := scanner.ParseStrategies(c.config.ScanStrategies)
+	if err != nil {
+		return fmt.Errorf("invalid --scan-strategies: %v", err)
+	}
+	if err := c.setupProxy(); err != nil {
+		return err
+	}
+	finalServer := target.server
 
 	fmt.Println("Zero-Trust Tunnel Dashboard")
 	fmt.Println("===========================================================")
@@ -84,9 +685,27 @@ func (c *Controller) Run(ctx context.Context) error {
 		fmt.Printf("SSH Host: %s\n", c.config.Host)
 	}
 	fmt.Printf("Server: %s\n", finalServer)
-	fmt.Printf("User: %s\n", finalUser)
-	if finalKey != "" {
-		fmt.Printf("Key: %s\n", finalKey)
+	if c.config.Local {
+		fmt.Println("Mode: local, services are linked directly without SSH")
+	}
+	if target.port != 0 && target.port != 22 {
+		fmt.Printf("Port: %d\n", target.port)
+	}
+	fmt.Printf("User: %s\n", target.user)
+	if len(target.identities) > 0 {
+		fmt.Printf("Key: %s\n", strings.Join(target.identities, ", "))
+	}
+	if len(target.jumps) > 0 {
+		fmt.Printf("Route: %s\n", strings.Join(c.route(target), " -> "))
+	}
+	if c.proxy != nil {
+		fmt.Printf("Proxy: %s\n", c.proxy)
+	}
+	if c.config.Backend == BackendNative {
+		fmt.Println("SSH backend: native")
+	}
+	if escalation != nil {
+		fmt.Printf("Escalation: %s for %s\n", escalation, strings.Join(escalation.Commands, ", "))
 	}
 	if c.config.Insecure {
 		fmt.Println("WARNING: Strict host key checking disabled!")
@@ -94,46 +713,131 @@ func (c *Controller) Run(ctx context.Context) error {
 	fmt.Printf("Scanning ports: %s\n", c.config.ScanPorts)
 	fmt.Println()
 
-	if c.config.Host != "" {
-		c.tunnelMgr = tunnel.NewManagerWithHost(c.config.Host, c.config.TunnelStartPort)
-		c.portScanner = scanner.NewScannerWithHost(c.config.Host)
+	if !c.config.Local {
+		if err := c.startAskpass(c.config.Askpass); err != nil {
+			return err
+		}
+		if c.askpass != nil {
+			defer func() {
+				_ = c.askpass.Close() //nolint:errcheck // Shutting down anyway
+			}()
+		}
+	}
+	if c.prompts != nil {
+		// Prompts come up while connecting, long before the dashboard exists
+		c.httpServer = c.newHTTPServer(nil)
+		c.httpServer.SetPrompts(c.prompts)
+		c.startHTTPServer()
+		fmt.Printf("SSH prompts are answered at: %s\n\n", c.dashboardURL())
+	}
+
+	if !c.config.Local {
+		if err := c.startCerts(ctx, target); err != nil {
+			return err
+		}
+	}
+
+	var remote transport.Transport
+	if c.config.Local {
+		remote = localexec.NewClient()
+		if c.config.Multiplex || c.config.Lazy {
+			fmt.Println("Note: --multiplex and --lazy have no effect in local mode")
+			c.config.Multiplex = false
+			c.config.Lazy = false
+		}
+		if len(c.config.ReverseForwards) > 0 {
+			fmt.Println("Note: --reverse has no effect in local mode, the ports are already on this machine")
+		}
 	} else {
-		c.tunnelMgr = tunnel.NewManager(finalServer, finalUser, finalKey, c.config.TunnelStartPort)
-		c.portScanner = scanner.NewScanner(finalServer, finalUser, finalKey)
+		knownHosts, err := c.verifyHostKey(ctx, target)
+		if err != nil {
+			return err
+		}
+		if remote, err = c.newTransport(target, knownHosts); err != nil {
+			return err
+		}
+	}
+	c.remote = remote
+	c.discovery = transport.OnError(transport.Escalate(remote, escalation), c.remoteFailed)
+	if closer, ok := remote.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close() //nolint:errcheck // Shutting down anyway
+		}()
+	}
+	c.tunnelMgr = tunnel.NewManagerWithTransport(c.remote, c.config.TunnelStartPort)
+	c.portScanner = scanner.NewScannerWithTransport(c.discovery)
+	c.portScanner.SetStrategies(strategies)
+
+	policy := tunnel.DefaultReconnectPolicy()
+	policy.MaxRestarts = c.config.MaxReconnects
+	c.tunnelMgr.SetReconnectPolicy(policy)
+	c.tunnelMgr.SetStateChangeFunc(c.logTunnelState)
+	if c.config.Lazy {
+		c.tunnelMgr.SetLazy(c.config.LazyIdle)
+	}
+	c.tunnelMgr.SetTTL(c.config.TTL)
+	c.tunnelMgr.SetIdleTimeout(c.config.IdleTimeout)
+	if c.config.PortStatePath != "" {
+		stateHost := c.config.Host
+		if stateHost == "" {
+			stateHost = finalServer
+		}
+		store, err := tunnel.LoadPortStore(c.config.PortStatePath, stateHost)
+		if err != nil {
+			fmt.Printf("Warning: local ports will not be remembered: %v\n", err)
+		} else {
+			c.tunnelMgr.SetPortStore(store)
+		}
 	}
 
-	c.tunnelMgr.SetInsecure(c.config.Insecure)
-	c.portScanner.SetInsecure(c.config.Insecure)
+	if len(localForwards) > 0 && c.usesHostAlias() && !c.config.Multiplex {
+		if runtime.GOOS == "windows" {
+			fmt.Println("Warning: every ssh process requests the LocalForward entries of the ssh config, tunnels may fail to start")
+		} else {
+			// A master binds them once and ignores them in later forward requests
+			fmt.Println("Note: ssh config has LocalForward entries, sharing one SSH connection so they are bound once")
+			c.config.Multiplex = true
+		}
+	}
+
+	if c.config.Multiplex && c.config.Backend == BackendNative {
+		fmt.Println("Note: --multiplex is implied by the native SSH backend")
+	} else if c.config.Multiplex {
+		// The shared transport is switched to the control socket, so scanning
+		// and detection reuse the same connection
+		if _, err := c.tunnelMgr.EnableMultiplex(); err != nil {
+			return fmt.Errorf("error enabling connection multiplexing: %v", err)
+		}
+		// Make sure the master does not outlive early returns below
+		defer c.tunnelMgr.CloseAll()
+
+		fmt.Println("Opening shared SSH connection...")
+		if err := c.tunnelMgr.StartMaster(); err != nil {
+			return fmt.Errorf("error opening shared SSH connection: %s", describe(err))
+		}
+	}
 
 	serviceDetector := detector.NewDetector(3 * time.Second)
 
 	var ports []int
+	// listeners is how each scanned port is bound on the server
+	listeners := make(map[int]scanner.Listener)
 	var dockerServices map[int]*detector.DockerService
 	var allContainers []*detector.DockerService
 
 	if c.config.DetectionMode == "docker" || c.config.DetectionMode == "both" {
 		var err error
-		if c.config.Host != "" {
-			dockerServices, err = detector.DetectDockerServices("", "", "", true, c.config.Host, c.config.Insecure)
-		} else {
-			dockerServices, err = detector.DetectDockerServices(finalServer, finalUser, finalKey, false, "", c.config.Insecure)
-		}
-
+		dockerServices, err = detector.DetectDockerServices(c.discovery)
 		if err != nil {
 			if c.config.DetectionMode == "docker" {
-				return fmt.Errorf("docker detection failed: %v", err)
+				return fmt.Errorf("docker detection failed: %s", describe(err))
 			}
-			fmt.Printf("Warning: Docker detection failed: %v\n", err)
+			fmt.Printf("Warning: Docker detection failed: %s\n", describe(err))
 			dockerServices = make(map[int]*detector.DockerService)
 		}
 
 		var err2 error
-		if c.config.Host != "" {
-			allContainers, err2 = detector.GetAllDockerContainers("", "", "", true, c.config.Host, c.config.Insecure)
-		} else {
-			allContainers, err2 = detector.GetAllDockerContainers(finalServer, finalUser, finalKey, false, "", c.config.Insecure)
-		}
-
+		allContainers, err2 = detector.GetAllDockerContainers(c.discovery)
 		if err2 == nil {
 			totalContainers := len(allContainers)
 			accessibleContainers := len(dockerServices)
@@ -157,18 +861,20 @@ func (c *Controller) Run(ctx context.Context) error {
 
 	if c.config.DetectionMode == "direct" || (c.config.DetectionMode == "both" && len(ports) == 0) {
 		fmt.Println("Scanning for open ports...")
-		scannedPorts, err := c.portScanner.ScanPorts(c.config.ScanPorts)
+		scanned, err := c.portScanner.ScanListeners(c.config.ScanPorts)
 		if err != nil {
-			return fmt.Errorf("error scanning ports: %v", err)
+			return fmt.Errorf("error scanning ports: %s", describe(err))
 		}
+		fmt.Printf("Listed %d listening socket(s) with %s\n", len(scanned.Listeners), scanned.Strategy)
 
 		portMap := make(map[int]bool)
 		for _, p := range ports {
 			portMap[p] = true
 		}
-		for _, p := range scannedPorts {
-			if !portMap[p] {
-				ports = append(ports, p)
+		for _, l := range scanner.Preferred(scanned.Listeners) {
+			listeners[l.Port] = l
+			if !portMap[l.Port] {
+				ports = append(ports, l.Port)
 			}
 		}
 	}
@@ -180,26 +886,56 @@ func (c *Controller) Run(ctx context.Context) error {
 
 	fmt.Printf("Found %d port(s) to tunnel: %v\n\n", len(ports), ports)
 
-	fmt.Println("Creating SSH tunnels...")
+	if c.config.Local {
+		fmt.Println("Linking local ports...")
+	} else if c.config.Lazy {
+		fmt.Println("Binding local ports (forwards open on first connection)...")
+	} else {
+		fmt.Println("Creating SSH tunnels...")
+	}
 	localPorts := make(map[int]int)
 	for _, port := range ports {
-		localPort, err := c.tunnelMgr.CreateTunnel(port)
+		// Services bound to one address, such as a private IP, are reached
+		// there instead of on localhost
+		targetHost := listeners[port].TargetHost()
+		if c.config.Local {
+			// The service already listens here, the link is the port itself
+			// on the address it is bound to
+			localPorts[port] = port
+			fmt.Printf("   Direct link: %s\n", directAddress(targetHost, port))
+			continue
+		}
+		localPort, err := c.tunnelMgr.CreateTunnelTo(targetHost, port)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create tunnel for port %d: %v\n", port, err)
+			fmt.Fprintf(os.Stderr, "Failed to create tunnel for port %d: %s\n", port, describe(err))
 			continue
 		}
 		localPorts[port] = localPort
-		fmt.Printf("   Tunnel created: localhost:%d -> %s:%d\n", localPort, finalServer, port)
+		if targetHost != "" {
+			fmt.Printf("   Tunnel created: localhost:%d -> %s on %s (listens on that address only)\n", localPort, listeners[port], finalServer)
+		} else {
+			fmt.Printf("   Tunnel created: localhost:%d -> %s:%d\n", localPort, finalServer, port)
+		}
 	}
 
 	if len(localPorts) == 0 {
 		return fmt.Errorf("failed to create any tunnels")
 	}
 
+	c.applyPortTTLs()
+	c.openConfigForwards(localForwards)
+
+	if !c.config.Local {
+		c.createReverseTunnels(finalServer)
+	}
+	socketServices := c.createSocketTunnels(finalServer)
+
 	fmt.Println()
 
-	fmt.Println("Waiting for tunnels to stabilize...")
-	time.Sleep(2 * time.Second)
+	if !c.config.Lazy && !c.config.Local {
+		fmt.Println("Waiting for tunnels to stabilize...")
+		time.Sleep(2 * time.Second)
+	}
 
 	fmt.Println("Detecting services...")
 
@@ -207,7 +943,12 @@ func (c *Controller) Run(ctx context.Context) error {
 		dockerServices = make(map[int]*detector.DockerService)
 	}
 
+	// HTTP probing would open every lazy forward, so lazy mode relies on Docker metadata only
 	useDirect := c.config.DetectionMode == "direct" || c.config.DetectionMode == "both"
+	if useDirect && c.config.Lazy {
+		fmt.Printf("Note: --lazy skips HTTP probing for --detection-mode %s, services are named from Docker and their processes only\n", c.config.DetectionMode)
+		useDirect = false
+	}
 
 	var services []detector.Service
 	if useDirect {
@@ -218,9 +959,11 @@ func (c *Controller) Run(ctx context.Context) error {
 
 	// Nginx Proxy Manager handling
 	if c.config.DetectionMode == "docker" || c.config.DetectionMode == "both" {
-		c.handleNginxProxy(services, dockerServices, allContainers, localPorts, finalServer, finalUser, finalKey, &services)
+		c.handleNginxProxy(services, dockerServices, allContainers, localPorts, &services)
 	}
 
+	services = append(services, socketServices...)
+
 	fmt.Printf("Detected %d service(s)\n\n", len(services))
 	for i := range services {
 		if services[i].Port > 0 {
@@ -232,29 +975,48 @@ func (c *Controller) Run(ctx context.Context) error {
 		}
 	}
 
+	applyListeners(services, listeners, localPorts, c.config.Local)
+
 	c.dashGen = dashboard.NewGenerator(services)
+	if len(target.jumps) > 0 {
+		c.dashGen.SetRoute(c.route(target))
+	}
 	html, err := c.dashGen.GenerateHTML(localPorts, c.config.TunnelStartPort)
 	if err != nil {
 		return fmt.Errorf("error generating dashboard: %v", err)
 	}
 
-	c.httpServer = server.NewServer(c.config.DashboardPort, services)
-	c.httpServer.SetHTML(html)
+	started := c.httpServer != nil
+	if started {
+		c.httpServer.UpdateServices(services)
+	} else {
+		c.httpServer = c.newHTTPServer(services)
+	}
 	c.httpServer.SetScanner(c.portScanner)
+	c.httpServer.SetTunnelManager(c.tunnelMgr)
+	if c.config.SOCKSPort > 0 {
+		if err := c.tunnelMgr.StartSOCKS(c.config.SOCKSPort); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			c.httpServer.SetSOCKSPort(c.config.SOCKSPort)
+		}
+	}
 	c.httpServer.SetShutdownFunc(func() {
 		fmt.Println("\nShutdown initiated via dashboard...")
 		c.cancel()
 	})
-
-	go func() {
-		if err := c.httpServer.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error starting server: %v\n", err)
-			c.cancel() // Signal shutdown on server error
-		}
-	}()
+	// The loading page reloads once the HTML is set
+	c.httpServer.SetHTML(html)
+	if !started {
+		c.startHTTPServer()
+	}
 
 	fmt.Println(c.dashGen.GenerateCLI(localPorts, c.config.TunnelStartPort))
-	fmt.Printf("Web dashboard available at: http://localhost:%d\n", c.config.DashboardPort)
+	fmt.Printf("Web dashboard available at: %s\n", c.dashboardURL())
+	fmt.Printf("API requests that change state need the header X-Dashboard-Token: %s\n", c.httpServer.Token())
+	if port := c.tunnelMgr.SOCKSPort(); port > 0 {
+		fmt.Printf("SOCKS5 proxy on 127.0.0.1:%d, proxy auto-config at: %s/proxy.pac\n", port, c.dashboardURL())
+	}
 	fmt.Println("\nPress Ctrl+C to stop...")
 
 	<-ctx.Done()
@@ -264,7 +1026,201 @@ func (c *Controller) Run(ctx context.Context) error {
 	return nil
 }
 
-func (c *Controller) handleNginxProxy(services []detector.Service, dockerServices map[int]*detector.DockerService, allContainers []*detector.DockerService, localPorts map[int]int, server, user, key string, servicesPtr *[]detector.Service) {
+// applyListeners records how each scanned service is bound on the server and
+// which process listens, and points those with their own tunnel target at it.
+// In local mode that is the target address itself.
+func applyListeners(services []detector.Service, listeners map[int]scanner.Listener, localPorts map[int]int, local bool) {
+	for i := range services {
+		svc := &services[i]
+		l, ok := listeners[svc.Port]
+		if !ok || svc.Port == 0 || svc.TargetHost != "" {
+			continue
+		}
+		svc.Bind = l.Address
+		svc.BindScope = string(l.Scope)
+		if l.Process != nil {
+			svc.SetProcess(l.Process.String(), l.Process.PID)
+		}
+		if target := l.TargetHost(); target != "" {
+			scheme := "http"
+			if strings.HasPrefix(svc.URL, "https://") {
+				scheme = "https"
+			}
+			svc.TargetHost = target
+			svc.LocalPort = localPorts[svc.Port]
+			switch {
+			case svc.LocalPort > 0 && local:
+				svc.URL = fmt.Sprintf("%s://%s", scheme, directAddress(target, svc.Port))
+			case svc.LocalPort > 0:
+				svc.URL = fmt.Sprintf("%s://localhost:%d", scheme, svc.LocalPort)
+			}
+		}
+	}
+}
+
+// newHTTPServer creates the dashboard server on the configured address.
+func (c *Controller) newHTTPServer(services []detector.Service) *server.Server {
+	srv := server.NewServer(c.config.DashboardPort, services)
+	if c.config.DashboardBind != "" {
+		srv.SetBindAddress(c.config.DashboardBind)
+	}
+	return srv
+}
+
+// dashboardURL is where the dashboard is reached: at --dashboard-bind, or at
+// localhost when that is empty or listens on every address.
+func (c *Controller) dashboardURL() string {
+	host := c.config.DashboardBind
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(c.config.DashboardPort))
+}
+
+// startHTTPServer serves the dashboard in the background, shutting down if
+// the server fails.
+func (c *Controller) startHTTPServer() {
+	go func() {
+		if err := c.httpServer.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting server: %v\n", err)
+			c.cancel() // Signal shutdown on server error
+		}
+	}()
+}
+
+// applyPortTTLs sets the per-tunnel TTLs requested on the command line.
+func (c *Controller) applyPortTTLs() {
+	for _, spec := range c.config.PortTTLs {
+		portStr, durationStr, ok := strings.Cut(spec, "=")
+		port, err := strconv.Atoi(portStr)
+		if !ok || err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping port TTL %q: expected port=duration\n", spec)
+			continue
+		}
+		ttl, err := time.ParseDuration(durationStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping port TTL %q: %v\n", spec, err)
+			continue
+		}
+		count, err := c.tunnelMgr.SetPortTTL(port, ttl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping port TTL %q: %v\n", spec, err)
+			continue
+		}
+		if count > 1 {
+			fmt.Printf("   %d tunnels to port %d close in %v\n", count, port, ttl)
+		} else {
+			fmt.Printf("   Tunnel to port %d closes in %v\n", port, ttl)
+		}
+	}
+}
+
+// openConfigForwards opens the LocalForward entries of the host's ssh config.
+// OpenSSH binds them itself on the shared connection, other backends get a
+// managed tunnel that tries the configured local port first.
+func (c *Controller) openConfigForwards(forwards []sshconfig.LocalForward) {
+	if c.usesHostAlias() && len(forwards) > 0 && (c.config.TTL > 0 || c.config.IdleTimeout > 0) {
+		fmt.Println("   Note: --ttl and --idle-timeout do not close LocalForwards that ssh binds itself")
+	}
+	for _, f := range forwards {
+		if c.usesHostAlias() {
+			fmt.Printf("   LocalForward: localhost:%d -> %s:%d (bound by ssh)\n", f.LocalPort, f.RemoteHost, f.RemotePort)
+			continue
+		}
+
+		localPort, err := c.tunnelMgr.CreateTunnelAt(f.LocalPort, f.RemoteHost, f.RemotePort)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open LocalForward %d -> %s:%d: %s\n", f.LocalPort, f.RemoteHost, f.RemotePort, describe(err))
+			continue
+		}
+		fmt.Printf("   Tunnel created: localhost:%d -> %s:%d (LocalForward)\n", localPort, f.RemoteHost, f.RemotePort)
+	}
+}
+
+// createReverseTunnels opens the reverse tunnels requested on the command line.
+func (c *Controller) createReverseTunnels(server string) {
+	for _, spec := range c.config.ReverseForwards {
+		forward, err := tunnel.ParseReverseForward(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping reverse tunnel: %v\n", err)
+			continue
+		}
+		if err := c.tunnelMgr.CreateReverseTunnel(forward); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create reverse tunnel %s: %s\n", spec, describe(err))
+			continue
+		}
+		bind := forward.BindAddress
+		if bind == "" {
+			bind = "localhost"
+		}
+		fmt.Printf("   Reverse tunnel created: %s:%s:%d -> localhost:%d\n", server, bind, forward.RemotePort, forward.LocalPort)
+	}
+}
+
+// createSocketTunnels forwards the Unix sockets requested on the command line
+// and returns a service for each one.
+func (c *Controller) createSocketTunnels(server string) []detector.Service {
+	var services []detector.Service
+	for _, spec := range c.config.SocketForwards {
+		forward, err := tunnel.ParseSocketForward(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping socket forward: %v\n", err)
+			continue
+		}
+		localPort, err := c.tunnelMgr.CreateSocketTunnel(forward)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to forward socket %s: %s\n", forward.RemoteSocket, describe(err))
+			continue
+		}
+
+		local := forward.LocalSocket
+		if local == "" {
+			local = fmt.Sprintf("localhost:%d", localPort)
+		}
+		fmt.Printf("   Socket forwarded: %s -> %s:%s\n", local, server, forward.RemoteSocket)
+		services = append(services, *detector.IdentifyUnixSocket(forward.RemoteSocket, localPort, forward.LocalSocket))
+	}
+	return services
+}
+
+// logTunnelState reports supervisor state changes on the console.
+func (c *Controller) logTunnelState(status tunnel.Status) {
+	label := fmt.Sprintf("localhost:%d -> :%d", status.LocalPort, status.RemotePort)
+	switch status.Kind {
+	case tunnel.KindReverse:
+		label = fmt.Sprintf(":%d -> localhost:%d (reverse)", status.RemotePort, status.LocalPort)
+	case tunnel.KindSocket:
+		label = fmt.Sprintf("localhost:%d -> %s", status.LocalPort, status.RemoteSocket)
+		if status.LocalSocket != "" {
+			label = fmt.Sprintf("%s -> %s", status.LocalSocket, status.RemoteSocket)
+		}
+	}
+
+	failing := status.State == tunnel.StateReconnecting || status.State == tunnel.StateFailed
+	if failing && status.ErrorKind == transport.KindAuth {
+		c.authFailed()
+	}
+
+	switch status.State {
+	case tunnel.StateReconnecting:
+		fmt.Fprintf(os.Stderr, "Tunnel %s down (%s), reconnecting...\n", label, status.LastError)
+	case tunnel.StateFailed:
+		fmt.Fprintf(os.Stderr, "Tunnel %s failed after %d restart(s): %s\n", label, status.Restarts, status.LastError)
+		if advice := adviceFor(status.ErrorKind, ""); advice != "" {
+			fmt.Fprintf(os.Stderr, "  -> %s\n", advice)
+		}
+	case tunnel.StateExpired:
+		fmt.Printf("   Tunnel %s closed: %s\n", label, status.LastError)
+	case tunnel.StateUp:
+		if status.Restarts == 0 {
+			// Lazy forwards opening on demand are not worth reporting
+			return
+		}
+		fmt.Printf("   Tunnel restored: %s (restart #%d)\n", label, status.Restarts)
+	}
+}
+
+func (c *Controller) handleNginxProxy(services []detector.Service, dockerServices map[int]*detector.DockerService, allContainers []*detector.DockerService, localPorts map[int]int, servicesPtr *[]detector.Service) {
 	hasNginxProxy := false
 	nginxRemotePort := 0
 
@@ -339,12 +1295,7 @@ func (c *Controller) handleNginxProxy(services []detector.Service, dockerService
 			if service != nil {
 				service.Network = container.Network
 				if hasNginxProxy && nginxLocalPort > 0 && nginxContainerName != "" {
-					var domains []string
-					if c.config.Host != "" {
-						domains, _ = detector.QueryNPMDatabase(nginxContainerName, container.ContainerName, container.Port, "", "", "", true, c.config.Host, c.config.Insecure) //nolint:errcheck
-					} else {
-						domains, _ = detector.QueryNPMDatabase(nginxContainerName, container.ContainerName, container.Port, server, user, key, false, "", c.config.Insecure) //nolint:errcheck
-					}
+					domains, _ := detector.QueryNPMDatabase(c.discovery, nginxContainerName, container.ContainerName, container.Port) //nolint:errcheck
 
 					if len(domains) > 0 {
 						domain := domains[0]
@@ -365,38 +1316,78 @@ func (c *Controller) handleNginxProxy(services []detector.Service, dockerService
 				*servicesPtr = append(*servicesPtr, *service)
 			}
 		} else if !container.ExposedToHost {
-			if !servicePortMap[container.Port] {
+			// Containers reachable by IP get their own tunnel, so only dedupe by port without one
+			if container.IPAddress != "" || !servicePortMap[container.Port] {
 				service := detector.IdentifyServiceFromDocker(container)
 				if service != nil {
 					service.Network = container.Network
-					if hasNginxProxy && nginxLocalPort > 0 && nginxContainerName != "" {
-						var domains []string
-						if c.config.Host != "" {
-							domains, _ = detector.QueryNPMDatabase(nginxContainerName, container.ContainerName, container.Port, "", "", "", true, c.config.Host, c.config.Insecure) //nolint:errcheck
-						} else {
-							domains, _ = detector.QueryNPMDatabase(nginxContainerName, container.ContainerName, container.Port, server, user, key, false, "", c.config.Insecure) //nolint:errcheck
-						}
-
-						if len(domains) > 0 {
-							domain := domains[0]
-							service.Port = 0
-							service.URL = fmt.Sprintf("http://localhost:%d", nginxLocalPort)
-							service.Domain = domain
-							service.Description = fmt.Sprintf("%s (Domain: %s)", service.Description, domain)
-						} else {
-							service.Port = 0
-							service.URL = fmt.Sprintf("http://localhost:%d", nginxLocalPort)
-							service.Description = fmt.Sprintf("%s (Accessible via Nginx Proxy Manager)", service.Description)
-						}
+					hasProxy := hasNginxProxy && nginxLocalPort > 0 && nginxContainerName != ""
+
+					var domains []string
+					if hasProxy {
+						domains, _ = detector.QueryNPMDatabase(c.discovery, nginxContainerName, container.ContainerName, container.Port) //nolint:errcheck
+					}
+
+					if len(domains) > 0 {
+						domain := domains[0]
+						service.Port = 0
+						service.URL = fmt.Sprintf("http://localhost:%d", nginxLocalPort)
+						service.Domain = domain
+						service.Description = fmt.Sprintf("%s (Domain: %s)", service.Description, domain)
+					} else if c.config.Local && container.IPAddress != "" {
+						// Container IPs are routed from this machine, so they are linked directly
+						address := directAddress(container.IPAddress, container.Port)
+						service.TargetHost = container.IPAddress
+						service.LocalPort = container.Port
+						service.URL = "http://" + address
+						service.Description = fmt.Sprintf("%s (Container %s, direct)", service.Description, address)
+						fmt.Printf("   Direct link: %s (%s)\n", address, container.ContainerName)
+					} else if localPort, ok := c.tunnelToContainer(container); ok {
+						service.TargetHost = container.IPAddress
+						service.LocalPort = localPort
+						service.URL = fmt.Sprintf("http://localhost:%d", localPort)
+						service.Description = fmt.Sprintf("%s (Container %s:%d via SSH)", service.Description, container.IPAddress, container.Port)
+					} else if hasProxy {
+						service.Port = 0
+						service.URL = fmt.Sprintf("http://localhost:%d", nginxLocalPort)
+						service.Description = fmt.Sprintf("%s (Accessible via Nginx Proxy Manager)", service.Description)
 					} else {
 						service.Port = 0
 						service.URL = ""
 						service.Description = fmt.Sprintf("%s (Container port %d - not exposed to host)", service.Description, container.Port)
 					}
 					*servicesPtr = append(*servicesPtr, *service)
-					servicePortMap[container.Port] = true
+					if container.IPAddress == "" {
+						servicePortMap[container.Port] = true
+					}
 				}
 			}
 		}
 	}
 }
+
+// directAddress is host:port for a service linked without a tunnel, with
+// localhost for an empty host.
+func directAddress(host string, port int) string {
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// tunnelToContainer forwards a local port to a container's own IP for ports
+// that are not published on the host.
+func (c *Controller) tunnelToContainer(container *detector.DockerService) (int, bool) {
+	if container.IPAddress == "" || container.Port == 0 {
+		return 0, false
+	}
+
+	localPort, err := c.tunnelMgr.CreateTunnelTo(container.IPAddress, container.Port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create tunnel to %s (%s:%d): %s\n", container.ContainerName, container.IPAddress, container.Port, describe(err))
+		return 0, false
+	}
+
+	fmt.Printf("   Tunnel created: localhost:%d -> %s:%d (%s)\n", localPort, container.IPAddress, container.Port, container.ContainerName)
+	return localPort, true
+}
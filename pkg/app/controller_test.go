@@ -0,0 +1,253 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/detector"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/scanner"
+)
+
+func TestResolveTargetEscalation(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	sshConfig := `IgnoreUnknown Escalate,EscalateCommands
+Host db
+    HostName db.internal
+    Escalate sudo:postgres
+    EscalateCommands ss,docker
+Host web
+    HostName web.internal
+`
+	if err := os.WriteFile(configPath, []byte(sshConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_CONFIG", configPath)
+
+	tests := []struct {
+		name         string
+		config       Config
+		wantEscalate string
+		wantCommands []string
+	}{
+		{
+			name:         "from ssh config",
+			config:       Config{Host: "db"},
+			wantEscalate: "sudo:postgres",
+			wantCommands: []string{"ss", "docker"},
+		},
+		{
+			name:         "flag overrides method",
+			config:       Config{Host: "db", Escalate: "doas"},
+			wantEscalate: "doas",
+			wantCommands: []string{"ss", "docker"},
+		},
+		{
+			name:         "flag overrides commands",
+			config:       Config{Host: "db", EscalateCommands: []string{"docker"}},
+			wantEscalate: "sudo:postgres",
+			wantCommands: []string{"docker"},
+		},
+		{
+			name:         "flag turns it off",
+			config:       Config{Host: "db", Escalate: "none"},
+			wantEscalate: "none",
+			wantCommands: []string{"ss", "docker"},
+		},
+		{
+			name:   "host without escalation",
+			config: Config{Host: "web"},
+		},
+		{
+			name:         "server without ssh config",
+			config:       Config{ServerAddr: "10.0.0.5", User: "deploy", Escalate: "sudo"},
+			wantEscalate: "sudo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewController(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, _, err := c.resolveTarget()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.escalate != tt.wantEscalate {
+				t.Errorf("escalate = %q, want %q", target.escalate, tt.wantEscalate)
+			}
+			if !reflect.DeepEqual(target.escalateCommands, tt.wantCommands) {
+				t.Errorf("escalateCommands = %v, want %v", target.escalateCommands, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func TestResolveTargetJumps(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	sshConfig := `Host 10.0.3.7 app
+    HostName 10.0.3.7
+    ProxyJump admin@bastion.example.com,inner-bastion
+`
+	if err := os.WriteFile(configPath, []byte(sshConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_CONFIG", configPath)
+
+	tests := []struct {
+		name      string
+		config    Config
+		wantJumps []string
+	}{
+		{
+			name:      "host from ssh config",
+			config:    Config{Host: "app"},
+			wantJumps: []string{"admin@bastion.example.com", "inner-bastion"},
+		},
+		{
+			name:      "server from ssh config",
+			config:    Config{ServerAddr: "10.0.3.7", User: "deploy"},
+			wantJumps: []string{"admin@bastion.example.com", "inner-bastion"},
+		},
+		{
+			name:      "flag replaces ssh config",
+			config:    Config{ServerAddr: "10.0.3.7", User: "deploy", Jumps: []string{"other-bastion"}},
+			wantJumps: []string{"other-bastion"},
+		},
+		{
+			name:   "server without ssh config",
+			config: Config{ServerAddr: "10.0.0.5", User: "deploy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewController(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, _, err := c.resolveTarget()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(target.jumps, tt.wantJumps) {
+				t.Errorf("jumps = %v, want %v", target.jumps, tt.wantJumps)
+			}
+		})
+	}
+}
+
+func TestResolveTargetServerFromSSHConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	sshConfig := `IgnoreUnknown CertCommand,Escalate
+Host 10.0.3.7
+    Port 2222
+    User fromconfig
+    IdentityFile /keys/app
+    IdentityFile /keys/app-old
+    CertCommand step ssh certificate
+    Escalate sudo
+    ProxyJump bastion.example.com
+`
+	if err := os.WriteFile(configPath, []byte(sshConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_CONFIG", configPath)
+
+	c, err := NewController(Config{ServerAddr: "10.0.3.7", User: "deploy", KeyPath: "/keys/cli", Backend: BackendNative})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _, err := c.resolveTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target.server != "10.0.3.7" || target.port != 2222 || target.user != "deploy" {
+		t.Errorf("target = %s@%s:%d, want deploy@10.0.3.7:2222", target.user, target.server, target.port)
+	}
+	if want := []string{"/keys/cli", "/keys/app", "/keys/app-old"}; !reflect.DeepEqual(target.identities, want) {
+		t.Errorf("identities = %v, want %v", target.identities, want)
+	}
+	if target.certCommand != "step ssh certificate" || target.escalate != "sudo" {
+		t.Errorf("certCommand = %q, escalate = %q", target.certCommand, target.escalate)
+	}
+	if len(target.hops) != 1 || target.hops[0].server != "bastion.example.com" {
+		t.Errorf("hops = %+v, want bastion.example.com", target.hops)
+	}
+}
+
+func TestDashboardURL(t *testing.T) {
+	tests := []struct {
+		bind string
+		want string
+	}{
+		{bind: "", want: "http://localhost:8080"},
+		{bind: "127.0.0.1", want: "http://127.0.0.1:8080"},
+		{bind: "0.0.0.0", want: "http://localhost:8080"},
+		{bind: "::", want: "http://localhost:8080"},
+		{bind: "10.0.3.7", want: "http://10.0.3.7:8080"},
+		{bind: "fd00::7", want: "http://[fd00::7]:8080"},
+	}
+
+	for _, tt := range tests {
+		c := &Controller{config: Config{DashboardBind: tt.bind, DashboardPort: 8080}}
+		if got := c.dashboardURL(); got != tt.want {
+			t.Errorf("dashboardURL() with bind %q = %q, want %q", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestApplyListeners(t *testing.T) {
+	listeners := map[int]scanner.Listener{
+		3000: {Address: "0.0.0.0", Port: 3000, Scope: scanner.ScopeWildcard},
+		8080: {Address: "10.0.3.7", Port: 8080, Scope: scanner.ScopeInterface},
+		8443: {Address: "fd00::7", Port: 8443, Scope: scanner.ScopeInterface},
+	}
+
+	tests := []struct {
+		name       string
+		local      bool
+		localPorts map[int]int
+		wantURLs   map[int]string
+	}{
+		{
+			name:       "tunneled",
+			localPorts: map[int]int{3000: 9001, 8080: 9002, 8443: 9003},
+			wantURLs: map[int]string{
+				3000: "http://localhost:3000",
+				8080: "http://localhost:9002",
+				8443: "https://localhost:9003",
+			},
+		},
+		{
+			name:       "local links the bound address",
+			local:      true,
+			localPorts: map[int]int{3000: 3000, 8080: 8080, 8443: 8443},
+			wantURLs: map[int]string{
+				3000: "http://localhost:3000",
+				8080: "http://10.0.3.7:8080",
+				8443: "https://[fd00::7]:8443",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []detector.Service{
+				{Port: 3000, URL: "http://localhost:3000"},
+				{Port: 8080, URL: "http://localhost:8080"},
+				{Port: 8443, URL: "https://localhost:8443"},
+			}
+			applyListeners(services, listeners, tt.localPorts, tt.local)
+			for _, svc := range services {
+				if want := tt.wantURLs[svc.Port]; svc.URL != want {
+					t.Errorf("port %d: URL = %q, want %q", svc.Port, svc.URL, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/detector"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/scanner"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/sshcert"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// Outcomes of a doctor check.
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+// doctorTimeout bounds each remote check.
+const doctorTimeout = 20 * time.Second
+
+// tunnelRangeSize is how many ports from TunnelStartPort the doctor checks.
+const tunnelRangeSize = 100
+
+// remoteTools are the commands the doctor looks for on the server.
+var remoteTools = []struct {
+	name string
+	// use says what the tool is needed for
+	use string
+}{
+	{"ss", "port scanning"},
+	{"netstat", "port scanning when ss is missing"},
+	{"docker", "Docker detection"},
+	{"sqlite3", "Nginx Proxy Manager domains"},
+	{"curl", "checking services from the server"},
+}
+
+// doctor collects and prints check results.
+type doctor struct {
+	failed int
+	// checks are the results in the order they were reported
+	checks []check
+}
+
+// check is the result of one doctor check.
+type check struct {
+	status, name, detail, hint string
+}
+
+func (d *doctor) report(status, name, detail, hint string) {
+	if status == checkFail {
+		d.failed++
+	}
+	d.checks = append(d.checks, check{status: status, name: name, detail: detail, hint: hint})
+	fmt.Printf("[%s] %s: %s\n", status, name, detail)
+	if hint != "" && status != checkPass {
+		fmt.Printf("       -> %s\n", hint)
+	}
+}
+
+// Doctor checks that the server can be reached and has what the dashboard
+// needs, printing PASS, WARN or FAIL with a hint for every problem. It reuses
+// the scanner and Docker detection, so it sees what a real run would, and
+// returns an error when a check failed.
+func (c *Controller) Doctor(ctx context.Context) error {
+	target, _, err := c.resolveTarget()
+	if err != nil {
+		return err
+	}
+	escalation, err := transport.ParseEscalation(target.escalate, target.escalateCommands)
+	if err != nil {
+		return fmt.Errorf("invalid --escalate: %v", err)
+	}
+	strategies, err := scanner.ParseStrategies(c.config.ScanStrategies)
+	if err != nil {
+		return fmt.Errorf("invalid --scan-strategies: %v", err)
+	}
+	if err := c.setupProxy(); err != nil {
+		return err
+	}
+
+	fmt.Println("Zero-Trust Tunnel Dashboard doctor")
+	fmt.Println("===========================================================")
+	fmt.Printf("Server: %s\n", strings.Join(c.route(target), " -> "))
+	if c.proxy != nil {
+		fmt.Printf("Proxy: %s\n", c.proxy)
+	}
+	if escalation != nil {
+		fmt.Printf("Escalation: %s for %s\n", escalation, strings.Join(escalation.Commands, ", "))
+	}
+	fmt.Println()
+
+	d := &doctor{}
+	d.checkLocalPorts(c.config.DashboardBind, c.config.DashboardPort, c.config.TunnelStartPort)
+
+	// The dashboard port must stay free for its own check, so prompts are
+	// asked on the terminal
+	mode := c.config.Askpass
+	if mode == AskpassDashboard {
+		mode = AskpassTerminal
+	}
+	if err := c.startAskpass(mode); err != nil {
+		return err
+	}
+	if c.askpass != nil {
+		defer func() {
+			_ = c.askpass.Close() //nolint:errcheck // Exiting anyway
+		}()
+	}
+
+	if !d.checkCertificates(ctx, c, target) {
+		return d.result()
+	}
+
+	knownHosts, err := c.verifyHostKey(ctx, target)
+	if err != nil {
+		d.report(checkFail, "Host key", err.Error(), adviceFor(transport.KindHostKey, ""))
+		return d.result()
+	}
+
+	remote, err := c.newTransport(target, knownHosts)
+	if err != nil {
+		return err
+	}
+	if closer, ok := remote.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close() //nolint:errcheck // Only used for the checks
+		}()
+	}
+
+	if !d.checkConnection(ctx, remote) {
+		// Every other remote check would fail the same way
+		return d.result()
+	}
+	found := d.checkTools(ctx, remote, strategies)
+	discovery := transport.Escalate(remote, escalation)
+	d.checkProcessNames(discovery, strategies, escalation != nil && escalation.Applies("ss"))
+	if found["docker"] {
+		d.checkDocker(discovery)
+	}
+	d.checkForwarding(ctx, remote, strategies, target.port)
+	return d.result()
+}
+
+func (d *doctor) result() error {
+	fmt.Println()
+	if d.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+	fmt.Println("All required checks passed")
+	return nil
+}
+
+// checkCertificates obtains the certificates of hosts with a cert command.
+// It reports whether the connection checks can go on.
+func (d *doctor) checkCertificates(ctx context.Context, c *Controller, target remoteTarget) bool {
+	hooks, err := c.certHooks(target)
+	if err != nil {
+		d.report(checkFail, "Certificate", err.Error(), "")
+		return false
+	}
+	for _, hook := range hooks {
+		expires, err := hook.Ensure(ctx)
+		if err != nil {
+			d.report(checkFail, "Certificate", err.Error(), "run the cert_command by hand and check that it writes "+sshcert.CertPath(hook.Identity))
+			return false
+		}
+		d.report(checkPass, "Certificate", fmt.Sprintf("%s, %s", hook.Host, validUntil(expires)), "")
+	}
+	return true
+}
+
+// checkLocalPorts makes sure the dashboard port can be bound at
+// dashboardBind, loopback when empty, and the start of the tunnel range on
+// loopback.
+func (d *doctor) checkLocalPorts(dashboardBind string, dashboardPort, tunnelStartPort int) {
+	if dashboardBind == "" {
+		dashboardBind = "127.0.0.1"
+	}
+	dashboardAddr := net.JoinHostPort(dashboardBind, strconv.Itoa(dashboardPort))
+	if err := listenable(dashboardAddr); err != nil {
+		d.report(checkFail, "Dashboard port", fmt.Sprintf("%s is not free: %v", dashboardAddr, err),
+			"Stop the process using it or pick another port with --dashboard-port or address with --dashboard-bind")
+	} else {
+		d.report(checkPass, "Dashboard port", fmt.Sprintf("%s is free", dashboardAddr), "")
+	}
+
+	end := tunnelStartPort + tunnelRangeSize - 1
+	if end > 65535 {
+		end = 65535
+	}
+	var busy []string
+	for port := tunnelStartPort; port <= end; port++ {
+		if port == dashboardPort {
+			continue
+		}
+		if bindable(port) != nil {
+			busy = append(busy, fmt.Sprint(port))
+		}
+	}
+	switch {
+	case len(busy) == 0:
+		d.report(checkPass, "Tunnel ports", fmt.Sprintf("%d-%d are free", tunnelStartPort, end), "")
+	case len(busy) > (end-tunnelStartPort+1)/2:
+		d.report(checkFail, "Tunnel ports", fmt.Sprintf("%d of %d-%d are in use", len(busy), tunnelStartPort, end),
+			"Pick a free range with --tunnel-start-port")
+	default:
+		d.report(checkWarn, "Tunnel ports", fmt.Sprintf("in use, tunnels skip them: %s", strings.Join(busy, ", ")),
+			"Pick a free range with --tunnel-start-port to keep local ports stable")
+	}
+}
+
+// bindable reports why port cannot be listened on locally, or nil.
+func bindable(port int) error {
+	return listenable(transport.LocalAddr(port))
+}
+
+// listenable reports why addr cannot be listened on, or nil.
+func listenable(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// checkConnection logs in and runs a trivial command.
+func (d *doctor) checkConnection(ctx context.Context, remote transport.Transport) bool {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	start := time.Now()
+	output, err := remote.Run(ctx, "echo tunnel-dash")
+	if err != nil {
+		d.report(checkFail, "SSH connection", err.Error(), adviceFor(transport.KindOf(err), ""))
+		return false
+	}
+	if strings.TrimSpace(string(output)) != "tunnel-dash" {
+		d.report(checkWarn, "SSH connection", "the login shell prints extra output",
+			"Move output in the server's shell startup files behind an interactive check, it mixes with command output")
+		return true
+	}
+	d.report(checkPass, "SSH connection", fmt.Sprintf("logged in and ran a command in %s", time.Since(start).Round(time.Millisecond)), "")
+	return true
+}
+
+// checkTools looks for the remote commands the dashboard runs. Scanning
+// needs ss or netstat unless it may fall back to /proc, the others only
+// enable extra detection. It returns the tools that were found.
+func (d *doctor) checkTools(ctx context.Context, remote transport.Transport, strategies []scanner.Strategy) map[string]bool {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	var script strings.Builder
+	for _, tool := range remoteTools {
+		fmt.Fprintf(&script, "command -v %s >/dev/null 2>&1 && echo %s; ", tool.name, tool.name)
+	}
+	output, err := remote.Run(ctx, script.String()+"true")
+	if err != nil {
+		d.report(checkFail, "Remote tools", err.Error(), adviceFor(transport.KindOf(err), ""))
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for _, name := range strings.Fields(string(output)) {
+		found[name] = true
+	}
+
+	if !found["ss"] && !found["netstat"] {
+		if slices.Contains(strategies, scanner.StrategyProc) {
+			d.report(checkWarn, "ss/netstat", "neither is installed, ports are read from /proc/net/tcp", adviceFor(transport.KindNotFound, "ss"))
+		} else {
+			d.report(checkFail, "ss/netstat", "neither is installed, no port scanning", adviceFor(transport.KindNotFound, "ss"))
+		}
+	}
+	for _, tool := range remoteTools {
+		switch {
+		case found[tool.name]:
+			d.report(checkPass, tool.name, "installed", "")
+		case tool.name == "ss" || tool.name == "netstat":
+			// One of the two is enough, both missing was reported above
+		default:
+			d.report(checkWarn, tool.name, "not installed, no "+tool.use, adviceFor(transport.KindNotFound, tool.name))
+		}
+	}
+	return found
+}
+
+// checkProcessNames tells whether the scanner can see which process owns a
+// port, which only root sees for other users' processes. escalated is set
+// when ss already runs through sudo or doas.
+func (d *doctor) checkProcessNames(remote transport.Transport, strategies []scanner.Strategy, escalated bool) {
+	portScanner := scanner.NewScannerWithTransport(remote)
+	portScanner.SetStrategies(strategies)
+	named, total, strategy, err := portScanner.ProcessVisibility()
+	switch {
+	case err != nil:
+		d.report(checkFail, "Listening ports", err.Error(), remoteAdvice(err))
+	case total == 0:
+		d.report(checkWarn, "Listening ports", "no listening TCP ports found", "Start the services you want to reach, or check that ss/netstat can list them")
+	case named < total && escalated:
+		d.report(checkWarn, "Process names", fmt.Sprintf("visible for %d of %d listening ports listed with %s", named, total, strategy),
+			"The escalated user cannot see every process either, escalate to root instead")
+	case named < total:
+		d.report(checkWarn, "Process names", fmt.Sprintf("visible for %d of %d listening ports listed with %s", named, total, strategy),
+			"Processes of other users are hidden from the SSH user; services are still found but may be named by port only. Use --escalate sudo if it may run ss without a password")
+	default:
+		d.report(checkPass, "Process names", fmt.Sprintf("visible for all %d listening ports listed with %s", total, strategy), "")
+	}
+}
+
+// checkDocker runs the Docker detection the dashboard uses.
+func (d *doctor) checkDocker(remote transport.Transport) {
+	services, err := detector.DetectDockerServices(remote)
+	if err != nil {
+		hint := remoteAdvice(err)
+		if hint == "" {
+			hint = "Check that the Docker daemon is running, or use --detection-mode direct to skip Docker"
+		}
+		d.report(checkWarn, "Docker", err.Error(), hint)
+		return
+	}
+	d.report(checkPass, "Docker", fmt.Sprintf("socket accessible, %d container port(s) published", len(services)), "")
+}
+
+// checkForwarding opens a local forward to a port the server listens on
+// and connects through it, which only works when AllowTcpForwarding permits
+// forwards. The server's sshd is tried first.
+func (d *doctor) checkForwarding(ctx context.Context, remote transport.Transport, strategies []scanner.Strategy, sshPort int) {
+	const name = "Port forwarding"
+	hint := adviceFor(transport.KindForwarding, "")
+
+	remotePort, listening := forwardProbePort(remote, strategies, sshPort)
+	port, err := freeLocalPort()
+	if err != nil {
+		d.report(checkWarn, name, fmt.Sprintf("no free local port to test with: %v", err), "")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	handle, err := remote.Forward(ctx, transport.Forward{
+		Kind:       transport.ForwardLocal,
+		LocalPort:  port,
+		RemoteHost: "localhost",
+		RemotePort: remotePort,
+	})
+	if err != nil {
+		d.report(checkFail, name, err.Error(), remoteAdvice(err))
+		return
+	}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- handle.Wait()
+	}()
+	defer func() {
+		cancel()
+		<-ended
+	}()
+
+	err = probeForward(ctx, port, ended)
+	var remoteErr *transport.Error
+	switch {
+	case err == nil:
+		d.report(checkPass, name, fmt.Sprintf("forwarded to localhost:%d on the server", remotePort), "")
+	case errors.As(err, &remoteErr) && remoteErr.Kind != transport.KindForwarding:
+		d.report(checkFail, name, err.Error(), remoteAdvice(err))
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		d.report(checkWarn, name, fmt.Sprintf("could not test: %v", err), fmt.Sprintf("Check that something listens on localhost:%d on the server", remotePort))
+	case !listening:
+		// A refused connection to localhost looks the same as a refused forward
+		d.report(checkWarn, name, fmt.Sprintf("the server closed the forwarded connection to localhost:%d, which may not be listening", remotePort),
+			"If ports are listening on the server, "+hint)
+	default:
+		d.report(checkFail, name, fmt.Sprintf("the server closed the forwarded connection to localhost:%d, which is listening", remotePort), hint)
+	}
+}
+
+// forwardProbePort returns the port checkForwarding connects to: sshPort
+// when the server accepts connections to it on localhost, otherwise the
+// first port that does. listening is false when the scan found none and
+// sshPort is tried anyway.
+func forwardProbePort(remote transport.Transport, strategies []scanner.Strategy, sshPort int) (port int, listening bool) {
+	portScanner := scanner.NewScannerWithTransport(remote)
+	portScanner.SetStrategies(strategies)
+	result, err := portScanner.ScanListeners("")
+	if err != nil {
+		return sshPort, false
+	}
+
+	for _, l := range scanner.Preferred(result.Listeners) {
+		if l.TargetHost() != "" {
+			continue
+		}
+		if l.Port == sshPort {
+			return sshPort, true
+		}
+		if port == 0 {
+			port = l.Port
+		}
+	}
+	if port == 0 {
+		return sshPort, false
+	}
+	return port, true
+}
+
+// freeLocalPort returns a port the forward test can listen on.
+func freeLocalPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	return port, ln.Close()
+}
+
+// probeForward connects to the local end of the test forward, retrying
+// while ssh is still starting. It returns nil when the connection stays open
+// or the service greets, like sshd does, and an error when the server closes
+// it. It gives up when the forward ends, returning why.
+func probeForward(ctx context.Context, port int, ended chan error) error {
+	addr := transport.LocalAddr(port)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			// Services such as HTTP wait for the client to speak first
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second)) //nolint:errcheck // Best-effort
+			_, err = conn.Read(make([]byte, 1))
+			_ = conn.Close() //nolint:errcheck // Only probed
+			var netErr net.Error
+			if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case waitErr := <-ended:
+			ended <- waitErr
+			if waitErr == nil {
+				waitErr = errors.New("forward stopped")
+			}
+			return waitErr
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
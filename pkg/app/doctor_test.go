@@ -0,0 +1,426 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/scanner"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport/transporttest"
+)
+
+// wantCheck is the expected status and hint of a named check.
+type wantCheck struct {
+	status, hint string
+}
+
+// assertChecks compares the reported checks with want, by name.
+func assertChecks(t *testing.T, d *doctor, want map[string]wantCheck) {
+	t.Helper()
+	got := make(map[string]check)
+	for _, c := range d.checks {
+		got[c.name] = c
+	}
+	for name, w := range want {
+		c, ok := got[name]
+		if !ok {
+			t.Errorf("%s: not reported, got %v", name, d.checks)
+			continue
+		}
+		if c.status != w.status {
+			t.Errorf("%s: status = %s (%s), want %s", name, c.status, c.detail, w.status)
+		}
+		if c.hint != w.hint {
+			t.Errorf("%s: hint = %q, want %q", name, c.hint, w.hint)
+		}
+	}
+}
+
+// listen occupies a local port until the test ends.
+func listen(t *testing.T) (net.Listener, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close() //nolint:errcheck // Test cleanup
+	})
+	return ln, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestCheckLocalPorts(t *testing.T) {
+	_, busy := listen(t)
+	free, err := freeLocalPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wildcard, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = wildcard.Close() //nolint:errcheck // Test cleanup
+	}()
+	busyWildcard := wildcard.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name            string
+		dashboardBind   string
+		dashboardPort   int
+		tunnelStartPort int
+		want            map[string]wantCheck
+	}{
+		{
+			name:            "busy dashboard port",
+			dashboardPort:   busy,
+			tunnelStartPort: free,
+			want: map[string]wantCheck{
+				"Dashboard port": {checkFail, "Stop the process using it or pick another port with --dashboard-port or address with --dashboard-bind"},
+			},
+		},
+		{
+			name:            "busy dashboard port at the bind address",
+			dashboardBind:   "0.0.0.0",
+			dashboardPort:   busyWildcard,
+			tunnelStartPort: free,
+			want: map[string]wantCheck{
+				"Dashboard port": {checkFail, "Stop the process using it or pick another port with --dashboard-port or address with --dashboard-bind"},
+			},
+		},
+		{
+			name:            "busy tunnel port",
+			dashboardPort:   free,
+			tunnelStartPort: busy,
+			want: map[string]wantCheck{
+				"Dashboard port": {checkPass, ""},
+				"Tunnel ports":   {checkWarn, "Pick a free range with --tunnel-start-port to keep local ports stable"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &doctor{}
+			d.checkLocalPorts(tt.dashboardBind, tt.dashboardPort, tt.tunnelStartPort)
+			assertChecks(t, d, tt.want)
+		})
+	}
+}
+
+func TestCheckTools(t *testing.T) {
+	tests := []struct {
+		name       string
+		installed  string
+		strategies []scanner.Strategy
+		want       map[string]wantCheck
+	}{
+		{
+			name:       "all installed",
+			installed:  "ss\nnetstat\ndocker\nsqlite3\ncurl\n",
+			strategies: scanner.DefaultStrategies,
+			want: map[string]wantCheck{
+				"ss":     {checkPass, ""},
+				"docker": {checkPass, ""},
+			},
+		},
+		{
+			name:       "missing docker",
+			installed:  "ss\nsqlite3\ncurl\n",
+			strategies: scanner.DefaultStrategies,
+			want: map[string]wantCheck{
+				"ss":     {checkPass, ""},
+				"docker": {checkWarn, adviceFor(transport.KindNotFound, "docker")},
+			},
+		},
+		{
+			name:       "missing ss with proc fallback",
+			installed:  "docker\n",
+			strategies: scanner.DefaultStrategies,
+			want: map[string]wantCheck{
+				"ss/netstat": {checkWarn, adviceFor(transport.KindNotFound, "ss")},
+				"docker":     {checkPass, ""},
+			},
+		},
+		{
+			name:       "missing ss without proc fallback",
+			installed:  "docker\n",
+			strategies: []scanner.Strategy{scanner.StrategySS, scanner.StrategyNetstat},
+			want: map[string]wantCheck{
+				"ss/netstat": {checkFail, adviceFor(transport.KindNotFound, "ss")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The lookups run as one "command -v ..." script
+			remote := transporttest.Fake{"command": tt.installed}
+
+			d := &doctor{}
+			d.checkTools(context.Background(), remote, tt.strategies)
+			assertChecks(t, d, tt.want)
+		})
+	}
+}
+
+func TestCheckProcessNames(t *testing.T) {
+	const named = "tcp 0 0 127.0.0.1:5432 0.0.0.0:* LISTEN 456/postgres\n"
+	const hidden = "tcp 0 0 0.0.0.0:22 0.0.0.0:* LISTEN -\n"
+
+	tests := []struct {
+		name      string
+		netstat   string
+		escalated bool
+		want      wantCheck
+	}{
+		{
+			name:    "all visible",
+			netstat: named,
+			want:    wantCheck{checkPass, ""},
+		},
+		{
+			name:    "hidden process names",
+			netstat: named + hidden,
+			want: wantCheck{checkWarn, "Processes of other users are hidden from the SSH user; services are still found but may be named by port only. " +
+				"Use --escalate sudo if it may run ss without a password"},
+		},
+		{
+			name:      "hidden from the escalated user",
+			netstat:   named + hidden,
+			escalated: true,
+			want:      wantCheck{checkWarn, "The escalated user cannot see every process either, escalate to root instead"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := transporttest.Fake{"netstat -tlnp": tt.netstat}
+
+			d := &doctor{}
+			d.checkProcessNames(remote, scanner.DefaultStrategies, tt.escalated)
+			assertChecks(t, d, map[string]wantCheck{"Process names": tt.want})
+		})
+	}
+}
+
+func TestForwardProbePort(t *testing.T) {
+	tests := []struct {
+		name          string
+		remote        transporttest.Fake
+		sshPort       int
+		wantPort      int
+		wantListening bool
+	}{
+		{
+			name:          "sshd",
+			remote:        transporttest.Fake{"netstat -tlnp": "tcp 0 0 127.0.0.1:5432 0.0.0.0:* LISTEN -\ntcp 0 0 0.0.0.0:22 0.0.0.0:* LISTEN -\n"},
+			sshPort:       22,
+			wantPort:      22,
+			wantListening: true,
+		},
+		{
+			name:          "sshd on another port",
+			remote:        transporttest.Fake{"netstat -tlnp": "tcp 0 0 0.0.0.0:2222 0.0.0.0:* LISTEN -\n"},
+			sshPort:       2222,
+			wantPort:      2222,
+			wantListening: true,
+		},
+		{
+			name:          "sshd not listed",
+			remote:        transporttest.Fake{"netstat -tlnp": "tcp 0 0 127.0.0.1:5432 0.0.0.0:* LISTEN -\n"},
+			sshPort:       22,
+			wantPort:      5432,
+			wantListening: true,
+		},
+		{
+			name:     "only bound to another address",
+			remote:   transporttest.Fake{"netstat -tlnp": "tcp 0 0 10.0.0.5:80 0.0.0.0:* LISTEN -\n"},
+			sshPort:  22,
+			wantPort: 22,
+		},
+		{
+			name:     "scan fails",
+			remote:   transporttest.Fake{},
+			sshPort:  22,
+			wantPort: 22,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, listening := forwardProbePort(tt.remote, scanner.DefaultStrategies, tt.sshPort)
+			if port != tt.wantPort || listening != tt.wantListening {
+				t.Errorf("forwardProbePort() = %d, %v, want %d, %v", port, listening, tt.wantPort, tt.wantListening)
+			}
+		})
+	}
+}
+
+// forwardingFake is a transporttest.Fake whose forwards listen on their
+// local port and hand each connection to serve, like a forward to a server
+// that does so.
+type forwardingFake struct {
+	transporttest.Fake
+	serve func(net.Conn)
+	// err fails the forward when set
+	err error
+}
+
+func (f forwardingFake) Forward(ctx context.Context, fwd transport.Forward) (transport.Handle, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", fwd.LocalPort))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.serve(conn)
+		}
+	}()
+	return listenerHandle{ctx: ctx, ln: ln}, nil
+}
+
+type listenerHandle struct {
+	ctx context.Context
+	ln  net.Listener
+}
+
+func (h listenerHandle) Wait() error {
+	<-h.ctx.Done()
+	return h.ln.Close()
+}
+
+// greet answers like sshd does.
+func greet(conn net.Conn) {
+	_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n")) //nolint:errcheck // Test server
+	_ = conn.Close()                                     //nolint:errcheck // Test server
+}
+
+// refuse closes the connection, like sshd does for a forward it does not
+// permit.
+func refuse(conn net.Conn) {
+	_ = conn.Close() //nolint:errcheck // Test server
+}
+
+func TestCheckForwarding(t *testing.T) {
+	const sshd = "tcp 0 0 0.0.0.0:22 0.0.0.0:* LISTEN -\n"
+	hint := adviceFor(transport.KindForwarding, "")
+	denied := &transport.Error{
+		Kind:    transport.KindForwarding,
+		Command: "ssh -N -L",
+		Stderr:  "channel 2: open failed: administratively prohibited: open failed",
+		Err:     errors.New("exit status 255"),
+	}
+
+	tests := []struct {
+		name   string
+		remote forwardingFake
+		want   wantCheck
+	}{
+		{
+			name:   "forwarded",
+			remote: forwardingFake{Fake: transporttest.Fake{"netstat -tlnp": sshd}, serve: greet},
+			want:   wantCheck{checkPass, ""},
+		},
+		{
+			name:   "refused forward",
+			remote: forwardingFake{Fake: transporttest.Fake{"netstat -tlnp": sshd}, serve: refuse},
+			want:   wantCheck{checkFail, hint},
+		},
+		{
+			name:   "refused with nothing listening",
+			remote: forwardingFake{Fake: transporttest.Fake{}, serve: refuse},
+			want:   wantCheck{checkWarn, "If ports are listening on the server, " + hint},
+		},
+		{
+			name:   "forward fails",
+			remote: forwardingFake{Fake: transporttest.Fake{"netstat -tlnp": sshd}, err: denied},
+			want:   wantCheck{checkFail, hint},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &doctor{}
+			d.checkForwarding(context.Background(), tt.remote, scanner.DefaultStrategies, 22)
+			assertChecks(t, d, map[string]wantCheck{"Port forwarding": tt.want})
+		})
+	}
+}
+
+func TestProbeForward(t *testing.T) {
+	stopped := errors.New("connection lost")
+
+	tests := []struct {
+		name string
+		// serve answers on the forwarded port, nil for none
+		serve   func(net.Conn)
+		ended   error
+		wantErr error
+		fails   bool
+	}{
+		{
+			name:  "service greets",
+			serve: greet,
+		},
+		{
+			name:  "server closes the connection",
+			serve: refuse,
+			fails: true,
+		},
+		{
+			name:    "forward ended",
+			ended:   stopped,
+			wantErr: stopped,
+			fails:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ended := make(chan error, 1)
+			var port int
+			if tt.serve != nil {
+				var ln net.Listener
+				ln, port = listen(t)
+				go func() {
+					for {
+						conn, err := ln.Accept()
+						if err != nil {
+							return
+						}
+						tt.serve(conn)
+					}
+				}()
+			} else {
+				var err error
+				if port, err = freeLocalPort(); err != nil {
+					t.Fatal(err)
+				}
+				ended <- tt.ended
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := probeForward(ctx, port, ended)
+			if (err != nil) != tt.fails {
+				t.Fatalf("probeForward() error = %v, want failure %v", err, tt.fails)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("probeForward() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+// Package askpass answers the prompts of ssh processes, such as key
+// passphrases, passwords and one-time codes. The running binary serves as
+// SSH_ASKPASS: ssh starts it with the prompt, and it hands the prompt over a
+// private socket to the Bridge in the main process, which asks the user once
+// and remembers the answer for the rest of the session.
+package askpass
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Environment variables that carry the bridge to the helper process.
+const (
+	// SocketEnv is the Unix socket of the bridge; its presence makes the
+	// binary act as the askpass helper
+	SocketEnv = "TUNNEL_DASH_ASKPASS"
+	// TokenEnv authenticates the helper to the bridge
+	TokenEnv = "TUNNEL_DASH_ASKPASS_TOKEN"
+)
+
+// ErrCanceled is returned when the user dismissed a prompt.
+var ErrCanceled = errors.New("prompt canceled")
+
+// Request is a prompt to answer.
+type Request struct {
+	Prompt string `json:"prompt"`
+	// Echo is set when the answer is not secret, like a user name
+	Echo bool `json:"echo"`
+	// Confirm is set for yes/no questions such as unknown host keys,
+	// which are never answered from the cache
+	Confirm bool `json:"confirm"`
+}
+
+// Prompter asks the user.
+type Prompter interface {
+	Prompt(ctx context.Context, req Request) (string, error)
+}
+
+// Bridge answers prompts from the cache or by asking its Prompter. Prompts
+// are asked one at a time, so parallel ssh processes that need the same
+// passphrase wait for a single answer.
+type Bridge struct {
+	prompter Prompter
+
+	// askMu serializes questions to the user
+	askMu sync.Mutex
+
+	mu    sync.Mutex
+	cache map[string]string
+	// served records which requesters got a cached answer; asking again
+	// means the answer was wrong
+	served map[string]map[int]bool
+
+	ln    net.Listener
+	dir   string
+	token string
+}
+
+// NewBridge creates a bridge that asks p.
+func NewBridge(p Prompter) *Bridge {
+	return &Bridge{
+		prompter: p,
+		cache:    make(map[string]string),
+		served:   make(map[string]map[int]bool),
+	}
+}
+
+// Ask answers req, from the cache when possible. requester identifies the
+// asking process, 0 when unknown; a requester that asks the same prompt
+// twice is asked again instead of getting the same cached answer.
+func (b *Bridge) Ask(ctx context.Context, req Request, requester int) (string, error) {
+	if req.Confirm {
+		b.askMu.Lock()
+		defer b.askMu.Unlock()
+		return b.prompter.Prompt(ctx, req)
+	}
+
+	if answer, ok := b.cached(req.Prompt, requester); ok {
+		return answer, nil
+	}
+
+	b.askMu.Lock()
+	defer b.askMu.Unlock()
+	// Another request may have been answered while this one waited
+	if answer, ok := b.cached(req.Prompt, requester); ok {
+		return answer, nil
+	}
+
+	answer, err := b.prompter.Prompt(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	b.cache[req.Prompt] = answer
+	b.served[req.Prompt] = map[int]bool{requester: requester != 0}
+	b.mu.Unlock()
+	return answer, nil
+}
+
+// cached returns the remembered answer to prompt, dropping it when
+// requester already received it once.
+func (b *Bridge) cached(prompt string, requester int) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	answer, ok := b.cache[prompt]
+	if !ok {
+		return "", false
+	}
+	if requester != 0 {
+		if b.served[prompt][requester] {
+			delete(b.cache, prompt)
+			delete(b.served, prompt)
+			return "", false
+		}
+		b.served[prompt][requester] = true
+	}
+	return answer, true
+}
+
+// Forget drops every cached answer, e.g. after an authentication failure.
+func (b *Bridge) Forget() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cache = make(map[string]string)
+	b.served = make(map[string]map[int]bool)
+}
+
+// Listen starts serving helper processes on a Unix socket in a private
+// temporary directory.
+func (b *Bridge) Listen() error {
+	dir, err := os.MkdirTemp("", "tunnel-dash-askpass-")
+	if err != nil {
+		return fmt.Errorf("failed to create askpass directory: %w", err)
+	}
+	ln, err := net.Listen("unix", filepath.Join(dir, "askpass.sock"))
+	if err != nil {
+		_ = os.RemoveAll(dir) //nolint:errcheck // Already failing
+		return fmt.Errorf("failed to listen for askpass requests: %w", err)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		_ = ln.Close()        //nolint:errcheck // Already failing
+		_ = os.RemoveAll(dir) //nolint:errcheck // Already failing
+		return fmt.Errorf("failed to create askpass token: %w", err)
+	}
+
+	b.ln, b.dir, b.token = ln, dir, hex.EncodeToString(token)
+	go b.serve()
+	return nil
+}
+
+// Env returns the environment that makes ssh use the helper. helper is the
+// path of this binary.
+func (b *Bridge) Env(helper string) []string {
+	return []string{
+		"SSH_ASKPASS=" + helper,
+		// OpenSSH 8.4+ uses the helper even when there is a terminal
+		"SSH_ASKPASS_REQUIRE=force",
+		SocketEnv + "=" + b.ln.Addr().String(),
+		TokenEnv + "=" + b.token,
+	}
+}
+
+// Close stops serving and removes the socket.
+func (b *Bridge) Close() error {
+	if b.ln == nil {
+		return nil
+	}
+	err := b.ln.Close()
+	_ = os.RemoveAll(b.dir) //nolint:errcheck // Temporary directory
+	return err
+}
+
+// message is sent by the helper, one JSON line per connection.
+type message struct {
+	Token string `json:"token"`
+	// PID is the helper's parent, the ssh process asking
+	PID int `json:"pid"`
+	Request
+}
+
+// reply is the bridge's answer.
+type reply struct {
+	Answer string `json:"answer,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (b *Bridge) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *Bridge) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close() //nolint:errcheck // One request per connection
+	}()
+
+	var msg message
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&msg); err != nil {
+		return
+	}
+	if msg.Token != b.token {
+		_ = json.NewEncoder(conn).Encode(reply{Error: "invalid token"}) //nolint:errcheck // Helper fails either way
+		return
+	}
+
+	// The helper sends nothing more, so a read that returns means it exited,
+	// for example because ssh timed out; drop its prompt then
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = io.Copy(io.Discard, conn) //nolint:errcheck // Any end means the helper is gone
+		cancel()
+	}()
+
+	answer, err := b.Ask(ctx, msg.Request, msg.PID)
+	if err != nil {
+		_ = json.NewEncoder(conn).Encode(reply{Error: err.Error()}) //nolint:errcheck // Helper fails either way
+		return
+	}
+	_ = json.NewEncoder(conn).Encode(reply{Answer: answer}) //nolint:errcheck // Helper fails either way
+}
+
+// RunHelper is the askpass helper: it sends prompt to the bridge at socket
+// and prints the answer for ssh. It returns the exit code, non-zero when
+// there is no answer. kind is SSH_ASKPASS_PROMPT as set by ssh: "confirm"
+// for yes/no questions and "none" for notices that need no answer.
+func RunHelper(socket, token, prompt, kind string) int {
+	if kind == "none" {
+		return 0
+	}
+
+	req := Request{Prompt: prompt, Confirm: kind == "confirm" || isConfirmation(prompt)}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel-dash askpass: %v\n", err)
+		return 1
+	}
+	defer func() {
+		_ = conn.Close() //nolint:errcheck // Exiting anyway
+	}()
+
+	if err := json.NewEncoder(conn).Encode(message{Token: token, PID: os.Getppid(), Request: req}); err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel-dash askpass: %v\n", err)
+		return 1
+	}
+	var r reply
+	if err := json.NewDecoder(conn).Decode(&r); err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel-dash askpass: %v\n", err)
+		return 1
+	}
+	if r.Error != "" {
+		fmt.Fprintf(os.Stderr, "tunnel-dash askpass: %s\n", r.Error)
+		return 1
+	}
+	fmt.Println(r.Answer)
+	return 0
+}
+
+// isConfirmation reports whether an OpenSSH prompt is a yes/no question.
+// Older versions do not set SSH_ASKPASS_PROMPT for these.
+func isConfirmation(prompt string) bool {
+	return strings.Contains(prompt, "(yes/no")
+}
@@ -0,0 +1,224 @@
+package askpass
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingPrompter answers every prompt with "answer-N".
+type countingPrompter struct {
+	asked int
+}
+
+func (p *countingPrompter) Prompt(_ context.Context, _ Request) (string, error) {
+	p.asked++
+	return "answer-" + string(rune('0'+p.asked)), nil
+}
+
+func TestBridgeCache(t *testing.T) {
+	p := &countingPrompter{}
+	b := NewBridge(p)
+	ctx := context.Background()
+	passphrase := Request{Prompt: "Enter passphrase for key '/home/me/.ssh/id_ed25519': "}
+
+	tests := []struct {
+		name      string
+		req       Request
+		requester int
+		want      string
+		wantAsked int
+	}{
+		{"first process asks", passphrase, 100, "answer-1", 1},
+		{"second process is answered from the cache", passphrase, 200, "answer-1", 1},
+		{"repeated prompt means the answer was wrong", passphrase, 100, "answer-2", 2},
+		{"confirmations are never cached", Request{Prompt: "Are you sure (yes/no)? ", Confirm: true}, 300, "answer-3", 3},
+	}
+
+	for _, tt := range tests {
+		got, err := b.Ask(ctx, tt.req, tt.requester)
+		if err != nil {
+			t.Fatalf("%s: Ask() error = %v", tt.name, err)
+		}
+		if got != tt.want || p.asked != tt.wantAsked {
+			t.Errorf("%s: Ask() = %q after %d prompt(s), want %q after %d", tt.name, got, p.asked, tt.want, tt.wantAsked)
+		}
+	}
+
+	b.Forget()
+	if got, _ := b.Ask(ctx, passphrase, 400); got != "answer-4" {
+		t.Errorf("expected a new prompt after Forget(), got %q", got)
+	}
+}
+
+func TestHelperRoundTrip(t *testing.T) {
+	queue := NewQueue()
+	b := NewBridge(queue)
+	if err := b.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	env := make(map[string]string)
+	for _, kv := range b.Env("/usr/local/bin/tunnel-dash") {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+
+	// Answer from the "dashboard" once the prompt shows up
+	go func() {
+		for i := 0; i < 200; i++ {
+			if pending := queue.Pending(); len(pending) > 0 {
+				_ = queue.Answer(pending[0].ID, "123456", false)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	code := RunHelper(env[SocketEnv], env[TokenEnv], "Verification code: ", "")
+	os.Stdout = stdout
+	w.Close()
+	output, _ := io.ReadAll(r)
+
+	if code != 0 || string(output) != "123456\n" {
+		t.Errorf("RunHelper() = %d with output %q, want 0 and the answer", code, output)
+	}
+
+	if code := RunHelper(env[SocketEnv], "wrong-token", "Password: ", ""); code == 0 {
+		t.Error("expected the helper to fail with a wrong token")
+	}
+}
+
+func TestPromptDroppedWhenHelperExits(t *testing.T) {
+	queue := NewQueue()
+	b := NewBridge(queue)
+	if err := b.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	env := make(map[string]string)
+	for _, kv := range b.Env("/usr/local/bin/tunnel-dash") {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+
+	// A helper that gives up, like one whose ssh process timed out
+	conn, err := net.Dial("unix", env[SocketEnv])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message{Token: env[TokenEnv], PID: 100, Request: Request{Prompt: "Password: "}}
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the prompt", func() bool { return len(queue.Pending()) == 1 })
+	conn.Close()
+	waitFor(t, "the prompt to be dropped", func() bool { return len(queue.Pending()) == 0 })
+
+	// Later prompts are not blocked behind the dropped one
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Ask(context.Background(), Request{Prompt: "Verification code: "}, 200)
+		done <- err
+	}()
+	waitFor(t, "the next prompt", func() bool { return len(queue.Pending()) == 1 })
+	if err := queue.Answer(queue.Pending()[0].ID, "123456", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Ask() error = %v", err)
+	}
+}
+
+// waitFor fails the test unless cond holds within two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestQueueCancel(t *testing.T) {
+	queue := NewQueue()
+	done := make(chan error, 1)
+	go func() {
+		_, err := queue.Prompt(context.Background(), Request{Prompt: "Password: "})
+		done <- err
+	}()
+
+	for len(queue.Pending()) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := queue.Answer(queue.Pending()[0].ID, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, ErrCanceled) {
+		t.Errorf("Prompt() error = %v, want ErrCanceled", err)
+	}
+	if err := queue.Answer("missing", "x", false); err == nil {
+		t.Error("expected an error for an unknown prompt")
+	}
+}
+
+func TestTerminalCanceledConfirmBeforeSecret(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	stdin := bufio.NewReader(r)
+	var mu sync.Mutex
+	var modes []bool
+	term := &Terminal{
+		isTerminal: func() bool { return true },
+		readLine: func(secret bool) (string, error) {
+			mu.Lock()
+			modes = append(modes, secret)
+			mu.Unlock()
+			answer, err := stdin.ReadString('\n')
+			return strings.TrimRight(answer, "\n"), err
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	confirm := Request{Prompt: "Are you sure you want to continue connecting (yes/no)? ", Confirm: true}
+	if _, err := term.Prompt(ctx, confirm); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Prompt() error = %v, want DeadlineExceeded", err)
+	}
+
+	// The first line finishes the canceled confirm's read and is thrown away
+	go func() {
+		io.WriteString(w, "yes\nhunter2\n") //nolint:errcheck // the pipe stays open
+	}()
+	answer, err := term.Prompt(context.Background(), Request{Prompt: "Enter passphrase: "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "hunter2" {
+		t.Errorf("Prompt() = %q, want %q", answer, "hunter2")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []bool{false, true}; !reflect.DeepEqual(modes, want) {
+		t.Errorf("reads were secret %v, want %v", modes, want)
+	}
+}
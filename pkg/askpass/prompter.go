@@ -0,0 +1,220 @@
+package askpass
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Terminal asks on the controlling terminal, without echo for secrets.
+//
+// One goroutine owns stdin for the life of the process and reads a line at
+// a time. A read outlives the prompt it was started for when that prompt is
+// canceled, so the next prompt has the user press Enter to finish it and
+// throws its line away, instead of reading its own answer with the wrong
+// echo mode or handing the line to a different prompt.
+type Terminal struct {
+	mu    sync.Mutex
+	once  sync.Once
+	reads chan *read
+	lines chan line
+	// stale is a read left behind by a canceled prompt
+	stale *read
+
+	// isTerminal and readLine default to stdin and are replaced in tests
+	isTerminal func() bool
+	readLine   func(secret bool) (string, error)
+}
+
+// read is one line requested from the stdin goroutine for a prompt.
+type read struct {
+	secret bool
+}
+
+// line is the answer to a read.
+type line struct {
+	read   *read
+	answer string
+	err    error
+}
+
+// ErrNoTerminal is returned by Terminal when stdin is not a terminal.
+var ErrNoTerminal = errors.New("no terminal to ask on")
+
+// Prompt prints req on stderr and waits for the line typed on the terminal
+// in answer, read without echo unless req is echoed or a confirmation.
+func (t *Terminal) Prompt(ctx context.Context, req Request) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.once.Do(t.start)
+
+	if !t.isTerminal() {
+		return "", fmt.Errorf("%w: %s", ErrNoTerminal, strings.TrimSpace(req.Prompt))
+	}
+
+	if t.stale != nil {
+		fmt.Fprint(os.Stderr, "\nThe previous prompt was canceled, press Enter to continue: ")
+		if _, err := t.wait(ctx, t.stale); err != nil {
+			return "", err
+		}
+	}
+
+	fmt.Fprint(os.Stderr, req.Prompt)
+	r := &read{secret: !req.Echo && !req.Confirm}
+	t.reads <- r
+	return t.wait(ctx, r)
+}
+
+// wait returns the answer to r, discarding lines read for other prompts.
+// When ctx ends first, r is left as the stale read.
+func (t *Terminal) wait(ctx context.Context, r *read) (string, error) {
+	for {
+		select {
+		case l := <-t.lines:
+			if l.read != r {
+				continue
+			}
+			t.stale = nil
+			return l.answer, l.err
+		case <-ctx.Done():
+			t.stale = r
+			return "", ctx.Err()
+		}
+	}
+}
+
+// start fills in the stdin defaults and starts the goroutine that reads a
+// line each time Prompt asks for one.
+func (t *Terminal) start() {
+	fd := int(os.Stdin.Fd())
+	if t.isTerminal == nil {
+		t.isTerminal = func() bool { return term.IsTerminal(fd) }
+	}
+	if t.readLine == nil {
+		stdin := bufio.NewReader(os.Stdin)
+		t.readLine = func(secret bool) (string, error) {
+			if secret {
+				answer, err := term.ReadPassword(fd)
+				fmt.Fprintln(os.Stderr)
+				return string(answer), err
+			}
+			answer, err := stdin.ReadString('\n')
+			return strings.TrimRight(answer, "\r\n"), err
+		}
+	}
+
+	t.reads = make(chan *read)
+	t.lines = make(chan line)
+	go func() {
+		for r := range t.reads {
+			answer, err := t.readLine(r.secret)
+			t.lines <- line{r, answer, err}
+		}
+	}()
+}
+
+// Queue holds prompts until they are answered through the dashboard.
+type Queue struct {
+	mu      sync.Mutex
+	next    int
+	pending map[string]*pending
+}
+
+type pending struct {
+	PendingPrompt
+	number int
+	answer chan string
+}
+
+// NewQueue creates an empty prompt queue.
+func NewQueue() *Queue {
+	return &Queue{pending: make(map[string]*pending)}
+}
+
+// Prompt waits until req is answered or canceled with Answer.
+func (q *Queue) Prompt(ctx context.Context, req Request) (string, error) {
+	// IDs are random so a page that cannot list the prompts cannot answer one
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to create prompt ID: %w", err)
+	}
+
+	q.mu.Lock()
+	q.next++
+	p := &pending{
+		PendingPrompt: PendingPrompt{ID: hex.EncodeToString(id), Request: req},
+		number:        q.next,
+		answer:        make(chan string, 1),
+	}
+	q.pending[p.ID] = p
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, p.ID)
+		q.mu.Unlock()
+	}()
+
+	select {
+	case answer, ok := <-p.answer:
+		if !ok {
+			return "", ErrCanceled
+		}
+		return answer, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// PendingPrompt is a prompt waiting for an answer, as listed by Pending.
+type PendingPrompt struct {
+	ID string `json:"id"`
+	Request
+}
+
+// Pending lists the unanswered prompts, oldest first.
+func (q *Queue) Pending() []PendingPrompt {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting := make([]*pending, 0, len(q.pending))
+	for _, p := range q.pending {
+		waiting = append(waiting, p)
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].number < waiting[j].number })
+
+	prompts := make([]PendingPrompt, len(waiting))
+	for i, p := range waiting {
+		prompts[i] = p.PendingPrompt
+	}
+	return prompts
+}
+
+// Answer answers the prompt with id, or dismisses it when cancel is set.
+func (q *Queue) Answer(id, answer string, cancel bool) error {
+	q.mu.Lock()
+	p, ok := q.pending[id]
+	if ok {
+		delete(q.pending, id)
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no pending prompt %q", id)
+	}
+	if cancel {
+		close(p.answer)
+	} else {
+		p.answer <- answer
+	}
+	return nil
+}
@@ -14,6 +14,8 @@ var dashboardTemplate string
 
 type Generator struct {
 	services []detector.Service
+	// route is the hop path to the server when jump hosts are used
+	route []string
 }
 
 func NewGenerator(services []detector.Service) *Generator {
@@ -22,8 +24,13 @@ func NewGenerator(services []detector.Service) *Generator {
 	}
 }
 
+// SetRoute sets the jump hosts and server that services are reached through.
+func (g *Generator) SetRoute(route []string) {
+	g.route = route
+}
+
 func (g *Generator) GenerateHTML(localPorts map[int]int, tunnelStartPort int) (string, error) {
-	viewModel := buildViewModel(g.services, localPorts, tunnelStartPort)
+	viewModel := buildViewModel(g.services, localPorts, tunnelStartPort, g.route)
 
 	funcMap := template.FuncMap{
 		"contains": strings.Contains,
@@ -43,7 +50,7 @@ func (g *Generator) GenerateHTML(localPorts map[int]int, tunnelStartPort int) (s
 }
 
 func (g *Generator) GenerateCLI(localPorts map[int]int, tunnelStartPort int) string {
-	viewModel := buildViewModel(g.services, localPorts, tunnelStartPort)
+	viewModel := buildViewModel(g.services, localPorts, tunnelStartPort, g.route)
 
 	if len(viewModel.Services) == 0 {
 		return "No services detected.\n"
@@ -52,6 +59,9 @@ func (g *Generator) GenerateCLI(localPorts map[int]int, tunnelStartPort int) str
 	var sb strings.Builder
 	sb.WriteString("\nZero-Trust Tunnel Dashboard\n")
 	sb.WriteString("===========================================================\n\n")
+	if viewModel.Route != "" {
+		sb.WriteString(fmt.Sprintf("Route: %s\n\n", viewModel.Route))
+	}
 
 	for _, view := range viewModel.Services {
 		sb.WriteString(fmt.Sprintf("%s\n", view.Name))
@@ -69,6 +79,15 @@ func (g *Generator) GenerateCLI(localPorts map[int]int, tunnelStartPort int) str
 			if view.LocalPort > 0 {
 				sb.WriteString(fmt.Sprintf("   Local Port: %d\n", view.LocalPort))
 			}
+			if view.TargetHost != "" {
+				sb.WriteString(fmt.Sprintf("   Target: %s:%d\n", view.TargetHost, view.Port))
+			}
+			if view.Socket != "" {
+				sb.WriteString(fmt.Sprintf("   Socket: %s\n", view.Socket))
+			}
+			if view.LocalSocket != "" {
+				sb.WriteString(fmt.Sprintf("   Local Socket: %s\n", view.LocalSocket))
+			}
 		case AccessProxied:
 			if view.Domain != "" {
 				sb.WriteString(fmt.Sprintf("   Domain: %s (configured in Nginx)\n", view.Domain))
@@ -84,6 +103,15 @@ func (g *Generator) GenerateCLI(localPorts map[int]int, tunnelStartPort int) str
 		if view.Network != "" {
 			sb.WriteString(fmt.Sprintf("   Network: %s\n", view.Network))
 		}
+		if view.Path != "" {
+			sb.WriteString(fmt.Sprintf("   Path: %s\n", view.Path))
+		}
+		if view.Bind != "" {
+			sb.WriteString(fmt.Sprintf("   Bound to: %s\n", view.Bind))
+		}
+		if view.Process != "" {
+			sb.WriteString(fmt.Sprintf("   Process: %s\n", view.Process))
+		}
 
 		sb.WriteString("\n")
 	}
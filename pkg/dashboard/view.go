@@ -53,6 +53,14 @@ type ServiceView struct {
 	Icon        string
 	Domain      string
 	Network     string
+	TargetHost  string
+	// Path is the hop path to the service when jump hosts are used
+	Path        string
+	Socket      string
+	LocalSocket string
+	// Bind describes how the service listens on the server
+	Bind        string
+	Process     string
 	Access      ServiceAccess
 	AccessClass string
 }
@@ -71,11 +79,13 @@ type ViewModel struct {
 	Groups   map[string][]ServiceView
 	Stats    Stats
 	Networks []string
+	// Route is the hop path to the server, empty without jump hosts
+	Route string
 }
 
 // resolveAccess determines the access level of a service based on its properties
 func resolveAccess(svc detector.Service, hasURL bool) ServiceAccess {
-	if hasURL {
+	if hasURL || svc.Socket != "" {
 		return AccessAccessible
 	}
 	if svc.Domain != "" {
@@ -125,7 +135,7 @@ func normalizeNetworkName(network string) string {
 }
 
 // buildViewModel creates a ViewModel from services and port mappings
-func buildViewModel(services []detector.Service, localPorts map[int]int, tunnelStartPort int) ViewModel {
+func buildViewModel(services []detector.Service, localPorts map[int]int, tunnelStartPort int, route []string) ViewModel {
 	var views []ServiceView
 	networkSet := make(map[string]bool)
 
@@ -135,6 +145,7 @@ func buildViewModel(services []detector.Service, localPorts map[int]int, tunnelS
 		}
 
 		view := buildServiceView(svc, localPorts, tunnelStartPort)
+		view.Path = servicePath(route, view.TargetHost)
 		views = append(views, view)
 
 		if view.Network != "" {
@@ -155,9 +166,23 @@ func buildViewModel(services []detector.Service, localPorts map[int]int, tunnelS
 		Groups:   groups,
 		Stats:    stats,
 		Networks: networks,
+		Route:    strings.Join(route, " → "),
 	}
 }
 
+// servicePath returns the hops from route to a service on targetHost, which
+// is the server itself when empty.
+func servicePath(route []string, targetHost string) string {
+	if len(route) == 0 {
+		return ""
+	}
+	path := strings.Join(route, " → ")
+	if targetHost != "" && targetHost != "localhost" {
+		path += " → " + targetHost
+	}
+	return path
+}
+
 // buildServiceView creates a ServiceView from a detector.Service
 func buildServiceView(svc detector.Service, localPorts map[int]int, tunnelStartPort int) ServiceView {
 	icon := getServiceIcon(svc.Type)
@@ -167,7 +192,13 @@ func buildServiceView(svc detector.Service, localPorts map[int]int, tunnelStartP
 	isProxied := strings.Contains(svc.Description, "Nginx Proxy") || hasDomain
 
 	if svc.Port > 0 && !isProxied {
-		if lp, exists := localPorts[svc.Port]; exists {
+		if svc.LocalPort > 0 {
+			// Service has a dedicated tunnel, e.g. to a container IP
+			localPort = svc.LocalPort
+			if serviceURL == "" {
+				serviceURL = fmt.Sprintf("http://localhost:%d", localPort)
+			}
+		} else if lp, exists := localPorts[svc.Port]; exists {
 			localPort = lp
 			if localPort == tunnelStartPort {
 				serviceURL = ""
@@ -180,11 +211,16 @@ func buildServiceView(svc detector.Service, localPorts map[int]int, tunnelStartP
 		}
 	}
 
+	if svc.Socket != "" {
+		// Socket forwards have no remote port, only their own local end
+		localPort = svc.LocalPort
+	}
+
 	if isProxied {
 		localPort = 0
 	}
 
-	if strings.Contains(serviceURL, fmt.Sprintf(":%d", tunnelStartPort)) || localPort == tunnelStartPort {
+	if svc.Socket == "" && (strings.Contains(serviceURL, fmt.Sprintf(":%d", tunnelStartPort)) || localPort == tunnelStartPort) {
 		serviceURL = ""
 		localPort = 0
 	}
@@ -203,11 +239,38 @@ func buildServiceView(svc detector.Service, localPorts map[int]int, tunnelStartP
 		Icon:        icon,
 		Domain:      svc.Domain,
 		Network:     normalizedNetwork,
+		TargetHost:  svc.TargetHost,
+		Socket:      svc.Socket,
+		LocalSocket: svc.LocalSocket,
+		Bind:        bindLabel(svc),
+		Process:     processLabel(svc),
 		Access:      access,
 		AccessClass: accessClass(access),
 	}
 }
 
+// bindLabel describes the address a service listens on at the server
+func bindLabel(svc detector.Service) string {
+	switch svc.BindScope {
+	case "wildcard":
+		return fmt.Sprintf("all interfaces (%s)", svc.Bind)
+	case "loopback":
+		return fmt.Sprintf("loopback only (%s)", svc.Bind)
+	case "interface":
+		return fmt.Sprintf("%s only", svc.Bind)
+	default:
+		return ""
+	}
+}
+
+// processLabel describes the process listening on the service's port
+func processLabel(svc detector.Service) string {
+	if svc.Process == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s, PID %d", svc.Process, svc.PID)
+}
+
 // computeStats calculates statistics from service views
 func computeStats(views []ServiceView) Stats {
 	stats := Stats{
@@ -1,10 +1,12 @@
 package detector
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
 	"regexp"
 	"strings"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 )
 
 // Package-level regex compilation for performance
@@ -20,6 +22,9 @@ type DockerService struct {
 	Port          int
 	PortMapping   string
 	Network       string
+	// IPAddress is the container's address on its Docker network, used to
+	// forward to ports that are not published on the host
+	IPAddress     string
 	HasPorts      bool
 	ExposedToHost bool
 }
@@ -30,65 +35,97 @@ type PortInfo struct {
 	ExposedToHost bool
 }
 
-// buildSSHCommand constructs an SSH command for remote execution
-func buildSSHCommand(server, user, keyPath string, useHostAlias bool, hostAlias, cmd string, insecure bool) *exec.Cmd {
-	var args []string
-
-	if insecure {
-		args = append(args,
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-		)
+// executeDockerPS runs docker ps on the server and returns the output
+func executeDockerPS(tr transport.Transport) (string, error) {
+	output, err := tr.Run(context.Background(),
+		"docker ps --format '{{.Names}}|{{.Image}}|{{.Ports}}|{{.Networks}}'")
+	if err != nil {
+		return "", fmt.Errorf("docker ps failed: %w", err)
 	}
 
-	args = append(args, "-o", "LogLevel=ERROR")
+	return string(output), nil
+}
 
-	if useHostAlias {
-		args = append(args, hostAlias, cmd)
-	} else {
-		if keyPath != "" {
-			args = append(args, "-i", keyPath)
-		}
-		args = append(args, fmt.Sprintf("%s@%s", user, server), cmd)
+// DetectDockerServices detects Docker services with ports exposed to the host
+func DetectDockerServices(tr transport.Transport) (map[int]*DockerService, error) {
+	output, err := executeDockerPS(tr)
+	if err != nil {
+		return nil, err
 	}
 
-	return exec.Command("ssh", args...)
+	return filterExposedContainers(parseDockerContainers(output)), nil
 }
 
-// executeDockerPS runs docker ps remotely via SSH and returns the output
-func executeDockerPS(server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) (string, error) {
-	cmd := buildSSHCommand(server, user, keyPath, useHostAlias, hostAlias,
-		"docker ps --format '{{.Names}}|{{.Image}}|{{.Ports}}|{{.Networks}}'", insecure)
-
-	output, err := cmd.Output()
+// GetAllDockerContainers returns all Docker containers regardless of port exposure
+func GetAllDockerContainers(tr transport.Transport) ([]*DockerService, error) {
+	output, err := executeDockerPS(tr)
 	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			return "", fmt.Errorf("docker ps failed: %s: %w", string(ee.Stderr), err)
+		return nil, err
+	}
+
+	containers := parseDockerContainers(output)
+
+	// Container IPs are best-effort: without them internal ports stay unreachable
+	if ips, err := inspectContainerIPs(tr); err == nil {
+		for _, container := range containers {
+			container.IPAddress = ips[container.ContainerName]
 		}
-		return "", fmt.Errorf("failed to run docker ps: %w", err)
 	}
 
-	return string(output), nil
+	return containers, nil
 }
 
-// DetectDockerServices detects Docker services with ports exposed to the host
-func DetectDockerServices(server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) (map[int]*DockerService, error) {
-	output, err := executeDockerPS(server, user, keyPath, useHostAlias, hostAlias, insecure)
+// inspectContainerIPs runs docker inspect on the server and returns each
+// running container's IP address keyed by container name
+func inspectContainerIPs(tr transport.Transport) (map[string]string, error) {
+	// List the IDs separately rather than with $(docker ps -q), so escalation
+	// applies to both docker commands
+	ids, err := tr.Run(context.Background(), "docker ps -q")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	idList := strings.Fields(string(ids))
+	if len(idList) == 0 {
+		return map[string]string{}, nil
 	}
 
-	return filterExposedContainers(parseDockerContainers(output)), nil
+	output, err := tr.Run(context.Background(),
+		"docker inspect --format '{{.Name}}|{{range $net, $cfg := .NetworkSettings.Networks}}{{$net}}={{$cfg.IPAddress}},{{end}}' "+strings.Join(idList, " "))
+	if err != nil {
+		return nil, fmt.Errorf("docker inspect failed: %w", err)
+	}
+
+	return parseContainerIPs(string(output)), nil
 }
 
-// GetAllDockerContainers returns all Docker containers regardless of port exposure
-func GetAllDockerContainers(server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) ([]*DockerService, error) {
-	output, err := executeDockerPS(server, user, keyPath, useHostAlias, hostAlias, insecure)
-	if err != nil {
-		return nil, err
+// parseContainerIPs parses "/name|net1=ip1,net2=ip2," lines from docker inspect,
+// keeping the first non-empty IP for each container
+func parseContainerIPs(output string) map[string]string {
+	ips := make(map[string]string)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "|", 2)
+		if len(parts) < 2 {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimSpace(parts[0]), "/")
+		for _, entry := range strings.Split(parts[1], ",") {
+			_, ip, found := strings.Cut(entry, "=")
+			ip = strings.TrimSpace(ip)
+			if found && ip != "" {
+				ips[name] = ip
+				break
+			}
+		}
 	}
 
-	return parseDockerContainers(output), nil
+	return ips
 }
 
 // filterExposedContainers filters containers to only those with ports exposed to the host
@@ -1,24 +1,26 @@
 package detector
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
 	"strings"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 )
 
-func QueryNPMDatabase(nginxContainerName, containerName string, containerPort int, server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) ([]string, error) {
+func QueryNPMDatabase(tr transport.Transport, nginxContainerName, containerName string, containerPort int) ([]string, error) {
 
-	domains, err := queryNPMWithSQLite3(nginxContainerName, containerName, containerPort, server, user, keyPath, useHostAlias, hostAlias, insecure)
+	domains, err := queryNPMWithSQLite3(tr, nginxContainerName, containerName, containerPort)
 	if err == nil && len(domains) > 0 {
 		return domains, nil
 	}
 
-	domains, err = getNginxDomainsFromConfig(nginxContainerName, containerName, containerPort, server, user, keyPath, useHostAlias, hostAlias, insecure)
+	domains, err = getNginxDomainsFromConfig(tr, nginxContainerName, containerName, containerPort)
 	if err == nil && len(domains) > 0 {
 		return domains, nil
 	}
 
-	domains, err = queryNPMFromHost(nginxContainerName, containerName, containerPort, server, user, keyPath, useHostAlias, hostAlias, insecure)
+	domains, err = queryNPMFromHost(tr, nginxContainerName, containerName, containerPort)
 	if err == nil && len(domains) > 0 {
 		return domains, nil
 	}
@@ -26,42 +28,13 @@ func QueryNPMDatabase(nginxContainerName, containerName string, containerPort in
 	return nil, nil
 }
 
-func queryNPMWithSQLite3(nginxContainerName, containerName string, containerPort int, server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) ([]string, error) {
-	var cmd *exec.Cmd
+func queryNPMWithSQLite3(tr transport.Transport, nginxContainerName, containerName string, containerPort int) ([]string, error) {
 	dbPath := "/data/database.sqlite"
 
 	query := fmt.Sprintf("SELECT domain_names FROM proxy_host WHERE (forward_host LIKE '%%%s%%' OR forward_host = '%s') AND forward_port = %d", containerName, containerName, containerPort)
 
-	// Helper to add insecure flags
-	addInsecureFlags := func(args []string) []string {
-		if insecure {
-			return append(args,
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "UserKnownHostsFile=/dev/null",
-			)
-		}
-		return args
-	}
-
-	if useHostAlias {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR", hostAlias,
-			fmt.Sprintf("docker exec %s sqlite3 %s %q 2>/dev/null || echo ''", nginxContainerName, dbPath, query))
-		cmd = exec.Command("ssh", args...)
-	} else {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR")
-		if keyPath != "" {
-			args = append(args, "-i", keyPath)
-		}
-		args = append(args, fmt.Sprintf("%s@%s", user, server),
-			fmt.Sprintf("docker exec %s sqlite3 %s %q 2>/dev/null || echo ''", nginxContainerName, dbPath, query))
-		cmd = exec.Command("ssh", args...)
-	}
-
-	output, err := cmd.Output()
+	output, err := tr.Run(context.Background(),
+		fmt.Sprintf("docker exec %s sqlite3 %s %q 2>/dev/null || echo ''", nginxContainerName, dbPath, query))
 	if err != nil {
 		return nil, err
 	}
@@ -88,39 +61,9 @@ func queryNPMWithSQLite3(nginxContainerName, containerName string, containerPort
 	return nil, fmt.Errorf("no domains found")
 }
 
-func queryNPMFromHost(nginxContainerName, containerName string, containerPort int, server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) ([]string, error) {
-	var cmd *exec.Cmd
-
-	// Helper to add insecure flags
-	addInsecureFlags := func(args []string) []string {
-		if insecure {
-			return append(args,
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "UserKnownHostsFile=/dev/null",
-			)
-		}
-		return args
-	}
-
-	if useHostAlias {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR", hostAlias,
-			fmt.Sprintf("docker inspect %s --format '{{range .Mounts}}{{if eq .Destination \"/data\"}}{{.Source}}{{end}}{{end}}' 2>/dev/null", nginxContainerName))
-		cmd = exec.Command("ssh", args...)
-	} else {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR")
-		if keyPath != "" {
-			args = append(args, "-i", keyPath)
-		}
-		args = append(args, fmt.Sprintf("%s@%s", user, server),
-			fmt.Sprintf("docker inspect %s --format '{{range .Mounts}}{{if eq .Destination \"/data\"}}{{.Source}}{{end}}{{end}}' 2>/dev/null", nginxContainerName))
-		cmd = exec.Command("ssh", args...)
-	}
-
-	output, err := cmd.Output()
+func queryNPMFromHost(tr transport.Transport, nginxContainerName, containerName string, containerPort int) ([]string, error) {
+	output, err := tr.Run(context.Background(),
+		fmt.Sprintf("docker inspect %s --format '{{range .Mounts}}{{if eq .Destination \"/data\"}}{{.Source}}{{end}}{{end}}' 2>/dev/null", nginxContainerName))
 	if err != nil {
 		return nil, err
 	}
@@ -133,25 +76,8 @@ func queryNPMFromHost(nginxContainerName, containerName string, containerPort in
 	dbPath := fmt.Sprintf("%s/database.sqlite", mountPath)
 	query := fmt.Sprintf("SELECT domain_names FROM proxy_host WHERE (forward_host LIKE '%%%s%%' OR forward_host = '%s') AND forward_port = %d", containerName, containerName, containerPort)
 
-	if useHostAlias {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR", hostAlias,
-			fmt.Sprintf("sqlite3 %s %q 2>/dev/null || echo ''", dbPath, query))
-		cmd = exec.Command("ssh", args...)
-	} else {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR")
-		if keyPath != "" {
-			args = append(args, "-i", keyPath)
-		}
-		args = append(args, fmt.Sprintf("%s@%s", user, server),
-			fmt.Sprintf("sqlite3 %s %q 2>/dev/null || echo ''", dbPath, query))
-		cmd = exec.Command("ssh", args...)
-	}
-
-	output, err = cmd.Output()
+	output, err = tr.Run(context.Background(),
+		fmt.Sprintf("sqlite3 %s %q 2>/dev/null || echo ''", dbPath, query))
 	if err != nil {
 		return nil, err
 	}
@@ -178,39 +104,9 @@ func queryNPMFromHost(nginxContainerName, containerName string, containerPort in
 	return nil, fmt.Errorf("no domains found")
 }
 
-func getNginxDomainsFromConfig(nginxContainerName, containerName string, containerPort int, server, user, keyPath string, useHostAlias bool, hostAlias string, insecure bool) ([]string, error) {
-	var cmd *exec.Cmd
-
-	// Helper to add insecure flags
-	addInsecureFlags := func(args []string) []string {
-		if insecure {
-			return append(args,
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "UserKnownHostsFile=/dev/null",
-			)
-		}
-		return args
-	}
-
-	if useHostAlias {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR", hostAlias,
-			fmt.Sprintf("docker exec %s find /data/nginx/proxy_host -name '*.conf' -exec grep -l '%s:%d' {} \\; 2>/dev/null | head -1 | xargs grep -oP 'server_name\\s+\\K[^;]+' 2>/dev/null || echo ''", nginxContainerName, containerName, containerPort))
-		cmd = exec.Command("ssh", args...)
-	} else {
-		args := []string{}
-		args = addInsecureFlags(args)
-		args = append(args, "-o", "LogLevel=ERROR")
-		if keyPath != "" {
-			args = append(args, "-i", keyPath)
-		}
-		args = append(args, fmt.Sprintf("%s@%s", user, server),
-			fmt.Sprintf("docker exec %s find /data/nginx/proxy_host -name '*.conf' -exec grep -l '%s:%d' {} \\; 2>/dev/null | head -1 | xargs grep -oP 'server_name\\s+\\K[^;]+' 2>/dev/null || echo ''", nginxContainerName, containerName, containerPort))
-		cmd = exec.Command("ssh", args...)
-	}
-
-	output, err := cmd.Output()
+func getNginxDomainsFromConfig(tr transport.Transport, nginxContainerName, containerName string, containerPort int) ([]string, error) {
+	output, err := tr.Run(context.Background(),
+		fmt.Sprintf("docker exec %s find /data/nginx/proxy_host -name '*.conf' -exec grep -l '%s:%d' {} \\; 2>/dev/null | head -1 | xargs grep -oP 'server_name\\s+\\K[^;]+' 2>/dev/null || echo ''", nginxContainerName, containerName, containerPort))
 	if err != nil {
 		return nil, err
 	}
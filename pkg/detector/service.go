@@ -16,6 +16,52 @@ type Service struct {
 	Description string
 	Domain      string
 	Network     string
+	// TargetHost is where the tunnel for this service points on the remote
+	// side, e.g. a container IP. Empty means the SSH server itself.
+	TargetHost string
+	// LocalPort is set when the service has its own tunnel that is not keyed
+	// by Port in the local port map, such as a forward to a container IP.
+	LocalPort int
+	// Socket is the remote Unix socket path for socket-forwarded services,
+	// LocalSocket the local path it is forwarded to, if any.
+	Socket      string
+	LocalSocket string
+	// Bind is the address the service listens on at the server, such as
+	// "127.0.0.1" or "::", and BindScope whether that is "wildcard",
+	// "loopback" or "interface". Both are empty when the port was not
+	// scanned.
+	Bind      string
+	BindScope string
+	// Process describes the program listening on Port, such as
+	// "node /srv/billing/server.js (deploy)", and PID is its process ID.
+	// Both are empty when ss or netstat could not name it.
+	Process string
+	PID     int
+	// guessed is set when the name only comes from the port number
+	guessed bool
+}
+
+// SetProcess records the process listening on the service's port, such as
+// "node /srv/billing/server.js (deploy)". Services only known by their port
+// number are named after it and describe it with its PID; the others
+// mention it in their description.
+func (s *Service) SetProcess(process string, pid int) {
+	if process == "" {
+		return
+	}
+	s.Process = process
+	s.PID = pid
+
+	switch {
+	case s.Type == "unknown":
+		s.Name = process
+		s.Description = fmt.Sprintf("%s, PID %d listening on port %d", process, pid, s.Port)
+	case s.guessed:
+		s.Name = process
+		s.Description = fmt.Sprintf("%s, PID %d; %s", process, pid, s.Description)
+	default:
+		s.Description = fmt.Sprintf("%s, served by %s", s.Description, process)
+	}
 }
 
 type Detector struct {
@@ -326,6 +372,7 @@ func (d *Detector) guessServiceByPort(port int) *Service {
 			Type:        svc.Type,
 			URL:         fmt.Sprintf("http://localhost:%d", port),
 			Description: svc.Description,
+			guessed:     true,
 		}
 	}
 
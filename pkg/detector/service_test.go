@@ -1,10 +1,15 @@
 package detector
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport/transporttest"
 )
 
 func TestNewDetector(t *testing.T) {
@@ -89,6 +94,52 @@ func TestGuessServiceByPort(t *testing.T) {
 	}
 }
 
+func TestSetProcess(t *testing.T) {
+	detector := NewDetector(3 * time.Second)
+	process := "node /srv/billing/server.js (deploy)"
+
+	tests := []struct {
+		name            string
+		service         *Service
+		wantName        string
+		wantDescription string
+	}{
+		{
+			name:            "bare port",
+			service:         &Service{Port: 4000, Name: "Service on port 4000", Type: "unknown"},
+			wantName:        process,
+			wantDescription: process + ", PID 1234 listening on port 4000",
+		},
+		{
+			name:            "guessed by port",
+			service:         detector.guessServiceByPort(3000),
+			wantName:        process,
+			wantDescription: process + ", PID 1234; Common port for Node.js development servers",
+		},
+		{
+			name:            "identified over HTTP",
+			service:         &Service{Port: 3000, Name: "Grafana", Type: "grafana", Description: "Grafana Dashboard"},
+			wantName:        "Grafana",
+			wantDescription: "Grafana Dashboard, served by " + process,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.service.SetProcess(process, 1234)
+			if tt.service.Name != tt.wantName {
+				t.Errorf("SetProcess() Name = %q, want %q", tt.service.Name, tt.wantName)
+			}
+			if tt.service.Description != tt.wantDescription {
+				t.Errorf("SetProcess() Description = %q, want %q", tt.service.Description, tt.wantDescription)
+			}
+			if tt.service.PID != 1234 || tt.service.Process != process {
+				t.Errorf("SetProcess() Process = %q, PID = %d", tt.service.Process, tt.service.PID)
+			}
+		})
+	}
+}
+
 func TestIdentifyServiceFromResponse_Grafana(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("X-Grafana-Version", "9.5.0")
@@ -335,3 +386,168 @@ func TestIdentifyServiceFromDocker(t *testing.T) {
 		})
 	}
 }
+
+func TestParseContainerIPs(t *testing.T) {
+	output := `/postgres|backend=172.18.0.5,
+/redis|backend=172.18.0.6,frontend=172.19.0.2,
+/host-net|host=,
+/grafana|monitoring=,bridge=172.17.0.3,
+`
+
+	ips := parseContainerIPs(output)
+
+	tests := map[string]string{
+		"postgres": "172.18.0.5",
+		"redis":    "172.18.0.6",
+		"grafana":  "172.17.0.3",
+	}
+	for name, want := range tests {
+		if got := ips[name]; got != want {
+			t.Errorf("parseContainerIPs()[%q] = %q, want %q", name, got, want)
+		}
+	}
+
+	if _, exists := ips["host-net"]; exists {
+		t.Error("parseContainerIPs() should skip containers without an IP")
+	}
+}
+
+func TestGetAllDockerContainers(t *testing.T) {
+	tr := transporttest.Fake{
+		"docker ps":      "web|nginx:latest|0.0.0.0:8080->80/tcp|frontend\npostgres|postgres:16|5432/tcp|backend\n",
+		"docker ps -q":   "3f2a\n9c1b\n",
+		"docker inspect": "/web|frontend=172.19.0.2,\n/postgres|backend=172.18.0.5,\n",
+	}
+
+	containers, err := GetAllDockerContainers(tr)
+	if err != nil {
+		t.Fatalf("GetAllDockerContainers() error = %v", err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(containers))
+	}
+	if containers[1].ContainerName != "postgres" || containers[1].IPAddress != "172.18.0.5" || containers[1].ExposedToHost {
+		t.Errorf("unexpected container: %+v", containers[1])
+	}
+
+	exposed, err := DetectDockerServices(tr)
+	if err != nil {
+		t.Fatalf("DetectDockerServices() error = %v", err)
+	}
+	if len(exposed) != 1 || exposed[8080] == nil {
+		t.Errorf("expected only port 8080 to be exposed, got %v", exposed)
+	}
+
+	if _, err := GetAllDockerContainers(transporttest.Fake{}); err == nil {
+		t.Error("expected an error when docker ps fails")
+	}
+}
+
+func TestGetAllDockerContainersEscalated(t *testing.T) {
+	rec := &recordingTransport{Fake: transporttest.Fake{
+		"sudo -n docker ps":      "postgres|postgres:16|5432/tcp|backend\n",
+		"sudo -n docker ps -q":   "3f2a\n",
+		"sudo -n docker inspect": "/postgres|backend=172.18.0.5,\n",
+	}}
+	escalation, err := transport.ParseEscalation("sudo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := GetAllDockerContainers(transport.Escalate(rec, escalation))
+	if err != nil {
+		t.Fatalf("GetAllDockerContainers() error = %v", err)
+	}
+	if len(containers) != 1 || containers[0].IPAddress != "172.18.0.5" {
+		t.Errorf("expected the container IP from an escalated inspect, got %+v", containers)
+	}
+
+	var ps, inspect string
+	for _, command := range rec.commands {
+		switch {
+		case command == "sudo -n docker ps -q" || command == "docker ps -q":
+			ps = command
+		case strings.Contains(command, "docker inspect"):
+			inspect = command
+		}
+	}
+	if ps != "sudo -n docker ps -q" {
+		t.Errorf("docker ps -q ran as %q, want it escalated", ps)
+	}
+	if !strings.HasPrefix(inspect, "sudo -n docker inspect ") || !strings.HasSuffix(inspect, " 3f2a") {
+		t.Errorf("docker inspect ran as %q, want it escalated with the container IDs", inspect)
+	}
+}
+
+// recordingTransport is transporttest.Fake remembering every command it ran.
+type recordingTransport struct {
+	transporttest.Fake
+	commands []string
+}
+
+func (r *recordingTransport) Run(ctx context.Context, command string) ([]byte, error) {
+	r.commands = append(r.commands, command)
+	return r.Fake.Run(ctx, command)
+}
+
+func TestIdentifyUnixSocket(t *testing.T) {
+	tests := []struct {
+		name        string
+		socketPath  string
+		localPort   int
+		localSocket string
+		wantType    string
+		wantDesc    string
+	}{
+		{
+			name:       "docker over tcp",
+			socketPath: "/var/run/docker.sock",
+			localPort:  9005,
+			wantType:   "docker",
+			wantDesc:   "Docker API (DOCKER_HOST=tcp://localhost:9005)",
+		},
+		{
+			name:        "docker over local socket",
+			socketPath:  "/var/run/docker.sock",
+			localSocket: "/tmp/remote-docker.sock",
+			wantType:    "docker",
+			wantDesc:    "Docker API (DOCKER_HOST=unix:///tmp/remote-docker.sock)",
+		},
+		{
+			name:       "postgres",
+			socketPath: "/var/run/postgresql/.s.PGSQL.5432",
+			localPort:  9006,
+			wantType:   "postgres",
+			wantDesc:   "PostgreSQL (psql -h localhost -p 9006)",
+		},
+		{
+			name:       "php-fpm",
+			socketPath: "/run/php/php8.2-fpm.sock",
+			localPort:  9007,
+			wantType:   "php-fpm",
+			wantDesc:   "PHP-FPM FastCGI (localhost:9007)",
+		},
+		{
+			name:       "unknown socket",
+			socketPath: "/run/app/app.sock",
+			localPort:  9008,
+			wantType:   "socket",
+			wantDesc:   "Unix socket /run/app/app.sock (forwarded to localhost:9008)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := IdentifyUnixSocket(tt.socketPath, tt.localPort, tt.localSocket)
+			if service.Type != tt.wantType {
+				t.Errorf("IdentifyUnixSocket() Type = %q, want %q", service.Type, tt.wantType)
+			}
+			if service.Description != tt.wantDesc {
+				t.Errorf("IdentifyUnixSocket() Description = %q, want %q", service.Description, tt.wantDesc)
+			}
+			if service.Socket != tt.socketPath || service.LocalPort != tt.localPort || service.Port != 0 {
+				t.Errorf("IdentifyUnixSocket() = %+v, want socket %s on local port %d", service, tt.socketPath, tt.localPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package detector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// socketMatcher identifies a service from the path of its Unix socket.
+type socketMatcher struct {
+	Match func(socketPath string) bool
+	Type  string
+	Name  string
+	// Desc describes the service given the local endpoint, either
+	// "localhost:<port>" or a local socket path.
+	Desc func(endpoint string, isSocket bool) string
+}
+
+// socketMatchers is the registry of known Unix socket services
+var socketMatchers = []socketMatcher{
+	{
+		Match: func(p string) bool { return path.Base(p) == "docker.sock" },
+		Type:  "docker",
+		Name:  "Docker API",
+		Desc: func(endpoint string, isSocket bool) string {
+			if isSocket {
+				return fmt.Sprintf("Docker API (DOCKER_HOST=unix://%s)", endpoint)
+			}
+			return fmt.Sprintf("Docker API (DOCKER_HOST=tcp://%s)", endpoint)
+		},
+	},
+	{
+		Match: func(p string) bool { return strings.HasPrefix(path.Base(p), ".s.PGSQL.") },
+		Type:  "postgres",
+		Name:  "PostgreSQL",
+		Desc: func(endpoint string, isSocket bool) string {
+			if isSocket {
+				return fmt.Sprintf("PostgreSQL socket (%s)", endpoint)
+			}
+			host, port, _ := strings.Cut(endpoint, ":")
+			return fmt.Sprintf("PostgreSQL (psql -h %s -p %s)", host, port)
+		},
+	},
+	{
+		Match: func(p string) bool { return strings.Contains(path.Base(p), "mysql") },
+		Type:  "mysql",
+		Name:  "MySQL",
+		Desc: func(endpoint string, isSocket bool) string {
+			if isSocket {
+				return fmt.Sprintf("MySQL socket (mysql --socket=%s)", endpoint)
+			}
+			// mysql treats "localhost" as its own socket, so force TCP
+			_, port, _ := strings.Cut(endpoint, ":")
+			return fmt.Sprintf("MySQL (mysql -h 127.0.0.1 -P %s)", port)
+		},
+	},
+	{
+		Match: func(p string) bool { return strings.Contains(path.Base(p), "redis") },
+		Type:  "redis",
+		Name:  "Redis",
+		Desc: func(endpoint string, isSocket bool) string {
+			if isSocket {
+				return fmt.Sprintf("Redis socket (redis-cli -s %s)", endpoint)
+			}
+			return fmt.Sprintf("Redis (redis-cli -u redis://%s)", endpoint)
+		},
+	},
+	{
+		Match: func(p string) bool { return strings.Contains(path.Base(p), "fpm") },
+		Type:  "php-fpm",
+		Name:  "PHP-FPM",
+		Desc: func(endpoint string, _ bool) string {
+			return fmt.Sprintf("PHP-FPM FastCGI (%s)", endpoint)
+		},
+	},
+}
+
+// IdentifyUnixSocket builds a service for a forwarded Unix socket. The local
+// end is localSocket if set, otherwise localhost:localPort.
+func IdentifyUnixSocket(socketPath string, localPort int, localSocket string) *Service {
+	endpoint := localSocket
+	isSocket := localSocket != ""
+	if !isSocket {
+		endpoint = fmt.Sprintf("localhost:%d", localPort)
+	}
+
+	service := &Service{
+		Socket:      socketPath,
+		LocalSocket: localSocket,
+		LocalPort:   localPort,
+	}
+
+	for _, matcher := range socketMatchers {
+		if matcher.Match(socketPath) {
+			service.Name = matcher.Name
+			service.Type = matcher.Type
+			service.Description = matcher.Desc(endpoint, isSocket)
+			return service
+		}
+	}
+
+	service.Name = path.Base(socketPath)
+	service.Type = "socket"
+	service.Description = fmt.Sprintf("Unix socket %s (forwarded to %s)", socketPath, endpoint)
+	return service
+}
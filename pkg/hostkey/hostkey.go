@@ -0,0 +1,270 @@
+// Package hostkey verifies SSH server keys on first use. Accepted keys are kept
+// in a known_hosts file owned by tunnel-dash, which both SSH backends then
+// check strictly, and keys can be pinned per host by fingerprint.
+package hostkey
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrNotTrusted is returned when an unknown key was not confirmed.
+var ErrNotTrusted = errors.New("host key was not trusted")
+
+// MismatchError reports a server key that differs from the one on record.
+// It is what a man-in-the-middle looks like, so the connection is refused.
+type MismatchError struct {
+	Host string
+	// Got is the fingerprint the server presented
+	Got string
+	// Want are the fingerprints on record
+	Want []string
+	// Pinned is set when Want came from pinned fingerprints, not the store
+	Pinned bool
+	// Path and Line locate the stored key, for removal if the change is expected
+	Path string
+	Line int
+}
+
+func (e *MismatchError) Error() string {
+	var b strings.Builder
+	b.WriteString("REMOTE HOST KEY HAS CHANGED, refusing to connect to " + e.Host + "\n")
+	b.WriteString("Someone could be intercepting the connection (man-in-the-middle attack),\n")
+	b.WriteString("or the server's host key was replaced.\n")
+	fmt.Fprintf(&b, "  presented: %s\n", e.Got)
+	fmt.Fprintf(&b, "  expected:  %s\n", strings.Join(e.Want, ", "))
+	if e.Pinned {
+		b.WriteString("The expected key is pinned with --host-key-fingerprint, update the pin if the change is legitimate")
+	} else {
+		fmt.Fprintf(&b, "If the change is legitimate, remove line %d of %s and reconnect", e.Line, e.Path)
+	}
+	return b.String()
+}
+
+// DefaultStorePath returns the location of tunnel-dash's known_hosts file.
+func DefaultStorePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "tunnel-dash", "known_hosts"), nil
+}
+
+// DialFunc opens a connection to addr, e.g. through a jump host.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ConfirmFunc asks the user whether to trust an unknown key.
+type ConfirmFunc func(host, keyType, fingerprint string) bool
+
+// Verifier checks a server's key against pinned fingerprints and the store,
+// asking Confirm about keys it has not seen before.
+type Verifier struct {
+	// StorePath is the known_hosts file that accepted keys are added to
+	StorePath string
+	// Pins are accepted fingerprints as "SHA256:..." for every host or
+	// "host=SHA256:..." for one host
+	Pins []string
+	// Confirm is asked about unknown keys, nil rejects them
+	Confirm ConfirmFunc
+	// Timeout bounds the key scan, 15s when zero
+	Timeout time.Duration
+	// Dial reaches the server, a direct TCP connection when nil
+	Dial DialFunc
+}
+
+// mu serializes updates of store files within the process.
+var mu sync.Mutex
+
+// Verify fetches the key of host:port and makes sure it is trusted, adding
+// it to the store when it is new and was pinned or confirmed. It returns the
+// verified key's fingerprint.
+func (v *Verifier) Verify(ctx context.Context, host string, port int) (string, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	known, err := v.known()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := Scan(ctx, v.Dial, addr, KnownAlgorithms(known, addr), v.Timeout)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	if pins := v.pinsFor(host); len(pins) > 0 {
+		for _, pin := range pins {
+			if pin == fingerprint {
+				return fingerprint, v.remember(addr, key, known)
+			}
+		}
+		return "", &MismatchError{Host: addr, Got: fingerprint, Want: pins, Pinned: true}
+	}
+
+	if known != nil {
+		err := known(addr, &net.TCPAddr{}, key)
+		var keyErr *knownhosts.KeyError
+		switch {
+		case err == nil:
+			return fingerprint, nil
+		case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+			mismatch := &MismatchError{Host: addr, Got: fingerprint, Path: keyErr.Want[0].Filename, Line: keyErr.Want[0].Line}
+			for _, want := range keyErr.Want {
+				mismatch.Want = append(mismatch.Want, ssh.FingerprintSHA256(want.Key))
+			}
+			return "", mismatch
+		case !errors.As(err, &keyErr):
+			return "", err
+		}
+	}
+
+	if v.Confirm == nil || !v.Confirm(addr, key.Type(), fingerprint) {
+		return "", fmt.Errorf("%w: %s %s %s", ErrNotTrusted, addr, key.Type(), fingerprint)
+	}
+	return fingerprint, v.remember(addr, key, nil)
+}
+
+// known returns a callback for the store, or nil while it does not exist.
+func (v *Verifier) known() (ssh.HostKeyCallback, error) {
+	if _, err := os.Stat(v.StorePath); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	callback, err := knownhosts.New(v.StorePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", v.StorePath, err)
+	}
+	return callback, nil
+}
+
+// pinsFor returns the fingerprints pinned for host.
+func (v *Verifier) pinsFor(host string) []string {
+	var pins []string
+	for _, pin := range v.Pins {
+		pinHost, fingerprint, scoped := strings.Cut(pin, "=")
+		if !scoped {
+			pins = append(pins, pin)
+		} else if pinHost == host {
+			pins = append(pins, fingerprint)
+		}
+	}
+	return pins
+}
+
+// remember adds key for addr to the store unless known already has it.
+func (v *Verifier) remember(addr string, key ssh.PublicKey, known ssh.HostKeyCallback) error {
+	if known != nil && known(addr, &net.TCPAddr{}, key) == nil {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(v.StorePath), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(v.StorePath), err)
+	}
+	f, err := os.OpenFile(v.StorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", v.StorePath, err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n"
+	if _, err := f.WriteString(line); err != nil {
+		_ = f.Close() //nolint:errcheck // Already failing
+		return fmt.Errorf("failed to save host key: %w", err)
+	}
+	return f.Close()
+}
+
+// errScanned aborts the handshake once the key has been captured.
+var errScanned = errors.New("host key captured")
+
+// Scan connects to addr with dial, or directly when it is nil, and returns
+// the server's host key without authenticating. algorithms restricts the key
+// types the server may offer.
+func Scan(ctx context.Context, dial DialFunc, addr string, algorithms []string, timeout time.Duration) (ssh.PublicKey, error) {
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	if dial == nil {
+		dialer := net.Dialer{Timeout: timeout}
+		dial = dialer.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer func() {
+		_ = conn.Close() //nolint:errcheck // Only used for the key exchange
+	}()
+	_ = conn.SetDeadline(time.Now().Add(timeout)) //nolint:errcheck // Best-effort
+
+	var key ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User:              "tunnel-dash",
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(_ string, _ net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errScanned
+		},
+	}
+	_, _, _, err = ssh.NewClientConn(conn, addr, config)
+	if key == nil {
+		return nil, fmt.Errorf("failed to read host key of %s: %w", addr, err)
+	}
+	return key, nil
+}
+
+// KnownAlgorithms returns the host key algorithms that callback has keys for
+// at addr, or nil to let the server choose. Asking for the known types keeps
+// a server with several keys from presenting one that is not on record.
+func KnownAlgorithms(callback ssh.HostKeyCallback, addr string) []string {
+	if callback == nil {
+		return nil
+	}
+
+	// Checking a throwaway key makes the callback list the keys it knows
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(addr, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		algorithms = append(algorithms, keyAlgorithms(known.Key.Type())...)
+	}
+	return algorithms
+}
+
+// keyAlgorithms maps a key type to the signature algorithms that use it.
+func keyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
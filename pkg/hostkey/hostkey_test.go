@@ -0,0 +1,164 @@
+package hostkey
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startServer runs an SSH server that only completes key exchange and
+// returns its host, port and key fingerprint.
+func startServer(t *testing.T) (string, int, string) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum, ssh.FingerprintSHA256(signer.PublicKey())
+}
+
+func TestVerifyTrustOnFirstUse(t *testing.T) {
+	host, port, fingerprint := startServer(t)
+	store := filepath.Join(t.TempDir(), "tunnel-dash", "known_hosts")
+
+	asked := 0
+	v := &Verifier{
+		StorePath: store,
+		Confirm: func(_, _, got string) bool {
+			asked++
+			return got == fingerprint
+		},
+	}
+
+	got, err := v.Verify(context.Background(), host, port)
+	if err != nil || got != fingerprint {
+		t.Fatalf("Verify() = %q, %v; want %q", got, err, fingerprint)
+	}
+	if _, err := os.Stat(store); err != nil {
+		t.Fatalf("expected the key to be stored: %v", err)
+	}
+
+	// Known keys are accepted without asking again
+	if _, err := v.Verify(context.Background(), host, port); err != nil || asked != 1 {
+		t.Errorf("second Verify() error = %v, asked %d times", err, asked)
+	}
+
+	declined := &Verifier{StorePath: filepath.Join(t.TempDir(), "known_hosts")}
+	if _, err := declined.Verify(context.Background(), host, port); !errors.Is(err, ErrNotTrusted) {
+		t.Errorf("expected ErrNotTrusted without confirmation, got %v", err)
+	}
+}
+
+func TestVerifyRejectsChangedKey(t *testing.T) {
+	host, port, _ := startServer(t)
+	store := filepath.Join(t.TempDir(), "known_hosts")
+	accept := func(string, string, string) bool { return true }
+
+	if _, err := (&Verifier{StorePath: store, Confirm: accept}).Verify(context.Background(), host, port); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another server with a different key on the same address
+	data, err := os.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHost, otherPort, _ := startServer(t)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	otherAddr := net.JoinHostPort(otherHost, strconv.Itoa(otherPort))
+	swapped := strings.Replace(string(data), "["+host+"]:"+strconv.Itoa(port), "["+otherHost+"]:"+strconv.Itoa(otherPort), 1)
+	if err := os.WriteFile(store, []byte(swapped), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&Verifier{StorePath: store, Confirm: accept}).Verify(context.Background(), otherHost, otherPort)
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected MismatchError for %s (key of %s), got %v", otherAddr, addr, err)
+	}
+	if mismatch.Pinned || mismatch.Line != 1 || !strings.Contains(err.Error(), "REMOTE HOST KEY HAS CHANGED") {
+		t.Errorf("unexpected mismatch: %+v", mismatch)
+	}
+}
+
+func TestVerifyPinnedFingerprint(t *testing.T) {
+	host, port, fingerprint := startServer(t)
+
+	pinned := &Verifier{
+		StorePath: filepath.Join(t.TempDir(), "known_hosts"),
+		Pins:      []string{"other.example.com=SHA256:unrelated", host + "=" + fingerprint},
+	}
+	if _, err := pinned.Verify(context.Background(), host, port); err != nil {
+		t.Errorf("Verify() with matching pin error = %v", err)
+	}
+
+	wrong := &Verifier{
+		StorePath: filepath.Join(t.TempDir(), "known_hosts"),
+		Pins:      []string{"SHA256:notthekey"},
+		Confirm:   func(string, string, string) bool { return true },
+	}
+	_, err := wrong.Verify(context.Background(), host, port)
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) || !mismatch.Pinned {
+		t.Errorf("expected pinned MismatchError, got %v", err)
+	}
+}
+
+func TestVerifyThroughDial(t *testing.T) {
+	host, port, fingerprint := startServer(t)
+
+	var dialed []string
+	v := &Verifier{
+		StorePath: filepath.Join(t.TempDir(), "known_hosts"),
+		Pins:      []string{fingerprint},
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	if _, err := v.Verify(context.Background(), host, port); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if want := net.JoinHostPort(host, strconv.Itoa(port)); len(dialed) != 1 || dialed[0] != want {
+		t.Errorf("dialed %v, want [%s]", dialed, want)
+	}
+}
@@ -0,0 +1,115 @@
+// Package localexec is a transport for this machine: commands run in a local
+// shell and forwards connect directly, so the scanner, detectors and tunnel
+// manager can serve a dashboard for local services without SSH.
+package localexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+var (
+	_ transport.Transport  = (*Client)(nil)
+	_ transport.Dialer     = (*Client)(nil)
+	_ transport.PortBinder = (*Client)(nil)
+)
+
+// ErrUnsupported is returned for forwards that only make sense with a
+// remote host, such as reverse forwards.
+var ErrUnsupported = errors.New("not supported in local mode")
+
+// Client is the local transport. The zero value is ready to use.
+type Client struct{}
+
+// NewClient creates a local transport.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// Run executes command with sh -c (cmd /C on Windows) and returns its
+// standard output. Failures are classified like remote ones.
+func (c *Client) Run(ctx context.Context, command string) ([]byte, error) {
+	cmd := shellCommand(ctx, command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		exitCode := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		return output, transport.NewError(command, err, exitCode, stderr.String())
+	}
+	return output, nil
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// Dial connects to addr directly.
+func (c *Client) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// Forward listens on f's local end and relays every connection to its target
+// on this machine, such as a container IP or a Unix socket. Dynamic and
+// reverse forwards return ErrUnsupported.
+func (c *Client) Forward(ctx context.Context, f transport.Forward) (transport.Handle, error) {
+	if f.Kind != transport.ForwardLocal {
+		return nil, fmt.Errorf("%s forward: %w", f.Kind, ErrUnsupported)
+	}
+
+	var ln net.Listener
+	var err error
+	if f.LocalSocket != "" {
+		ln, err = net.Listen("unix", f.LocalSocket)
+	} else {
+		ln, err = net.Listen("tcp", transport.LocalAddr(f.LocalPort))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	network, target := "tcp", net.JoinHostPort(transport.TargetHost(f.RemoteHost), strconv.Itoa(f.RemotePort))
+	if f.RemoteSocket != "" {
+		network, target = "unix", f.RemoteSocket
+	}
+
+	h := transport.Serve(ln, func(net.Conn) (net.Conn, error) {
+		return net.DialTimeout(network, target, 5*time.Second)
+	})
+	go func() {
+		<-ctx.Done()
+		h.Finish(ctx.Err())
+	}()
+	return h, nil
+}
+
+// ForwardAnyPort implements transport.PortBinder.
+func (c *Client) ForwardAnyPort(ctx context.Context, f transport.Forward) (transport.Handle, int, error) {
+	f.Kind, f.LocalPort, f.LocalSocket = transport.ForwardLocal, 0, ""
+	h, err := c.Forward(ctx, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h, h.(*transport.ListenHandle).Port(), nil
+}
@@ -0,0 +1,99 @@
+package localexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	c := NewClient()
+
+	output, err := c.Run(context.Background(), "echo hello")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if string(output) != "hello\n" {
+		t.Errorf("Run() output = %q, want %q", output, "hello\n")
+	}
+
+	_, err = c.Run(context.Background(), "tunnel-dash-missing-command")
+	if kind := transport.KindOf(err); kind != transport.KindNotFound {
+		t.Errorf("expected %s for a missing command, got %s (%v)", transport.KindNotFound, kind, err)
+	}
+}
+
+func TestForwardLocal(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localPort := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	c := NewClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := c.Forward(ctx, transport.Forward{
+		Kind:       transport.ForwardLocal,
+		LocalPort:  localPort,
+		RemoteHost: "127.0.0.1",
+		RemotePort: echo.Addr().(*net.TCPAddr).Port,
+	})
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", probe.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial forward: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected echoed ping, got %q", buf)
+	}
+
+	cancel()
+	if err := h.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() = %v, want context.Canceled", err)
+	}
+
+	if _, err := c.Forward(context.Background(), transport.Forward{Kind: transport.ForwardReverse, RemotePort: 8000, LocalPort: 3000}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a reverse forward, got %v", err)
+	}
+}
@@ -0,0 +1,551 @@
+// Package nativessh is a transport built on golang.org/x/crypto/ssh. It keeps
+// one connection to the server, runs commands as sessions and carries every
+// forward as a channel on that connection, so no ssh binary is needed.
+package nativessh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/hostkey"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+const (
+	// defaultTimeout bounds connecting and authenticating.
+	defaultTimeout = 15 * time.Second
+	// keepaliveInterval is how often the connection is probed, so a dead
+	// network is noticed even when no forward is in use.
+	keepaliveInterval = 30 * time.Second
+)
+
+var (
+	// ErrUnknownHost is returned when the server's key is not in known_hosts.
+	ErrUnknownHost = errors.New("host key is not in known_hosts")
+	// ErrHostKeyMismatch is returned when known_hosts has a different key for
+	// the server, which may mean someone is intercepting the connection.
+	ErrHostKeyMismatch = errors.New("host key does not match known_hosts")
+	// ErrConnectionLost is returned by forwards when the connection drops.
+	ErrConnectionLost = errors.New("ssh connection lost")
+)
+
+// Client implements transport.Transport without the OpenSSH binary.
+var (
+	_ transport.Transport = (*Client)(nil)
+	_ transport.Dialer    = (*Client)(nil)
+)
+
+type Config struct {
+	Server string
+	// Port is the SSH port, 22 when zero
+	Port int
+	User string
+	// IdentityFiles are private keys tried after the ssh-agent at
+	// SSH_AUTH_SOCK. The usual ~/.ssh/id_* keys are tried when empty.
+	IdentityFiles []string
+	// KnownHostsFiles are checked for the server's key, ~/.ssh/known_hosts
+	// when empty
+	KnownHostsFiles []string
+	Insecure        bool
+	Timeout         time.Duration
+	// Jumps are connected through in order, like ProxyJump. Known hosts,
+	// identities, the timeout and Prompt default to the target's.
+	Jumps []Config
+	// Prompt asks the user for key passphrases, passwords and
+	// keyboard-interactive answers such as one-time codes. Encrypted keys
+	// are skipped and only public keys are tried when it is nil.
+	Prompt PromptFunc
+	// Dial opens the TCP connection to the first hop, e.g. through a
+	// proxy. The connection is direct when it is nil.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// PromptFunc asks the user prompt and returns the answer. echo is set when
+// the answer is not secret.
+type PromptFunc func(prompt string, echo bool) (string, error)
+
+type Client struct {
+	config Config
+
+	mu   sync.Mutex
+	conn *ssh.Client
+	// dead is closed when conn stops working
+	dead chan struct{}
+}
+
+func NewClient(config Config) *Client {
+	if config.Port == 0 {
+		config.Port = 22
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	return &Client{config: config}
+}
+
+// Run executes command in a new session and returns its standard output.
+// A non-zero exit status is returned as an *ssh.ExitError wrapped with the
+// command's stderr.
+func (c *Client) Run(ctx context.Context, command string) ([]byte, error) {
+	conn, _, err := c.connect(ctx)
+	if err != nil {
+		return nil, transport.NewError(command, err, -1, "")
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, transport.NewError(command, fmt.Errorf("failed to open session: %w", err), -1, "")
+	}
+	defer func() {
+		_ = session.Close() //nolint:errcheck // Session may already be closed
+	}()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	result := make(chan error, 1)
+	go func() {
+		result <- session.Run(command)
+	}()
+
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL) //nolint:errcheck // Not every server supports signals
+		return nil, transport.NewError(command, ctx.Err(), -1, "")
+	}
+
+	if err != nil {
+		exitCode := -1
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitStatus()
+		}
+		return stdout.Bytes(), transport.NewError(command, err, exitCode, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// Dial opens a connection to addr from the server over the shared connection.
+func (c *Client) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, _, err := c.connect(ctx)
+	if err != nil {
+		return nil, transport.NewError("dial "+addr, err, -1, "")
+	}
+	return conn.DialContext(ctx, network, addr)
+}
+
+// Close closes the connection. It is reopened by the next command or forward.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// connect returns the shared connection, dialing it if there is none or the
+// previous one has died. The returned channel is closed when it dies.
+func (c *Client) connect(ctx context.Context) (*ssh.Client, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		select {
+		case <-c.dead:
+		default:
+			return c.conn, c.dead, nil
+		}
+	}
+
+	conn, hops, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dead := make(chan struct{})
+	c.conn = conn
+	c.dead = dead
+
+	go func() {
+		_ = conn.Wait() //nolint:errcheck // Any exit means the connection is gone
+		for i := len(hops) - 1; i >= 0; i-- {
+			_ = hops[i].Close() //nolint:errcheck // Jump connections only carried conn
+		}
+		close(dead)
+	}()
+	go keepalive(conn, dead)
+
+	return conn, dead, nil
+}
+
+// dial connects to the server through the jump hosts, if any. The returned
+// hops are the jump connections, which must be closed with the connection.
+func (c *Client) dial(ctx context.Context) (*ssh.Client, []*ssh.Client, error) {
+	var hops []*ssh.Client
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			_ = hops[i].Close() //nolint:errcheck // Tearing down a failed chain
+		}
+	}
+
+	var via *ssh.Client
+	for _, hop := range c.config.Jumps {
+		hop = c.inherit(hop)
+		conn, err := dialHop(ctx, hop, via)
+		if err != nil {
+			closeHops()
+			return nil, nil, fmt.Errorf("jump host %s: %w", hop.Server, err)
+		}
+		hops = append(hops, conn)
+		via = conn
+	}
+
+	conn, err := dialHop(ctx, c.config, via)
+	if err != nil {
+		closeHops()
+		return nil, nil, err
+	}
+	return conn, hops, nil
+}
+
+// inherit fills in the settings a jump host shares with the target.
+func (c *Client) inherit(hop Config) Config {
+	if hop.Port == 0 {
+		hop.Port = 22
+	}
+	if len(hop.KnownHostsFiles) == 0 {
+		hop.KnownHostsFiles = c.config.KnownHostsFiles
+	}
+	if len(hop.IdentityFiles) == 0 {
+		hop.IdentityFiles = c.config.IdentityFiles
+	}
+	if hop.Timeout <= 0 {
+		hop.Timeout = c.config.Timeout
+	}
+	hop.Insecure = hop.Insecure || c.config.Insecure
+	if hop.Prompt == nil {
+		hop.Prompt = c.config.Prompt
+	}
+	if hop.Dial == nil {
+		hop.Dial = c.config.Dial
+	}
+	return hop
+}
+
+// dialHop connects and authenticates to config's server, directly or through
+// a channel of via when it is not nil.
+func dialHop(ctx context.Context, config Config, via *ssh.Client) (*ssh.Client, error) {
+	addr := net.JoinHostPort(config.Server, strconv.Itoa(config.Port))
+
+	// The user's time to answer does not count against the timeout
+	var timer *time.Timer
+	if prompt := config.Prompt; prompt != nil {
+		config.Prompt = func(question string, echo bool) (string, error) {
+			timer.Stop()
+			defer timer.Reset(config.Timeout)
+			return prompt(question, echo)
+		}
+	}
+
+	// The agent is only needed to sign during the handshake
+	keyring, closeAgent := openAgent()
+	defer closeAgent()
+
+	clientConfig, err := newClientConfig(config, addr, keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	var netConn net.Conn
+	if via != nil {
+		netConn, err = via.DialContext(ctx, "tcp", addr)
+	} else if config.Dial != nil {
+		dialCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+		netConn, err = config.Dial(dialCtx, "tcp", addr)
+		cancel()
+	} else {
+		dialer := net.Dialer{Timeout: config.Timeout}
+		netConn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	// The handshake has no context of its own and channels have no
+	// deadlines, so a timer bounds it
+	timer = time.AfterFunc(config.Timeout, func() {
+		_ = netConn.Close() //nolint:errcheck // Aborts the handshake
+	})
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, clientConfig)
+	if !timer.Stop() && err == nil {
+		err = fmt.Errorf("timed out")
+	}
+	if err != nil {
+		_ = netConn.Close() //nolint:errcheck // Handshake already failed
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", addr, err)
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+func newClientConfig(config Config, addr string, keyring agent.Agent) (*ssh.ClientConfig, error) {
+	clientConfig := &ssh.ClientConfig{
+		User: config.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return signers(keyring, config.IdentityFiles, config.Prompt)
+		})},
+		Timeout: config.Timeout,
+	}
+	if prompt := config.Prompt; prompt != nil {
+		clientConfig.Auth = append(clientConfig.Auth,
+			ssh.KeyboardInteractive(func(_, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i, question := range questions {
+					if i == 0 && instruction != "" {
+						question = instruction + "\n" + question
+					}
+					answer, err := prompt(question, echos[i])
+					if err != nil {
+						return nil, err
+					}
+					answers[i] = answer
+				}
+				return answers, nil
+			}),
+			ssh.PasswordCallback(func() (string, error) {
+				return prompt(fmt.Sprintf("%s@%s's password: ", config.User, config.Server), false)
+			}),
+		)
+	}
+
+	if config.Insecure {
+		clientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey() //nolint:gosec // Explicitly requested with --insecure
+		return clientConfig, nil
+	}
+
+	callback, err := knownHosts(config.KnownHostsFiles)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig.HostKeyCallback = checkHostKey(callback)
+	// Ask for the key types we already know, otherwise the server may pick
+	// one that is missing from known_hosts and look like a mismatch
+	clientConfig.HostKeyAlgorithms = hostkey.KnownAlgorithms(callback, addr)
+	return clientConfig, nil
+}
+
+// knownHosts returns a knownhosts callback for files, ~/.ssh/known_hosts
+// when empty.
+func knownHosts(files []string) (ssh.HostKeyCallback, error) {
+	if len(files) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find known_hosts: %w", err)
+		}
+		files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+
+	var existing []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("%w: no known_hosts file at %s", ErrUnknownHost, strings.Join(files, ", "))
+	}
+
+	callback, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// checkHostKey turns knownhosts errors into ErrUnknownHost and
+// ErrHostKeyMismatch with the key's fingerprint.
+func checkHostKey(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("%w: %s presented %s %s", ErrUnknownHost, hostname, key.Type(), ssh.FingerprintSHA256(key))
+			}
+			return fmt.Errorf("%w: %s presented %s %s, expected the key at %s:%d", ErrHostKeyMismatch,
+				hostname, key.Type(), ssh.FingerprintSHA256(key), keyErr.Want[0].Filename, keyErr.Want[0].Line)
+		}
+		return err
+	}
+}
+
+// openAgent connects to the agent at SSH_AUTH_SOCK. It returns a nil agent
+// when there is none, and a function that closes the connection.
+func openAgent() (agent.Agent, func()) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, func() {}
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, func() {}
+	}
+	return agent.NewClient(conn), func() {
+		_ = conn.Close() //nolint:errcheck // Nothing left to sign
+	}
+}
+
+// signers returns keyring's keys followed by the identity files. Keys that
+// cannot be read, or need a passphrase and prompt is nil, are skipped.
+func signers(keyring agent.Agent, files []string, prompt PromptFunc) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+
+	if keyring != nil {
+		if agentSigners, err := keyring.Signers(); err == nil {
+			signers = append(signers, agentSigners...)
+		}
+	}
+
+	if len(files) == 0 {
+		files = defaultIdentityFiles()
+	}
+	var problems []string
+	for _, file := range files {
+		signer, err := loadKey(file, prompt)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				problems = append(problems, err.Error())
+			}
+			continue
+		}
+		// Like ssh, a certificate next to the key is offered before the key
+		if certSigner, err := loadCert(file+"-cert.pub", signer); err == nil {
+			signers = append(signers, certSigner)
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("no usable SSH keys: %s", strings.Join(problems, "; "))
+		}
+		return nil, fmt.Errorf("no SSH keys found, start ssh-agent or pass --key")
+	}
+	return signers, nil
+}
+
+// loadCert returns a signer that presents the certificate at path for key.
+func loadCert(path string, key ssh.Signer) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	cert, ok := public.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", path)
+	}
+	return ssh.NewCertSigner(cert, key)
+}
+
+// passphraseAttempts is how often a passphrase is asked for, like ssh.
+const passphraseAttempts = 3
+
+func loadKey(path string, prompt PromptFunc) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if prompt == nil {
+			return nil, fmt.Errorf("%s is encrypted, add it to ssh-agent", path)
+		}
+		// Retries use OpenSSH's wording, which also keeps a cached wrong
+		// answer from being repeated
+		question := fmt.Sprintf("Enter passphrase for key '%s': ", path)
+		for attempt := 0; attempt < passphraseAttempts; attempt++ {
+			passphrase, promptErr := prompt(question, false)
+			if promptErr != nil {
+				return nil, fmt.Errorf("no passphrase for %s: %w", path, promptErr)
+			}
+			if signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase)); err == nil {
+				return signer, nil
+			}
+			question = fmt.Sprintf("Bad passphrase, try again for %s: ", path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+func defaultIdentityFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		files = append(files, filepath.Join(home, ".ssh", name))
+	}
+	return files
+}
+
+// keepalive sends OpenSSH keepalive requests and closes conn once the server
+// stops answering.
+func keepalive(conn *ssh.Client, dead <-chan struct{}) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dead:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-dead:
+			return
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+		case <-time.After(keepaliveInterval):
+		}
+		_ = conn.Close() //nolint:errcheck // Connection is unusable either way
+		return
+	}
+}
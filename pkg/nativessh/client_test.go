@@ -0,0 +1,448 @@
+package nativessh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// testServer is a minimal SSH server that answers "echo hello" and "fail"
+// and opens direct-tcpip channels to any address.
+type testServer struct {
+	addr    string
+	hostKey ssh.Signer
+	keyPath string
+}
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, private
+}
+
+func startTestServer(t *testing.T) *testServer {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	hostKey, _ := newSigner(t)
+	clientKey, clientPrivate := newSigner(t)
+
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			netConn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(netConn, config)
+		}
+	}()
+
+	return &testServer{addr: ln.Addr().String(), hostKey: hostKey, keyPath: keyPath}
+}
+
+func serveConn(netConn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "session":
+			channel, requests, err := newChan.Accept()
+			if err != nil {
+				continue
+			}
+			go serveSession(channel, requests)
+		case "direct-tcpip":
+			payload := newChan.ExtraData()
+			hostLen := binary.BigEndian.Uint32(payload)
+			host := string(payload[4 : 4+hostLen])
+			port := binary.BigEndian.Uint32(payload[4+hostLen:])
+
+			upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+			if err != nil {
+				_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChan.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				defer channel.Close()
+				defer upstream.Close()
+				go func() { _, _ = io.Copy(upstream, channel) }()
+				_, _ = io.Copy(channel, upstream)
+			}()
+		default:
+			_ = newChan.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+
+		command := string(req.Payload[4:])
+		status := uint32(0)
+		switch command {
+		case "echo hello":
+			_, _ = channel.Write([]byte("hello\n"))
+		default:
+			_, _ = channel.Stderr().Write([]byte("boom\n"))
+			status = 1
+		}
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func (s *testServer) client(t *testing.T, knownKey ssh.PublicKey) *Client {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(s.addr)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, knownKey) + "\n"
+	if err := os.WriteFile(knownHosts, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	portNum, _ := strconv.Atoi(port)
+	c := NewClient(Config{
+		Server:          host,
+		Port:            portNum,
+		User:            "test",
+		IdentityFiles:   []string{s.keyPath},
+		KnownHostsFiles: []string{knownHosts},
+		Timeout:         5 * time.Second,
+	})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRun(t *testing.T) {
+	server := startTestServer(t)
+	c := server.client(t, server.hostKey.PublicKey())
+
+	output, err := c.Run(context.Background(), "echo hello")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if string(output) != "hello\n" {
+		t.Errorf("Run() output = %q, want %q", output, "hello\n")
+	}
+
+	_, err = c.Run(context.Background(), "fail")
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+		t.Fatalf("expected exit status 1, got %v", err)
+	}
+	if got := err.Error(); !strings.HasPrefix(got, "boom: ") {
+		t.Errorf("expected stderr in error, got %q", got)
+	}
+}
+
+func TestAgentClosedAfterHandshake(t *testing.T) {
+	server := startTestServer(t)
+
+	// Unix socket paths are limited to about 100 bytes, too few for t.TempDir
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	closed := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = agent.ServeAgent(agent.NewKeyring(), conn)
+		close(closed)
+	}()
+
+	c := server.client(t, server.hostKey.PublicKey())
+	if _, err := c.Run(context.Background(), "echo hello"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the agent connection to be closed after the handshake")
+	}
+}
+
+func TestHostKeyVerification(t *testing.T) {
+	server := startTestServer(t)
+	otherKey, _ := newSigner(t)
+
+	c := server.client(t, otherKey.PublicKey())
+	if _, err := c.Run(context.Background(), "echo hello"); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("expected ErrHostKeyMismatch, got %v", err)
+	}
+
+	c = NewClient(Config{
+		Server:          "127.0.0.1",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "missing")},
+	})
+	if _, err := c.Run(context.Background(), "echo hello"); !errors.Is(err, ErrUnknownHost) {
+		t.Errorf("expected ErrUnknownHost, got %v", err)
+	}
+}
+
+func TestForwardLocal(t *testing.T) {
+	server := startTestServer(t)
+	c := server.client(t, server.hostKey.PublicKey())
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localPort := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := c.Forward(ctx, transport.Forward{
+		Kind:       transport.ForwardLocal,
+		LocalPort:  localPort,
+		RemoteHost: "127.0.0.1",
+		RemotePort: echo.Addr().(*net.TCPAddr).Port,
+	})
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", transport.LocalAddr(localPort))
+	if err != nil {
+		t.Fatalf("failed to dial forward: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected echoed ping, got %q", buf)
+	}
+
+	cancel()
+	if err := h.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() = %v, want context.Canceled", err)
+	}
+}
+
+func TestRunThroughJump(t *testing.T) {
+	jump := startTestServer(t)
+	target := startTestServer(t)
+
+	var lines string
+	for _, s := range []*testServer{jump, target} {
+		lines += knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey.PublicKey()) + "\n"
+	}
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	jumpHost, jumpPort, _ := net.SplitHostPort(jump.addr)
+	jumpPortNum, _ := strconv.Atoi(jumpPort)
+	host, port, _ := net.SplitHostPort(target.addr)
+	portNum, _ := strconv.Atoi(port)
+
+	c := NewClient(Config{
+		Server:          host,
+		Port:            portNum,
+		User:            "test",
+		IdentityFiles:   []string{target.keyPath},
+		KnownHostsFiles: []string{knownHosts},
+		Timeout:         5 * time.Second,
+		Jumps: []Config{{
+			Server:        jumpHost,
+			Port:          jumpPortNum,
+			User:          "jump",
+			IdentityFiles: []string{jump.keyPath},
+		}},
+	})
+	defer c.Close()
+
+	output, err := c.Run(context.Background(), "echo hello")
+	if err != nil || string(output) != "hello\n" {
+		t.Fatalf("Run() through jump = %q, %v", output, err)
+	}
+
+	// A jump host with an unknown key stops the chain
+	c = NewClient(Config{
+		Server:          host,
+		Port:            portNum,
+		KnownHostsFiles: []string{knownHosts},
+		Jumps:           []Config{{Server: "127.0.0.1", Port: jumpPortNum, KnownHostsFiles: []string{filepath.Join(t.TempDir(), "missing")}}},
+	})
+	if _, err := c.Run(context.Background(), "echo hello"); !errors.Is(err, ErrUnknownHost) {
+		t.Errorf("expected ErrUnknownHost from the jump host, got %v", err)
+	}
+}
+
+func TestLoadKeyPassphrase(t *testing.T) {
+	_, private := newSigner(t)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadKey(keyPath, nil); err == nil || !strings.Contains(err.Error(), "ssh-agent") {
+		t.Errorf("expected the key to be skipped without a prompt, got %v", err)
+	}
+
+	var asked []string
+	answers := []string{"wrong", "secret"}
+	signer, err := loadKey(keyPath, func(prompt string, echo bool) (string, error) {
+		if echo {
+			t.Error("passphrase prompt must not echo")
+		}
+		asked = append(asked, prompt)
+		return answers[len(asked)-1], nil
+	})
+	if err != nil {
+		t.Fatalf("loadKey() error = %v", err)
+	}
+	if signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("unexpected key type %s", signer.PublicKey().Type())
+	}
+	if len(asked) != 2 || asked[0] == asked[1] {
+		t.Errorf("expected a second, different prompt after a wrong passphrase, got %q", asked)
+	}
+}
+
+func TestSignersWithCertificate(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer, private := newSigner(t)
+	ca, _ := newSigner(t)
+
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:         signer.PublicKey(),
+		CertType:    ssh.UserCert,
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := signers(nil, []string{keyPath}, nil)
+	if err != nil {
+		t.Fatalf("signers() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected the certificate and the key, got %d signer(s)", len(got))
+	}
+	if _, ok := got[0].PublicKey().(*ssh.Certificate); !ok {
+		t.Errorf("expected the certificate first, got %s", got[0].PublicKey().Type())
+	}
+}
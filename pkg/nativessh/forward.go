@@ -0,0 +1,200 @@
+package nativessh
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// Forward opens f on the shared connection. Local and dynamic forwards listen
+// on this machine and open a direct-tcpip (or streamlocal) channel per
+// connection, reverse forwards ask the server to listen with tcpip-forward.
+func (c *Client) Forward(ctx context.Context, f transport.Forward) (transport.Handle, error) {
+	op := string(f.Kind) + " forward"
+	conn, dead, err := c.connect(ctx)
+	if err != nil {
+		return nil, transport.NewError(op, err, -1, "")
+	}
+
+	var ln net.Listener
+	var dial func(net.Conn) (net.Conn, error)
+
+	switch f.Kind {
+	case transport.ForwardReverse:
+		bind := f.BindAddress
+		if bind == "" {
+			bind = "127.0.0.1"
+		}
+		ln, err = conn.Listen("tcp", net.JoinHostPort(bind, strconv.Itoa(f.RemotePort)))
+		if err != nil {
+			return nil, transport.NewError(op, fmt.Errorf("server refused to listen on %s:%d: %w", bind, f.RemotePort, err), -1, "")
+		}
+		local := transport.LocalAddr(f.LocalPort)
+		dial = func(net.Conn) (net.Conn, error) {
+			return net.DialTimeout("tcp", local, 5*time.Second)
+		}
+
+	case transport.ForwardDynamic:
+		if ln, err = net.Listen("tcp", transport.LocalAddr(f.LocalPort)); err != nil {
+			return nil, err
+		}
+		dial = func(client net.Conn) (net.Conn, error) {
+			target, err := socksHandshake(client)
+			if err != nil {
+				return nil, err
+			}
+			upstream, err := conn.Dial("tcp", target)
+			socksReply(client, err)
+			return upstream, err
+		}
+
+	default:
+		if f.LocalSocket != "" {
+			ln, err = net.Listen("unix", f.LocalSocket)
+		} else {
+			ln, err = net.Listen("tcp", transport.LocalAddr(f.LocalPort))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		network, target := "tcp", net.JoinHostPort(transport.TargetHost(f.RemoteHost), strconv.Itoa(f.RemotePort))
+		if f.RemoteSocket != "" {
+			network, target = "unix", f.RemoteSocket
+		}
+		dial = func(net.Conn) (net.Conn, error) {
+			return conn.Dial(network, target)
+		}
+	}
+
+	h := transport.Serve(ln, dial)
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.Finish(ctx.Err())
+		case <-dead:
+			h.Finish(ErrConnectionLost)
+		}
+	}()
+	return h, nil
+}
+
+// ForwardAnyPort implements transport.PortBinder.
+func (c *Client) ForwardAnyPort(ctx context.Context, f transport.Forward) (transport.Handle, int, error) {
+	f.Kind, f.LocalPort, f.LocalSocket = transport.ForwardLocal, 0, ""
+	h, err := c.Forward(ctx, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h, h.(*transport.ListenHandle).Port(), nil
+}
+
+// SOCKS5 constants from RFC 1928.
+const (
+	socksVersion     = 5
+	socksNoAuth      = 0
+	socksNoMethods   = 0xff
+	socksConnect     = 1
+	socksIPv4        = 1
+	socksDomain      = 3
+	socksIPv6        = 4
+	socksSucceeded   = 0
+	socksHostFailure = 4
+	socksBadCommand  = 7
+)
+
+var errSOCKS = errors.New("invalid SOCKS5 request")
+
+// socksHandshake reads a SOCKS5 greeting and CONNECT request from client and
+// returns the requested host:port. Names are resolved on the server, like
+// ssh -D does.
+func socksHandshake(client net.Conn) (string, error) {
+	_ = client.SetDeadline(time.Now().Add(10 * time.Second)) //nolint:errcheck // Best-effort
+	defer func() {
+		_ = client.SetDeadline(time.Time{}) //nolint:errcheck // Best-effort
+	}()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", errSOCKS
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(client, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoMethods)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := client.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method != socksNoAuth {
+		return "", errSOCKS
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(client, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", errSOCKS
+	}
+	if request[1] != socksConnect {
+		_, _ = client.Write([]byte{socksVersion, socksBadCommand, 0, socksIPv4, 0, 0, 0, 0, 0, 0}) //nolint:errcheck // Closing anyway
+		return "", errSOCKS
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		size := net.IPv4len
+		if request[3] == socksIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(client, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(client, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(client, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errSOCKS
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(client, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply tells the client whether the server could reach its target.
+func socksReply(client net.Conn, err error) {
+	status := byte(socksSucceeded)
+	if err != nil {
+		status = socksHostFailure
+	}
+	_, _ = client.Write([]byte{socksVersion, status, 0, socksIPv4, 0, 0, 0, 0, 0, 0}) //nolint:errcheck // Client sees the failure on read
+}
@@ -0,0 +1,310 @@
+// Package proxy connects through HTTP CONNECT and SOCKS5 proxies, for
+// networks where SSH can only get out through one. The native backend dials
+// with it directly, and the ssh binary uses it through "tunnel-dash
+// proxy-connect" as its ProxyCommand.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// URLEnv passes the proxy URL to "tunnel-dash proxy-connect".
+const URLEnv = "TUNNEL_DASH_PROXY"
+
+// handshakeTimeout bounds the proxy handshake when the context has no deadline.
+const handshakeTimeout = 30 * time.Second
+
+// ErrRefused is returned when the proxy declines to connect to the target.
+var ErrRefused = errors.New("proxy refused the connection")
+
+// Proxy is a parsed proxy URL.
+type Proxy struct {
+	url *url.URL
+}
+
+// Parse parses http://[user:password@]host[:port] or
+// socks5://[user:password@]host[:port]. The ports default to 80 and 1080.
+// socks5h is accepted as well; host names are always resolved by the proxy.
+func Parse(raw string) (*Proxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http:// or socks5://)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("proxy URL %q must not have a path", raw)
+	}
+	return &Proxy{url: u}, nil
+}
+
+// String returns the proxy URL without its password.
+func (p *Proxy) String() string {
+	return p.url.Redacted()
+}
+
+// address returns the proxy's host:port.
+func (p *Proxy) address() string {
+	port := p.url.Port()
+	if port == "" {
+		port = "80"
+		if p.url.Scheme != "http" {
+			port = "1080"
+		}
+	}
+	return net.JoinHostPort(p.url.Hostname(), port)
+}
+
+// DialContext connects to addr through the proxy. Only TCP is supported.
+func (p *Proxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("proxy cannot carry %s connections", network)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.address())
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy %s: %w", p, err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+	_ = conn.SetDeadline(deadline) //nolint:errcheck // Best-effort
+
+	tunnel := conn
+	if p.url.Scheme == "http" {
+		tunnel, err = p.connectHTTP(conn, addr)
+	} else {
+		err = p.connectSOCKS5(conn, addr)
+	}
+	if err != nil {
+		_ = conn.Close() //nolint:errcheck // Already failing
+		return nil, fmt.Errorf("proxy %s: %w", p, err)
+	}
+
+	_ = conn.SetDeadline(time.Time{}) //nolint:errcheck // Best-effort
+	return tunnel, nil
+}
+
+// connectHTTP asks an HTTP proxy to open a tunnel with CONNECT.
+func (p *Proxy) connectHTTP(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := p.url.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONNECT response: %w", err)
+	}
+	_ = resp.Body.Close() //nolint:errcheck // CONNECT responses have no body
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w to %s: %s", ErrRefused, addr, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		// The server may already have sent its banner
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes read ahead with the CONNECT response first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929).
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksUserPass     = 2
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+)
+
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// connectSOCKS5 negotiates a CONNECT to addr with a SOCKS5 proxy.
+func (p *Proxy) connectSOCKS5(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+
+	methods := []byte{socksNoAuth}
+	if p.url.User != nil {
+		methods = []byte{socksUserPass}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("invalid SOCKS5 greeting: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if err := p.authenticateSOCKS5(conn); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		return fmt.Errorf("SOCKS5 proxy requires authentication, add user:password@ to the URL")
+	default:
+		return fmt.Errorf("unsupported SOCKS5 authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, socksIPv4), ip4...)
+		} else {
+			req = append(append(req, socksIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long for SOCKS5")
+		}
+		req = append(append(req, socksDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("invalid SOCKS5 reply: %w", err)
+	}
+	if header[1] != 0 {
+		reason, ok := socksReplies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("error %d", header[1])
+		}
+		return fmt.Errorf("%w to %s: %s", ErrRefused, addr, reason)
+	}
+
+	// Skip the bound address, which is of no use here
+	var skip int
+	switch header[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("invalid SOCKS5 address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// authenticateSOCKS5 sends the URL's user name and password.
+func (p *Proxy) authenticateSOCKS5(conn net.Conn) error {
+	user := p.url.User.Username()
+	password, _ := p.url.User.Password()
+	if len(user) > 255 || len(password) > 255 {
+		return fmt.Errorf("SOCKS5 credentials too long")
+	}
+
+	req := append([]byte{1, byte(len(user))}, user...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("SOCKS5 proxy rejected the user name or password")
+	}
+	return nil
+}
+
+// Relay copies between conn and the standard streams, as a ProxyCommand does
+// for ssh, until the server closes the connection. The end of stdin is passed
+// on as a half close.
+func Relay(conn net.Conn, stdin io.Reader, stdout io.Writer) error {
+	go func() {
+		_, _ = io.Copy(conn, stdin) //nolint:errcheck // Ends with ssh
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite() //nolint:errcheck // Peer may already be gone
+			return
+		}
+		_ = conn.Close() //nolint:errcheck // Cannot half close
+	}()
+	_, err := io.Copy(stdout, conn)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
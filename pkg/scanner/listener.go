@@ -0,0 +1,147 @@
+package scanner
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Family is the address family of a listening socket.
+type Family string
+
+const (
+	FamilyIPv4 Family = "ipv4"
+	FamilyIPv6 Family = "ipv6"
+)
+
+// Scope tells which connections a listener accepts.
+type Scope string
+
+const (
+	// ScopeWildcard listeners accept connections on every address
+	ScopeWildcard Scope = "wildcard"
+	// ScopeLoopback listeners only accept connections from the server itself
+	ScopeLoopback Scope = "loopback"
+	// ScopeInterface listeners are bound to the address of one interface
+	ScopeInterface Scope = "interface"
+)
+
+// Listener is a listening TCP socket on the server.
+type Listener struct {
+	// Address is the bound address, such as "127.0.0.1", "::" or
+	// "10.0.3.7", and "*" for sockets that ss shows as accepting both
+	// families
+	Address string `json:"address"`
+	// Family is empty for "*"
+	Family Family `json:"family,omitempty"`
+	Port   int    `json:"port"`
+	Scope  Scope  `json:"scope"`
+	// Interface is the device a socket is bound to, e.g. "lo" for
+	// 127.0.0.53%lo or "eth0" for a link-local address
+	Interface string `json:"interface,omitempty"`
+	// Process owns the socket, nil when ss or netstat did not name it
+	Process *Process `json:"process,omitempty"`
+}
+
+// String formats the listener as ss does, e.g. "[::1]:8080".
+func (l Listener) String() string {
+	if l.Address == "*" {
+		return "*:" + strconv.Itoa(l.Port)
+	}
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+// TargetHost returns the host a tunnel to the listener connects to from the
+// server. It is empty when the default "localhost" reaches it, and the bound
+// address otherwise, such as a private IP or 127.0.0.53.
+func (l Listener) TargetHost() string {
+	if l.Scope == ScopeWildcard || l.Address == "127.0.0.1" || l.Address == "::1" {
+		return ""
+	}
+	if ip := net.ParseIP(l.Address); ip != nil && ip.IsLinkLocalUnicast() && l.Interface != "" {
+		return l.Address + "%" + l.Interface
+	}
+	return l.Address
+}
+
+// rank orders listeners on the same port by how directly a tunnel reaches
+// them, lowest first.
+func (l Listener) rank() int {
+	switch {
+	case l.Scope == ScopeWildcard:
+		return 0
+	case l.TargetHost() == "":
+		return 1
+	case l.Scope == ScopeLoopback:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Preferred returns one listener per port, in the order the ports first
+// appear: the wildcard one if any, then one that "localhost" reaches, then
+// the first of the others.
+func Preferred(listeners []Listener) []Listener {
+	index := make(map[int]int)
+	var result []Listener
+
+	for _, l := range listeners {
+		i, seen := index[l.Port]
+		if !seen {
+			index[l.Port] = len(result)
+			result = append(result, l)
+			continue
+		}
+		if l.rank() < result[i].rank() {
+			result[i] = l
+		}
+	}
+
+	return result
+}
+
+// parseListenerAddress parses the local address column of ss or netstat,
+// such as "0.0.0.0:22", "[::]:22", ":::22", "*:80", "127.0.0.53%lo:53",
+// "[fe80::1]%eth0:8080" or "[::ffff:127.0.0.1]:8080".
+func parseListenerAddress(field string) (Listener, bool) {
+	i := strings.LastIndex(field, ":")
+	if i < 0 {
+		return Listener{}, false
+	}
+	port, err := strconv.Atoi(field[i+1:])
+	if err != nil || port < 1 || port > 65535 {
+		return Listener{}, false
+	}
+
+	host := strings.NewReplacer("[", "", "]", "").Replace(field[:i])
+	host, zone, _ := strings.Cut(host, "%")
+	if host == "*" {
+		return Listener{Address: "*", Port: port, Scope: ScopeWildcard}, true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return Listener{}, false
+	}
+	return newListener(ip, strings.Contains(host, ":"), port, zone), true
+}
+
+// newListener describes a socket bound to ip, which is an IPv4-mapped
+// address for IPv6 sockets accepting IPv4 connections on one address.
+func newListener(ip net.IP, ipv6 bool, port int, zone string) Listener {
+	l := Listener{Address: ip.String(), Family: FamilyIPv4, Port: port, Interface: zone}
+	if ipv6 {
+		l.Family = FamilyIPv6
+	}
+
+	switch {
+	case ip.IsUnspecified():
+		l.Scope = ScopeWildcard
+	case ip.IsLoopback():
+		l.Scope = ScopeLoopback
+	default:
+		l.Scope = ScopeInterface
+	}
+	return l
+}
@@ -1,151 +1,264 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/ssh"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 )
 
+// Scanner lists the listening sockets on the server through a transport.
 type Scanner struct {
-	sshClient    *ssh.Client
-	server       string
-	user         string
-	keyPath      string
-	useHostAlias bool
-	hostAlias    string
-	insecure     bool
+	transport transport.Transport
+	// strategies are tried in order until one lists the listeners
+	strategies []Strategy
 }
 
+// NewScannerWithTransport creates a scanner that runs its commands through tr.
+func NewScannerWithTransport(tr transport.Transport) *Scanner {
+	return &Scanner{transport: tr}
+}
+
+// NewScanner creates a scanner that runs its commands through the ssh
+// binary as user@server with keyPath.
+//
+// Deprecated: Use NewScannerWithTransport, which can share one transport
+// with the tunnel manager and detectors.
 func NewScanner(server, user, keyPath string) *Scanner {
-	s := &Scanner{
-		server:       server,
-		user:         user,
-		keyPath:      keyPath,
-		useHostAlias: false,
-	}
-	s.initClient()
-	return s
+	return NewScannerWithTransport(ssh.NewClient(ssh.Config{Server: server, User: user, KeyPath: keyPath}))
 }
 
+// NewScannerWithHost creates a scanner that runs its commands through the
+// ssh binary on an ssh_config host alias.
+//
+// Deprecated: Use NewScannerWithTransport.
 func NewScannerWithHost(hostAlias string) *Scanner {
-	s := &Scanner{
-		useHostAlias: true,
-		hostAlias:    hostAlias,
-	}
-	s.initClient()
-	return s
+	return NewScannerWithTransport(ssh.NewClient(ssh.Config{UseHostAlias: true, HostAlias: hostAlias}))
 }
 
+// SetInsecure turns off strict host key checking for a scanner created by
+// NewScanner or NewScannerWithHost, before it scans.
+//
+// Deprecated: Set ssh.Config.Insecure on the transport instead.
 func (s *Scanner) SetInsecure(insecure bool) {
-	s.insecure = insecure
-	s.initClient()
+	if client, ok := s.transport.(*ssh.Client); ok {
+		client.SetInsecure(insecure)
+	}
 }
 
-func (s *Scanner) initClient() {
-	config := ssh.Config{
-		Server:       s.server,
-		User:         s.user,
-		KeyPath:      s.keyPath,
-		UseHostAlias: s.useHostAlias,
-		HostAlias:    s.hostAlias,
-		Insecure:     s.insecure,
-	}
-	s.sshClient = ssh.NewClient(config)
+// SetStrategies sets the order in which ss, netstat and /proc are tried,
+// DefaultStrategies when empty.
+func (s *Scanner) SetStrategies(strategies []Strategy) {
+	s.strategies = strategies
+}
+
+// run executes command on the server.
+func (s *Scanner) run(command string) ([]byte, error) {
+	return s.transport.Run(context.Background(), command)
 }
 
+// Result is what a scan found and how.
+type Result struct {
+	Listeners []Listener `json:"listeners"`
+	// Strategy is the one that listed the listeners
+	Strategy Strategy `json:"strategy"`
+}
+
+// ScanPorts returns the listening TCP ports in portRange, once each.
 func (s *Scanner) ScanPorts(portRange string) ([]int, error) {
-	ports, err := s.scanWithSS(portRange)
+	result, err := s.scan(portRange)
 	if err != nil {
-		ports, err = s.scanWithNetstat(portRange)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan ports: %w", err)
+		return nil, fmt.Errorf("failed to scan ports: %w", err)
+	}
+
+	ports := make([]int, 0, len(result.Listeners))
+	for _, l := range result.Listeners {
+		ports = append(ports, l.Port)
+	}
+	return deduplicatePorts(ports), nil
+}
+
+// ScanListeners returns every listening TCP socket with a port in portRange,
+// with the address it is bound to and, where visible, the process that owns
+// it. A port can have several, such as 0.0.0.0:22 and [::]:22.
+func (s *Scanner) ScanListeners(portRange string) (*Result, error) {
+	result, err := s.scan(portRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan ports: %w", err)
+	}
+	s.describeProcesses(result.Listeners)
+	return result, nil
+}
+
+// scan tries the strategies in order until one works.
+func (s *Scanner) scan(portRange string) (*Result, error) {
+	strategies := s.strategies
+	if len(strategies) == 0 {
+		strategies = DefaultStrategies
+	}
+
+	var err error
+	for _, strategy := range strategies {
+		listeners, strategyErr := s.scanWith(strategy, portRange)
+		if strategyErr == nil {
+			return &Result{Listeners: listeners, Strategy: strategy}, nil
+		}
+		// The others would fail the same way when the connection is the problem
+		if transport.KindOf(strategyErr).Connection() {
+			return nil, strategyErr
+		}
+		if err == nil {
+			err = strategyErr
+		} else {
+			err = fallbackError(err, strategyErr)
 		}
 	}
+	return nil, err
+}
 
-	return ports, nil
+func (s *Scanner) scanWith(strategy Strategy, portRange string) ([]Listener, error) {
+	switch strategy {
+	case StrategySS:
+		return s.scanWithSS(portRange)
+	case StrategyNetstat:
+		return s.scanWithNetstat(portRange)
+	case StrategyProc:
+		return s.scanWithProc(portRange)
+	default:
+		return nil, fmt.Errorf("unknown scan strategy %q", strategy)
+	}
 }
 
-func (s *Scanner) scanWithSS(portRange string) ([]int, error) {
-	cmd := s.sshClient.BuildCommand("ss -tlnp")
-	output, err := cmd.Output()
+// fallbackError picks the more useful of two failed attempts: a missing
+// fallback says less than why the first command failed.
+func fallbackError(first, fallback error) error {
+	if transport.KindOf(fallback) == transport.KindNotFound && transport.KindOf(first) != transport.KindNotFound {
+		return first
+	}
+	return fallback
+}
+
+func (s *Scanner) scanWithSS(portRange string) ([]Listener, error) {
+	output, err := s.run("ss -tlnp")
 	if err != nil {
 		return nil, err
 	}
 
-	return parseSSOutput(string(output), portRange)
+	return parseListeners(string(output), portRange), nil
 }
 
-func (s *Scanner) scanWithNetstat(portRange string) ([]int, error) {
-	cmd := s.sshClient.BuildCommand("netstat -tlnp")
-	output, err := cmd.Output()
+func (s *Scanner) scanWithNetstat(portRange string) ([]Listener, error) {
+	output, err := s.run("netstat -tlnp")
 	if err != nil {
 		return nil, err
 	}
 
-	return parseNetstatOutput(string(output), portRange)
+	return parseListeners(string(output), portRange), nil
 }
 
-func parseSSOutput(output, portRange string) ([]int, error) {
-	var ports []int
-	lines := strings.Split(output, "\n")
-
-	portRegex := regexp.MustCompile(`:(\d+)\s`)
-	minPort, maxPort := parsePortRange(portRange)
+// ProcessVisibility reports how many listening sockets show their owning
+// process, and the strategy that listed them. Without root, ss, netstat and
+// /proc only name the processes of the SSH user, so named is usually lower
+// than total.
+func (s *Scanner) ProcessVisibility() (named, total int, strategy Strategy, err error) {
+	result, err := s.scan("")
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to list listeners: %w", err)
+	}
 
-	for _, line := range lines {
-		if !strings.Contains(line, "LISTEN") {
-			continue
-		}
+	named, total = countNamedListeners(result.Listeners)
+	return named, total, result.Strategy, nil
+}
 
-		matches := portRegex.FindStringSubmatch(line)
-		if len(matches) < 2 {
-			continue
+// countNamedListeners counts the listeners and those whose process is known.
+func countNamedListeners(listeners []Listener) (named, total int) {
+	for _, l := range listeners {
+		total++
+		if l.Process != nil {
+			named++
 		}
+	}
+	return named, total
+}
 
-		port, err := strconv.Atoi(matches[1])
-		if err != nil {
-			continue
+// ScanUnixSockets lists the paths of listening Unix domain sockets on the
+// server, such as /var/run/docker.sock. Abstract sockets are skipped.
+func (s *Scanner) ScanUnixSockets() ([]string, error) {
+	output, err := s.run("ss -xl")
+	if err != nil {
+		if transport.KindOf(err).Connection() {
+			return nil, fmt.Errorf("failed to list unix sockets: %w", err)
 		}
-
-		if port >= minPort && port <= maxPort {
-			ports = append(ports, port)
+		var netstatErr error
+		output, netstatErr = s.run("netstat -xl")
+		if netstatErr != nil {
+			return nil, fmt.Errorf("failed to list unix sockets: %w", fallbackError(err, netstatErr))
 		}
 	}
 
-	return deduplicatePorts(ports), nil
+	return parseUnixSocketOutput(string(output)), nil
 }
 
-func parseNetstatOutput(output, portRange string) ([]int, error) {
-	var ports []int
-	lines := strings.Split(output, "\n")
+// parseUnixSocketOutput extracts socket paths from "ss -xl" or "netstat -xl"
+// output, where the path is the first field starting with "/".
+func parseUnixSocketOutput(output string) []string {
+	seen := make(map[string]bool)
+	var sockets []string
 
-	portRegex := regexp.MustCompile(`:(\d+)\s`)
-	minPort, maxPort := parsePortRange(portRange)
-
-	for _, line := range lines {
+	for _, line := range strings.Split(output, "\n") {
 		if !strings.Contains(line, "LISTEN") {
 			continue
 		}
 
-		matches := portRegex.FindStringSubmatch(line)
-		if len(matches) < 2 {
-			continue
+		for _, field := range strings.Fields(line) {
+			if !strings.HasPrefix(field, "/") {
+				continue
+			}
+			if !seen[field] {
+				seen[field] = true
+				sockets = append(sockets, field)
+			}
+			break
 		}
+	}
 
-		port, err := strconv.Atoi(matches[1])
-		if err != nil {
+	sort.Strings(sockets)
+	return sockets
+}
+
+// parseListeners reads the LISTEN lines of "ss -tlnp" or "netstat -tlnp"
+// output. The local address is the first field ending in ":<port>"; queue
+// sizes have no colon and the peer address ends in ":*".
+func parseListeners(output, portRange string) []Listener {
+	var listeners []Listener
+	// Listeners are compared without their process
+	seen := make(map[Listener]bool)
+	minPort, maxPort := parsePortRange(portRange)
+
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "LISTEN") {
 			continue
 		}
 
-		if port >= minPort && port <= maxPort {
-			ports = append(ports, port)
+		for _, field := range strings.Fields(line) {
+			l, ok := parseListenerAddress(field)
+			if !ok {
+				continue
+			}
+			if l.Port >= minPort && l.Port <= maxPort && !seen[l] {
+				seen[l] = true
+				l.Process = parseProcess(line)
+				listeners = append(listeners, l)
+			}
+			break
 		}
 	}
 
-	return deduplicatePorts(ports), nil
+	return listeners
 }
 
 func parsePortRange(portRange string) (minPort, maxPort int) {
@@ -44,7 +44,7 @@ LISTEN     0      128          *:5432                     *:*
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = parseSSOutput(output, portRange) //nolint:errcheck // Ignore error in benchmark
+		parseListeners(output, portRange)
 	}
 }
 
@@ -62,7 +62,7 @@ tcp        0      0 0.0.0.0:5432            0.0.0.0:*               LISTEN
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = parseNetstatOutput(output, portRange) //nolint:errcheck // Ignore error in benchmark
+		parseListeners(output, portRange)
 	}
 }
 
@@ -77,6 +77,6 @@ func BenchmarkParseSSOutputLarge(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = parseSSOutput(output, portRange) //nolint:errcheck // Ignore error in benchmark
+		parseListeners(output, portRange)
 	}
 }
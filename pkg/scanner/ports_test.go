@@ -1,7 +1,14 @@
 package scanner
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport/transporttest"
 )
 
 func TestParsePortRange(t *testing.T) {
@@ -124,24 +131,68 @@ LISTEN     0      128          *:8080                     *:*
 LISTEN     0      128          *:9000                     *:*
 LISTEN     0      128          *:22                       *:*`
 
-	ports, err := parseSSOutput(output, "3000-9000")
-	if err != nil {
-		t.Fatalf("parseSSOutput() error = %v", err)
-	}
+	listeners := parseListeners(output, "3000-9000")
 
 	expectedPorts := []int{3000, 8080, 9000}
-	if len(ports) != len(expectedPorts) {
-		t.Errorf("parseSSOutput() returned %d ports, want %d", len(ports), len(expectedPorts))
+	if len(listeners) != len(expectedPorts) {
+		t.Errorf("parseListeners() returned %d listeners, want %d", len(listeners), len(expectedPorts))
 	}
 
 	portMap := make(map[int]bool)
-	for _, p := range ports {
-		portMap[p] = true
+	for _, l := range listeners {
+		portMap[l.Port] = true
+		if l.Scope != ScopeWildcard {
+			t.Errorf("parseListeners() scope of %s = %s, want wildcard", l, l.Scope)
+		}
 	}
 
 	for _, expectedPort := range expectedPorts {
 		if !portMap[expectedPort] {
-			t.Errorf("parseSSOutput() missing port %d", expectedPort)
+			t.Errorf("parseListeners() missing port %d", expectedPort)
+		}
+	}
+}
+
+func TestParseSSOutputBindAddresses(t *testing.T) {
+	output := `State  Recv-Q Send-Q         Local Address:Port  Peer Address:Port Process
+LISTEN 0      4096           127.0.0.53%lo:53         0.0.0.0:*     users:(("systemd-resolve",pid=612,fd=14))
+LISTEN 0      128                  0.0.0.0:22         0.0.0.0:*     users:(("sshd",pid=812,fd=3))
+LISTEN 0      244                127.0.0.1:5432       0.0.0.0:*
+LISTEN 0      511                 10.0.3.7:8080       0.0.0.0:*
+LISTEN 0      128                     [::]:22            [::]:*     users:(("sshd",pid=812,fd=4))
+LISTEN 0      4096                   [::1]:9090          [::]:*
+LISTEN 0      511                        *:3000             *:*
+LISTEN 0      128      [fe80::1ff:fe23:4567:890a]%eth0:7000 [::]:*
+LISTEN 0      128                     [::]:22            [::]:*`
+
+	want := []Listener{
+		{Address: "127.0.0.53", Family: FamilyIPv4, Port: 53, Scope: ScopeLoopback, Interface: "lo"},
+		{Address: "0.0.0.0", Family: FamilyIPv4, Port: 22, Scope: ScopeWildcard},
+		{Address: "127.0.0.1", Family: FamilyIPv4, Port: 5432, Scope: ScopeLoopback},
+		{Address: "10.0.3.7", Family: FamilyIPv4, Port: 8080, Scope: ScopeInterface},
+		{Address: "::", Family: FamilyIPv6, Port: 22, Scope: ScopeWildcard},
+		{Address: "::1", Family: FamilyIPv6, Port: 9090, Scope: ScopeLoopback},
+		{Address: "*", Port: 3000, Scope: ScopeWildcard},
+		{Address: "fe80::1ff:fe23:4567:890a", Family: FamilyIPv6, Port: 7000, Scope: ScopeInterface, Interface: "eth0"},
+	}
+
+	got := parseListeners(output, "")
+	if len(got) != len(want) {
+		t.Fatalf("parseListeners() = %v, want %v", got, want)
+	}
+	wantProcesses := []string{"systemd-resolve/612", "sshd/812", "", "", "sshd/812", "", "", ""}
+	for i := range want {
+		process := ""
+		if p := got[i].Process; p != nil {
+			process = p.Name + "/" + strconv.Itoa(p.PID)
+		}
+		if process != wantProcesses[i] {
+			t.Errorf("parseListeners()[%d] process = %q, want %q", i, process, wantProcesses[i])
+		}
+
+		got[i].Process = nil
+		if got[i] != want[i] {
+			t.Errorf("parseListeners()[%d] = %+v, want %+v", i, got[i], want[i])
 		}
 	}
 }
@@ -151,26 +202,393 @@ func TestParseNetstatOutput(t *testing.T) {
 tcp        0      0 0.0.0.0:3000            0.0.0.0:*               LISTEN
 tcp        0      0 0.0.0.0:8080            0.0.0.0:*               LISTEN
 tcp        0      0 0.0.0.0:9000            0.0.0.0:*               LISTEN
-tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN`
+tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN
+tcp6       0      0 :::8080                 :::*                    LISTEN
+tcp6       0      0 ::1:6379                :::*                    LISTEN`
 
-	ports, err := parseNetstatOutput(output, "3000-9000")
+	listeners := parseListeners(output, "3000-9000")
+
+	expected := []string{"0.0.0.0:3000", "0.0.0.0:8080", "0.0.0.0:9000", "[::]:8080", "[::1]:6379"}
+	if len(listeners) != len(expected) {
+		t.Fatalf("parseListeners() = %v, want %v", listeners, expected)
+	}
+	for i, l := range listeners {
+		if l.String() != expected[i] {
+			t.Errorf("parseListeners()[%d] = %s, want %s", i, l, expected[i])
+		}
+	}
+}
+
+func TestProcessString(t *testing.T) {
+	tests := []struct {
+		name    string
+		process Process
+		want    string
+	}{
+		{"name only", Process{Name: "node", PID: 1234}, "node"},
+		{"script and user", Process{Name: "node", PID: 1234, User: "deploy", Cmdline: "/usr/bin/node /srv/billing/server.js"}, "node /srv/billing/server.js (deploy)"},
+		{"program without arguments", Process{Name: "redis-server", PID: 7, User: "redis", Cmdline: "/usr/bin/redis-server"}, "redis-server (redis)"},
+		{"secret flag after the script", Process{Name: "java", PID: 9, Cmdline: "/opt/java/bin/java -jar app.jar --db.password=hunter2"}, "java app.jar"},
+		{"secret flag value before the script", Process{Name: "node", PID: 10, Cmdline: "/usr/bin/node --token hunter2 server.js"}, "node"},
+		{"connection string", Process{Name: "worker", PID: 11, Cmdline: "/usr/local/bin/worker postgres://app:hunter2@db/app"}, "worker"},
+		{"flags before the script", Process{Name: "python3", PID: 12, User: "www", Cmdline: "/usr/bin/python3 -u /srv/app/main.py --port 8000"}, "python3 /srv/app/main.py (www)"},
+		{"unnamed", Process{PID: 42, User: "root"}, "PID 42 (root)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.process.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanListenersDescribesProcesses(t *testing.T) {
+	s := NewScannerWithTransport(transporttest.Fake{
+		"netstat -tlnp": "tcp 0 0 0.0.0.0:4000 0.0.0.0:* LISTEN 1234/node\n" +
+			"tcp6 0 0 :::4000 :::* LISTEN 1234/node\n" +
+			"tcp 0 0 127.0.0.1:5432 0.0.0.0:* LISTEN 456/postgres\n" +
+			"tcp 0 0 0.0.0.0:22 0.0.0.0:* LISTEN -\n",
+		processCommand([]int{456, 1234}): "456 postgres /usr/lib/postgresql/16/bin/postgres -D /var/lib/postgresql/16/main \n" +
+			"1234 deploy /usr/bin/node /srv/billing/server.js \n",
+	})
+
+	result, err := s.ScanListeners("")
 	if err != nil {
-		t.Fatalf("parseNetstatOutput() error = %v", err)
+		t.Fatalf("ScanListeners() error = %v", err)
 	}
+	if result.Strategy != StrategyNetstat {
+		t.Errorf("ScanListeners() strategy = %s, want netstat", result.Strategy)
+	}
+	listeners := result.Listeners
 
-	expectedPorts := []int{3000, 8080, 9000}
-	if len(ports) != len(expectedPorts) {
-		t.Errorf("parseNetstatOutput() returned %d ports, want %d", len(ports), len(expectedPorts))
+	want := []string{
+		"node /srv/billing/server.js (deploy)",
+		"node /srv/billing/server.js (deploy)",
+		"postgres /var/lib/postgresql/16/main (postgres)",
+		"",
+	}
+	if len(listeners) != len(want) {
+		t.Fatalf("ScanListeners() = %v, want %d listeners", listeners, len(want))
+	}
+	for i, l := range listeners {
+		got := ""
+		if l.Process != nil {
+			got = l.Process.String()
+		}
+		if got != want[i] {
+			t.Errorf("ScanListeners()[%d] process = %q, want %q", i, got, want[i])
+		}
 	}
+}
 
-	portMap := make(map[int]bool)
-	for _, p := range ports {
-		portMap[p] = true
+func TestPreferred(t *testing.T) {
+	tests := []struct {
+		name       string
+		listeners  []string
+		want       string
+		wantTarget string
+	}{
+		{"wildcard over loopback", []string{"127.0.0.1:80", "0.0.0.0:80"}, "0.0.0.0:80", ""},
+		{"both wildcards", []string{"[::]:22", "0.0.0.0:22"}, "[::]:22", ""},
+		{"IPv6 loopback only", []string{"[::1]:9090"}, "[::1]:9090", ""},
+		{"private address only", []string{"10.0.3.7:8080"}, "10.0.3.7:8080", "10.0.3.7"},
+		{"default loopback over another", []string{"127.0.0.53%lo:53", "127.0.0.1:53"}, "127.0.0.1:53", ""},
+		{"other loopback address", []string{"127.0.0.53%lo:53"}, "127.0.0.53:53", "127.0.0.53"},
+		{"link-local keeps its zone", []string{"[fe80::1]%eth0:7000"}, "[fe80::1]:7000", "fe80::1%eth0"},
 	}
 
-	for _, expectedPort := range expectedPorts {
-		if !portMap[expectedPort] {
-			t.Errorf("parseNetstatOutput() missing port %d", expectedPort)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listeners []Listener
+			for _, field := range tt.listeners {
+				l, ok := parseListenerAddress(field)
+				if !ok {
+					t.Fatalf("parseListenerAddress(%q) failed", field)
+				}
+				listeners = append(listeners, l)
+			}
+
+			got := Preferred(listeners)
+			if len(got) != 1 || got[0].String() != tt.want {
+				t.Fatalf("Preferred() = %v, want [%s]", got, tt.want)
+			}
+			if target := got[0].TargetHost(); target != tt.wantTarget {
+				t.Errorf("TargetHost() = %q, want %q", target, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestParseUnixSocketOutput(t *testing.T) {
+	ssOutput := `Netid State  Recv-Q Send-Q                      Local Address:Port   Peer Address:Port Process
+u_str LISTEN 0      4096                     /run/docker.sock 20970            * 0
+u_str LISTEN 0      244     /var/run/postgresql/.s.PGSQL.5432 31337            * 0
+u_seq LISTEN 0      4096                    /run/udev/control 13389            * 0
+u_str LISTEN 0      4096                   @/tmp/.X11-unix/X0 40001            * 0
+u_str LISTEN 0      4096                     /run/docker.sock 20971            * 0`
+
+	want := []string{"/run/docker.sock", "/run/udev/control", "/var/run/postgresql/.s.PGSQL.5432"}
+	got := parseUnixSocketOutput(ssOutput)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("parseUnixSocketOutput(ss) = %v, want %v", got, want)
+	}
+
+	netstatOutput := `Active UNIX domain sockets (only servers)
+Proto RefCnt Flags       Type       State         I-Node   Path
+unix  2      [ ACC ]     STREAM     LISTENING     20970    /run/docker.sock
+unix  2      [ ACC ]     STREAM     LISTENING     33001    /run/php/php8.2-fpm.sock`
+
+	want = []string{"/run/docker.sock", "/run/php/php8.2-fpm.sock"}
+	got = parseUnixSocketOutput(netstatOutput)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("parseUnixSocketOutput(netstat) = %v, want %v", got, want)
+	}
+}
+
+func TestCountNamedListeners(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		wantNamed int
+		wantTotal int
+	}{
+		{
+			name: "ss as root",
+			output: `State  Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+LISTEN 0      128          0.0.0.0:22        0.0.0.0:*     users:(("sshd",pid=812,fd=3))
+LISTEN 0      511        127.0.0.1:3000      0.0.0.0:*     users:(("node",pid=1204,fd=18))`,
+			wantNamed: 2,
+			wantTotal: 2,
+		},
+		{
+			name: "ss as an unprivileged user",
+			output: `State  Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+LISTEN 0      128          0.0.0.0:22        0.0.0.0:*
+LISTEN 0      511        127.0.0.1:3000      0.0.0.0:*     users:(("node",pid=1204,fd=18))
+LISTEN 0      4096            [::]:9090         [::]:*`,
+			wantNamed: 1,
+			wantTotal: 3,
+		},
+		{
+			name: "netstat with hidden processes",
+			output: `Proto Recv-Q Send-Q Local Address  Foreign Address State  PID/Program name
+tcp        0      0 0.0.0.0:22     0.0.0.0:*       LISTEN -
+tcp        0      0 127.0.0.1:3000 0.0.0.0:*       LISTEN 1204/node`,
+			wantNamed: 1,
+			wantTotal: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			named, total := countNamedListeners(parseListeners(tt.output, ""))
+			if named != tt.wantNamed || total != tt.wantTotal {
+				t.Errorf("countNamedListeners() = %d, %d; want %d, %d", named, total, tt.wantNamed, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestScanPortsWithTransport(t *testing.T) {
+	s := NewScannerWithTransport(transporttest.Fake{
+		"netstat -tlnp": "tcp 0 0 0.0.0.0:3000 0.0.0.0:* LISTEN 123/node\n" +
+			"tcp 0 0 127.0.0.1:5432 0.0.0.0:* LISTEN 456/postgres\n",
+	})
+
+	// ss is missing, so the scanner must fall back to netstat
+	ports, err := s.ScanPorts("")
+	if err != nil {
+		t.Fatalf("ScanPorts() error = %v", err)
+	}
+	if len(ports) != 2 || ports[0] != 3000 || ports[1] != 5432 {
+		t.Errorf("ScanPorts() = %v, want [3000 5432]", ports)
+	}
+}
+
+// errorTransport fails commands with the error listed for them.
+type errorTransport struct {
+	errs  map[string]error
+	calls []string
+}
+
+func (f *errorTransport) Run(_ context.Context, command string) ([]byte, error) {
+	f.calls = append(f.calls, command)
+	return nil, f.errs[command]
+}
+
+func (f *errorTransport) Forward(context.Context, transport.Forward) (transport.Handle, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestScanPortsErrors(t *testing.T) {
+	authErr := transport.NewError("ss -tlnp", errors.New("exit status 255"), 255, "admin@example.com: Permission denied (publickey).")
+	ssDenied := transport.NewError("ss -tlnp", errors.New("exit status 1"), 1, "Cannot open netlink socket: Permission denied")
+	netstatMissing := transport.NewError("netstat -tlnp", errors.New("exit status 127"), 127, "bash: netstat: command not found")
+	catMissing := transport.NewError(procNetCommand, errors.New("exit status 127"), 127, "sh: cat: not found")
+
+	tests := []struct {
+		name      string
+		errs      map[string]error
+		wantKind  transport.ErrorKind
+		wantCalls int
+	}{
+		{
+			name:      "connection failure skips the fallback",
+			errs:      map[string]error{"ss -tlnp": authErr, "netstat -tlnp": authErr},
+			wantKind:  transport.KindAuth,
+			wantCalls: 1,
+		},
+		{
+			name:      "missing fallback reports the first failure",
+			errs:      map[string]error{"ss -tlnp": ssDenied, "netstat -tlnp": netstatMissing, procNetCommand: catMissing},
+			wantKind:  transport.KindPermission,
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &errorTransport{errs: tt.errs}
+			_, err := NewScannerWithTransport(fake).ScanPorts("")
+			if got := transport.KindOf(err); got != tt.wantKind {
+				t.Errorf("ScanPorts() error kind = %s, want %s (%v)", got, tt.wantKind, err)
+			}
+			if len(fake.calls) != tt.wantCalls {
+				t.Errorf("ran %v, want %d command(s)", fake.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// procNetTCP is /proc/net/tcp and tcp6 with listeners on 127.0.0.1:3306,
+// 0.0.0.0:2024, 10.0.3.7:8080 and [::1]:4321, [::]:22, an established
+// connection and a socket in another state.
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   112        0 21457 1 0000000000000000 100 0 0 10 0
+   1: 00000000:07E8 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 662 1 0000000076902f72 100 0 0 10 0
+   2: 0703000A:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 30001 1 0000000000000000 100 0 0 10 0
+   3: 0100007F:E1FC 0100007F:0CEA 01 00000000:00000000 02:00000646 00000000     0        0 59975 2 000000001fb09849 20 4 0 18 -1
+   4: 0100007F:1F91 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 59976 2 0000000000000000 20 4 4 20 -1
+  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:10E1 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 64992 1 00000000e943034e 100 0 0 10 0
+   1: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 663 1 0000000000000000 100 0 0 10 0
+`
+
+func TestParseProcNetTCP(t *testing.T) {
+	listeners, inodes, err := parseProcNetTCP(procNetTCP, "")
+	if err != nil {
+		t.Fatalf("parseProcNetTCP() error = %v", err)
+	}
+
+	want := []Listener{
+		{Address: "127.0.0.1", Family: FamilyIPv4, Port: 3306, Scope: ScopeLoopback},
+		{Address: "0.0.0.0", Family: FamilyIPv4, Port: 2024, Scope: ScopeWildcard},
+		{Address: "10.0.3.7", Family: FamilyIPv4, Port: 8080, Scope: ScopeInterface},
+		{Address: "::1", Family: FamilyIPv6, Port: 4321, Scope: ScopeLoopback},
+		{Address: "::", Family: FamilyIPv6, Port: 22, Scope: ScopeWildcard},
+	}
+	wantInodes := []string{"21457", "662", "30001", "64992", "663"}
+	if len(listeners) != len(want) {
+		t.Fatalf("parseProcNetTCP() = %v, want %v", listeners, want)
+	}
+	for i := range want {
+		if listeners[i] != want[i] || inodes[i] != wantInodes[i] {
+			t.Errorf("parseProcNetTCP()[%d] = %+v inode %s, want %+v inode %s", i, listeners[i], inodes[i], want[i], wantInodes[i])
+		}
+	}
+
+	if _, _, err := parseProcNetTCP("cat: can't open '/proc/net/tcp'", ""); err == nil {
+		t.Error("parseProcNetTCP() accepted output without the table header")
+	}
+}
+
+func TestParseSocketInodes(t *testing.T) {
+	output := `/proc/1/fd:
+total 0
+lrwx------ 1 root root 64 Oct 16 10:00 0 -> /dev/null
+lrwx------ 1 root root 64 Oct 16 10:00 3 -> socket:[662]
+
+/proc/1204/fd:
+total 0
+lrwx------ 1 deploy deploy 64 Oct 16 10:00 18 -> socket:[30001]
+lr-x------ 1 deploy deploy 64 Oct 16 10:00 19 -> pipe:[30002]
+lrwx------ 1 deploy deploy 64 Oct 16 10:00 20 -> socket:[662]`
+
+	pids := parseSocketInodes(output)
+	if len(pids) != 2 || pids["662"] != 1 || pids["30001"] != 1204 {
+		t.Errorf("parseSocketInodes() = %v, want 662 -> 1 and 30001 -> 1204", pids)
+	}
+}
+
+func TestScanListenersStrategies(t *testing.T) {
+	remote := transporttest.Fake{
+		procNetCommand:              procNetTCP,
+		procFDCommand:               "/proc/1204/fd:\nlrwx------ 1 deploy deploy 64 Oct 16 10:00 18 -> socket:[30001]\n",
+		processCommand([]int{1204}): "1204 deploy /usr/bin/node /srv/billing/server.js \n",
+		"netstat -tlnp":             "tcp 0 0 0.0.0.0:3000 0.0.0.0:* LISTEN -\n",
+	}
+
+	tests := []struct {
+		name         string
+		strategies   []Strategy
+		wantStrategy Strategy
+		wantCount    int
+	}{
+		{"falls back to /proc without ss", []Strategy{StrategySS, StrategyProc}, StrategyProc, 5},
+		{"configured order", []Strategy{StrategyNetstat, StrategyProc}, StrategyNetstat, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScannerWithTransport(remote)
+			s.SetStrategies(tt.strategies)
+			result, err := s.ScanListeners("")
+			if err != nil {
+				t.Fatalf("ScanListeners() error = %v", err)
+			}
+			if result.Strategy != tt.wantStrategy || len(result.Listeners) != tt.wantCount {
+				t.Errorf("ScanListeners() = %d listener(s) with %s, want %d with %s", len(result.Listeners), result.Strategy, tt.wantCount, tt.wantStrategy)
+			}
+		})
+	}
+
+	s := NewScannerWithTransport(remote)
+	s.SetStrategies([]Strategy{StrategyProc})
+	result, err := s.ScanListeners("8080")
+	if err != nil {
+		t.Fatalf("ScanListeners() error = %v", err)
+	}
+	if len(result.Listeners) != 1 || result.Listeners[0].Process == nil || result.Listeners[0].Process.String() != "node /srv/billing/server.js (deploy)" {
+		t.Errorf("ScanListeners() = %+v, want 10.0.3.7:8080 owned by node", result.Listeners)
+	}
+}
+
+func TestParseStrategies(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    string
+		wantErr bool
+	}{
+		{nil, "ss,netstat,proc", false},
+		{[]string{"proc", "ss"}, "proc,ss", false},
+		{[]string{"netstat", "netstat"}, "netstat", false},
+		{[]string{"lsof"}, "", true},
+	}
+
+	for _, tt := range tests {
+		strategies, err := ParseStrategies(tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStrategies(%v) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			continue
+		}
+		got := make([]string, len(strategies))
+		for i, strategy := range strategies {
+			got[i] = string(strategy)
+		}
+		if err == nil && strings.Join(got, ",") != tt.want {
+			t.Errorf("ParseStrategies(%v) = %v, want %s", tt.names, got, tt.want)
 		}
 	}
 }
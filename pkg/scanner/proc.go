@@ -0,0 +1,173 @@
+package scanner
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Strategy is a way of listing the listening sockets on the server.
+type Strategy string
+
+const (
+	// StrategySS runs "ss -tlnp"
+	StrategySS Strategy = "ss"
+	// StrategyNetstat runs "netstat -tlnp"
+	StrategyNetstat Strategy = "netstat"
+	// StrategyProc reads /proc/net/tcp and /proc/net/tcp6, for minimal
+	// hosts such as busybox or distroless images that have neither
+	StrategyProc Strategy = "proc"
+)
+
+// DefaultStrategies is the order strategies are tried in when none is set.
+var DefaultStrategies = []Strategy{StrategySS, StrategyNetstat, StrategyProc}
+
+// ParseStrategies parses a fallback order such as "ss,netstat,proc". An
+// empty list returns DefaultStrategies.
+func ParseStrategies(names []string) ([]Strategy, error) {
+	if len(names) == 0 {
+		return DefaultStrategies, nil
+	}
+
+	seen := make(map[Strategy]bool)
+	strategies := make([]Strategy, 0, len(names))
+	for _, name := range names {
+		strategy := Strategy(strings.TrimSpace(name))
+		switch strategy {
+		case StrategySS, StrategyNetstat, StrategyProc:
+		default:
+			return nil, fmt.Errorf("unknown scan strategy %q (use %s, %s or %s)", name, StrategySS, StrategyNetstat, StrategyProc)
+		}
+		if !seen[strategy] {
+			seen[strategy] = true
+			strategies = append(strategies, strategy)
+		}
+	}
+	return strategies, nil
+}
+
+const (
+	// procNetCommand prints the IPv4 and, where enabled, IPv6 socket tables
+	procNetCommand = "cat /proc/net/tcp && { cat /proc/net/tcp6 2>/dev/null || true; }"
+	// procFDCommand lists every readable file descriptor, whose links name
+	// the socket inodes; fds of other users are unreadable without root
+	procFDCommand = "ls -l /proc/[0-9]*/fd 2>/dev/null; true"
+	// procListenState is TCP_LISTEN in the st column
+	procListenState = "0A"
+)
+
+// scanWithProc reads the kernel's socket tables and maps the sockets'
+// inodes to the processes holding them where their fds are readable.
+func (s *Scanner) scanWithProc(portRange string) ([]Listener, error) {
+	output, err := s.run(procNetCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners, inodes, err := parseProcNetTCP(string(output), portRange)
+	if err != nil || len(listeners) == 0 {
+		return listeners, err
+	}
+
+	fds, err := s.run(procFDCommand)
+	if err != nil {
+		// Listeners without processes are still worth returning
+		return listeners, nil
+	}
+	pids := parseSocketInodes(string(fds))
+	for i, inode := range inodes {
+		if pid, ok := pids[inode]; ok {
+			listeners[i].Process = &Process{PID: pid}
+		}
+	}
+	return listeners, nil
+}
+
+// parseProcNetTCP reads the LISTEN sockets of /proc/net/tcp and tcp6. It
+// returns the listeners and the socket inode of each.
+func parseProcNetTCP(output, portRange string) ([]Listener, []string, error) {
+	var listeners []Listener
+	var inodes []string
+	seen := make(map[Listener]bool)
+	minPort, maxPort := parsePortRange(portRange)
+	header := false
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "sl" {
+			header = true
+			continue
+		}
+		// sl local_address rem_address st ... uid timeout inode
+		if len(fields) < 10 || fields[3] != procListenState {
+			continue
+		}
+
+		l, ok := decodeProcAddress(fields[1])
+		if !ok || l.Port < minPort || l.Port > maxPort || seen[l] {
+			continue
+		}
+		seen[l] = true
+		listeners = append(listeners, l)
+		inodes = append(inodes, fields[9])
+	}
+
+	if !header {
+		return nil, nil, fmt.Errorf("unexpected /proc/net/tcp output")
+	}
+	return listeners, inodes, nil
+}
+
+// decodeProcAddress decodes "0100007F:1F90" or its 32 digit IPv6 form. The
+// kernel prints the address as 32-bit words in host byte order, which is
+// little-endian on the x86 and ARM servers this runs against, and the port
+// in network byte order.
+func decodeProcAddress(field string) (Listener, bool) {
+	hexIP, hexPort, ok := strings.Cut(field, ":")
+	if !ok {
+		return Listener{}, false
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil || port == 0 {
+		return Listener{}, false
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return Listener{}, false
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return newListener(ip, len(raw) == net.IPv6len, int(port), ""), true
+}
+
+// parseSocketInodes maps socket inodes to the PID holding them from the
+// output of procFDCommand, where each process's fds follow a "/proc/<pid>/fd:"
+// line and sockets link to "socket:[<inode>]".
+func parseSocketInodes(output string) map[string]int {
+	pids := make(map[string]int)
+	pid := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "/proc/") && strings.HasSuffix(line, "/fd:") {
+			pid, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "/proc/"), "/fd:")) //nolint:errcheck // Zero skips the process
+			continue
+		}
+		_, link, ok := strings.Cut(line, "socket:[")
+		if !ok || pid == 0 {
+			continue
+		}
+		inode := strings.TrimSuffix(link, "]")
+		if _, exists := pids[inode]; !exists {
+			pids[inode] = pid
+		}
+	}
+	return pids
+}
@@ -0,0 +1,155 @@
+package scanner
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Process is the program that owns a listening socket.
+type Process struct {
+	// Name is the short name ss or netstat reports, such as "node"
+	Name string `json:"name"`
+	PID  int    `json:"pid"`
+	// User owns the process, empty when it could not be read
+	User string `json:"user,omitempty"`
+	// Cmdline is the full command line from /proc/<pid>/cmdline with the
+	// arguments separated by spaces, empty when it could not be read. It
+	// can carry secrets, such as passwords in connection strings, so only
+	// String's program name is shown by default.
+	Cmdline string `json:"cmdline,omitempty"`
+}
+
+// String describes the process as "node /srv/billing/server.js (deploy)":
+// the base name of the program, the script it runs and the owning user.
+// Other arguments can carry secrets and are left out.
+func (p *Process) String() string {
+	label := p.Name
+	if args := strings.Fields(p.Cmdline); len(args) > 0 {
+		label = path.Base(args[0])
+		if script := scriptArg(args[1:]); script != "" {
+			label += " " + script
+		}
+	}
+	if label == "" {
+		label = "PID " + strconv.Itoa(p.PID)
+	}
+	if p.User != "" {
+		label += " (" + p.User + ")"
+	}
+	return label
+}
+
+// scriptArg returns the first argument that is not a flag when it names a
+// file, such as "/srv/billing/server.js" or "app.jar", and "" otherwise.
+// Values such as "hunter2" or "postgres://user:pass@db" are never returned.
+func scriptArg(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if strings.ContainsAny(arg, "=:@") || !strings.ContainsAny(arg, "/.") {
+			return ""
+		}
+		return arg
+	}
+	return ""
+}
+
+var (
+	// ssProcessRegex matches the first process in ss's
+	// users:(("node",pid=1234,fd=20))
+	ssProcessRegex = regexp.MustCompile(`users:\(\("([^"]*)",pid=(\d+)`)
+	// netstatProcessRegex matches netstat's PID/Program name column
+	netstatProcessRegex = regexp.MustCompile(`^(\d+)/(.+)$`)
+)
+
+// parseProcess finds the process in a LISTEN line of ss or netstat, which
+// only name it for root or the process's own user.
+func parseProcess(line string) *Process {
+	if m := ssProcessRegex.FindStringSubmatch(line); m != nil {
+		pid, _ := strconv.Atoi(m[2]) //nolint:errcheck // Digits only
+		return &Process{Name: m[1], PID: pid}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	if m := netstatProcessRegex.FindStringSubmatch(fields[len(fields)-1]); m != nil {
+		pid, _ := strconv.Atoi(m[1]) //nolint:errcheck // Digits only
+		return &Process{Name: m[2], PID: pid}
+	}
+	return nil
+}
+
+// describeProcesses fills in the owning user and command line of the
+// listeners' processes from /proc. It is best effort: processes that exited
+// or whose /proc entry is hidden keep what ss or netstat reported.
+func (s *Scanner) describeProcesses(listeners []Listener) {
+	byPID := make(map[int][]*Process)
+	for _, l := range listeners {
+		if l.Process != nil {
+			byPID[l.Process.PID] = append(byPID[l.Process.PID], l.Process)
+		}
+	}
+	if len(byPID) == 0 {
+		return
+	}
+
+	pids := make([]int, 0, len(byPID))
+	for pid := range byPID {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	output, err := s.run(processCommand(pids))
+	if err != nil && len(output) == 0 {
+		return
+	}
+
+	for pid, info := range parseProcessOutput(string(output)) {
+		for _, p := range byPID[pid] {
+			p.User = info.User
+			p.Cmdline = info.Cmdline
+			if p.Name == "" {
+				// Found through /proc, which only gives the PID
+				program, _, _ := strings.Cut(info.Cmdline, " ")
+				p.Name = path.Base(program)
+			}
+		}
+	}
+}
+
+// processCommand prints "<pid> <user> <cmdline>" for each of pids, with the
+// NUL-separated arguments of /proc/<pid>/cmdline joined by spaces and any
+// newlines in them replaced so each process stays on one line.
+func processCommand(pids []int) string {
+	list := make([]string, len(pids))
+	for i, pid := range pids {
+		list[i] = strconv.Itoa(pid)
+	}
+	return fmt.Sprintf(`for pid in %s; do [ -r /proc/$pid/cmdline ] || continue; `+
+		`printf '%%s %%s ' "$pid" "$(stat -c %%U /proc/$pid 2>/dev/null)"; `+
+		`tr '\000\n' '  ' < /proc/$pid/cmdline; echo; done`, strings.Join(list, " "))
+}
+
+// parseProcessOutput reads the output of processCommand.
+func parseProcessOutput(output string) map[int]Process {
+	processes := make(map[int]Process)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		processes[pid] = Process{PID: pid, User: fields[1], Cmdline: strings.TrimSpace(fields[2])}
+	}
+	return processes
+}
@@ -3,33 +3,68 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/askpass"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/detector"
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/scanner"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/tunnel"
 )
 
 type Server struct {
-	port     int
+	// host is the address the dashboard listens on, loopback by default
+	host string
+	port int
+	// token must accompany requests that change state, see guarded
+	token string
+	// mu guards services and html, which change while the server runs
+	mu       sync.RWMutex
 	services []detector.Service
 	html     string
 	scanner  *scanner.Scanner
+	tunnels  *tunnel.Manager
+	// socksPort is the local SOCKS proxy port advertised in /proxy.pac, 0 if disabled
+	socksPort int
 	// shutdownFunc is called to gracefully shut down the application
 	shutdownFunc func()
+	// prompts are ssh prompts answered in the dashboard, nil to ask elsewhere
+	prompts *askpass.Queue
 }
 
 func NewServer(port int, services []detector.Service) *Server {
 	return &Server{
+		host:     "127.0.0.1",
 		port:     port,
+		token:    newToken(),
 		services: services,
 	}
 }
 
+// SetBindAddress sets the address the dashboard listens on. Other machines
+// can only reach it when this is not a loopback address.
+func (s *Server) SetBindAddress(host string) {
+	s.host = host
+}
+
 func (s *Server) SetScanner(sc *scanner.Scanner) {
 	s.scanner = sc
 }
 
+func (s *Server) SetTunnelManager(m *tunnel.Manager) {
+	s.tunnels = m
+}
+
+func (s *Server) SetSOCKSPort(port int) {
+	s.socksPort = port
+}
+
 func (s *Server) SetHTML(html string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.html = html
 }
 
@@ -38,34 +73,64 @@ func (s *Server) SetShutdownFunc(fn func()) {
 }
 
 func (s *Server) Start() error {
-	http.HandleFunc("/", s.handleDashboard)
-	http.HandleFunc("/api/services", s.handleServicesAPI)
-	http.HandleFunc("/api/scan", s.handleScan)
-	http.HandleFunc("/api/shutdown", s.handleShutdown)
-	http.HandleFunc("/health", s.handleHealth)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleDashboard)
+	mux.HandleFunc("/api/services", s.handleServicesAPI)
+	mux.HandleFunc("/api/scan", s.guarded(s.handleScan))
+	mux.HandleFunc("/api/sockets", s.handleSockets)
+	mux.HandleFunc("/api/tunnels", s.handleTunnelsAPI)
+	mux.HandleFunc("/api/tunnels/reverse", s.guarded(s.handleReverseTunnel))
+	mux.HandleFunc("/api/tunnels/extend", s.guarded(s.handleExtendTunnel))
+	mux.HandleFunc("/api/shutdown", s.guarded(s.handleShutdown))
+	mux.HandleFunc("/api/prompts", s.guarded(s.handlePrompts))
+	mux.HandleFunc("/api/prompts/answer", s.guarded(s.handleAnswerPrompt))
+	mux.HandleFunc("/proxy.pac", s.handlePAC)
+	mux.HandleFunc("/health", s.handleHealth)
 
-	addr := fmt.Sprintf(":%d", s.port)
-	fmt.Printf("Dashboard server starting on http://localhost%s\n", addr)
-	return http.ListenAndServe(addr, nil)
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	fmt.Printf("Dashboard server starting on http://%s\n", addr)
+	return http.ListenAndServe(addr, checkHost(mux))
 }
 
 // UpdateServices updates the list of services dynamically.
 func (s *Server) UpdateServices(services []detector.Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.services = services
 }
 
+// currentServices returns the services shown on the dashboard.
+func (s *Server) currentServices() []detector.Service {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.services
+}
+
+// loadingPage is served until the dashboard is generated. It reloads once
+// /health reports the dashboard ready.
+const loadingPage = `<html><body><h1>Zero-Trust Tunnel Dashboard</h1><p>Dashboard is loading...</p>
+<script>
+setInterval(function() {
+    fetch('/health').then(r => r.json()).then(function(h) { if (h.ready) location.reload(); }).catch(function() {});
+}, 2000);
+</script>
+</body></html>`
+
 func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if s.html != "" {
-		_, _ = w.Write([]byte(s.html)) //nolint:errcheck // Ignore write error
-	} else {
-		_, _ = fmt.Fprintf(w, "<html><body><h1>Zero-Trust Tunnel Dashboard</h1><p>Dashboard is loading...</p></body></html>") //nolint:errcheck // Ignore write error
+	s.mu.RLock()
+	page := s.html
+	s.mu.RUnlock()
+	if page == "" {
+		page = loadingPage
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(s.withPromptModal(s.withToken(page)))) //nolint:errcheck // Ignore write error
 }
 
 func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
@@ -103,7 +168,161 @@ func (s *Server) handleServicesAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(s.services) //nolint:errcheck // Ignore encode error
+	_ = json.NewEncoder(w).Encode(s.servicesWithTraffic()) //nolint:errcheck // Ignore encode error
+}
+
+// serviceResponse is a service as returned by /api/services.
+type serviceResponse struct {
+	detector.Service
+	Traffic *tunnel.Traffic `json:",omitempty"`
+}
+
+// servicesWithTraffic attaches tunnel traffic counters to services that have
+// their own tunnel.
+func (s *Server) servicesWithTraffic() []serviceResponse {
+	current := s.currentServices()
+	services := make([]serviceResponse, 0, len(current))
+	for _, svc := range current {
+		resp := serviceResponse{Service: svc}
+		if s.tunnels != nil && svc.Port > 0 {
+			if traffic, ok := s.tunnels.Traffic(svc.TargetHost, svc.Port); ok {
+				resp.Traffic = &traffic
+			}
+		}
+		services = append(services, resp)
+	}
+	return services
+}
+
+func (s *Server) handleTunnelsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.tunnels == nil {
+		http.Error(w, "Tunnel manager not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.tunnels.Status()) //nolint:errcheck // Ignore encode error
+}
+
+// handleReverseTunnel creates (POST ?spec=[bind:]remote:local) or closes
+// (DELETE ?remotePort=N) a reverse tunnel.
+func (s *Server) handleReverseTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.tunnels == nil {
+		http.Error(w, "Tunnel manager not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodDelete {
+		var remotePort int
+		remotePort, err = strconv.Atoi(r.URL.Query().Get("remotePort"))
+		if err != nil {
+			http.Error(w, "Invalid remotePort", http.StatusBadRequest)
+			return
+		}
+		err = s.tunnels.CloseReverseTunnel(remotePort)
+	} else {
+		var forward tunnel.ReverseForward
+		forward, err = tunnel.ParseReverseForward(r.URL.Query().Get("spec"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.tunnels.CreateReverseTunnel(forward)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck // Ignore encode error
+			"error": err.Error(),
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(s.tunnels.Status()) //nolint:errcheck // Ignore encode error
+}
+
+// handleExtendTunnel extends (POST ?port=N&host=H&by=30m) the TTL of a tunnel,
+// reopening it if it already expired. Other kinds than local tunnels are
+// chosen with kind=reverse&port=N, kind=socket&socket=/path or kind=dynamic.
+func (s *Server) handleExtendTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.tunnels == nil {
+		http.Error(w, "Tunnel manager not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	kind := tunnel.Kind(query.Get("kind"))
+	port, err := strconv.Atoi(query.Get("port"))
+	if err != nil && (kind == "" || kind == tunnel.KindLocal || kind == tunnel.KindReverse) {
+		http.Error(w, "Invalid port", http.StatusBadRequest)
+		return
+	}
+	by := 30 * time.Minute
+	if value := query.Get("by"); value != "" {
+		by, err = time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var expiresAt time.Time
+	switch kind {
+	case "", tunnel.KindLocal:
+		expiresAt, err = s.tunnels.ExtendTunnel(query.Get("host"), port, by)
+	case tunnel.KindReverse:
+		expiresAt, err = s.tunnels.ExtendReverseTunnel(port, by)
+	case tunnel.KindSocket:
+		expiresAt, err = s.tunnels.ExtendSocketTunnel(query.Get("socket"), by)
+	case tunnel.KindDynamic:
+		expiresAt, err = s.tunnels.ExtendSOCKS(by)
+	default:
+		http.Error(w, "Invalid kind", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck // Ignore encode error
+			"error": err.Error(),
+		})
+		return
+	}
+
+	response := map[string]interface{}{"port": port}
+	if !expiresAt.IsZero() {
+		response["expiresAt"] = expiresAt
+	}
+	_ = json.NewEncoder(w).Encode(response) //nolint:errcheck // Ignore encode error
+}
+
+func (s *Server) handlePAC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.socksPort == 0 {
+		http.Error(w, "SOCKS proxy not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	_, _ = w.Write([]byte(generatePAC(pacHosts(s.currentServices()), s.socksPort))) //nolint:errcheck // Ignore write error
 }
 
 func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
@@ -122,7 +341,7 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 		portRange = "3000-9000"
 	}
 
-	ports, err := s.scanner.ScanPorts(portRange)
+	result, err := s.scanner.ScanListeners(portRange)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck // Ignore encode error
@@ -131,17 +350,65 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Command lines can carry secrets, so they are only returned when asked
+	// for from this machine
+	if r.URL.Query().Get("cmdline") != "1" || !fromLoopback(r) {
+		for _, l := range result.Listeners {
+			if l.Process != nil {
+				l.Process.Cmdline = ""
+			}
+		}
+	}
+
+	ports := make([]int, 0, len(result.Listeners))
+	for _, l := range scanner.Preferred(result.Listeners) {
+		ports = append(ports, l.Port)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"ports": ports,
-		"count": len(ports),
+		"ports":     ports,
+		"count":     len(ports),
+		"listeners": result.Listeners,
+		"strategy":  result.Strategy,
 	}) // Ignore encode error
 }
 
+func (s *Server) handleSockets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.scanner == nil {
+		http.Error(w, "Scanner not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	sockets, err := s.scanner.ScanUnixSockets()
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck // Ignore encode error
+			"error": err.Error(),
+		})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck // Ignore encode error
+		"sockets": sockets,
+		"count":   len(sockets),
+	})
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	s.mu.RLock()
+	ready := s.html != ""
+	s.mu.RUnlock()
+
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":   "healthy",
-		"services": len(s.services),
+		"services": len(s.currentServices()),
+		"ready":    ready,
 	}) // Ignore encode error
 }
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/detector"
+)
+
+// pacHosts collects the hosts and domains of discovered services that should
+// be reached through the SOCKS proxy.
+func pacHosts(services []detector.Service) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+
+	add := func(host string) {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" || host == "localhost" || seen[host] {
+			return
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	for _, svc := range services {
+		add(svc.TargetHost)
+		for _, domain := range strings.Split(svc.Domain, ",") {
+			add(domain)
+		}
+	}
+
+	sort.Strings(hosts)
+	return hosts
+}
+
+// generatePAC builds a proxy auto-config script that sends only the given
+// hosts (and their subdomains) through the SOCKS proxy on socksPort.
+func generatePAC(hosts []string, socksPort int) string {
+	list, err := json.Marshal(hosts)
+	if err != nil || hosts == nil {
+		list = []byte("[]")
+	}
+
+	proxy := fmt.Sprintf("SOCKS5 127.0.0.1:%d; SOCKS 127.0.0.1:%d", socksPort, socksPort)
+
+	var sb strings.Builder
+	sb.WriteString("// Generated by Zero-Trust Tunnel Dashboard\n")
+	sb.WriteString("function FindProxyForURL(url, host) {\n")
+	sb.WriteString(fmt.Sprintf("    var hosts = %s;\n", list))
+	sb.WriteString("    host = host.toLowerCase();\n")
+	sb.WriteString("    for (var i = 0; i < hosts.length; i++) {\n")
+	sb.WriteString("        if (host == hosts[i] || dnsDomainIs(host, \".\" + hosts[i])) {\n")
+	sb.WriteString(fmt.Sprintf("            return %q;\n", proxy))
+	sb.WriteString("        }\n")
+	sb.WriteString("    }\n")
+	sb.WriteString("    return \"DIRECT\";\n")
+	sb.WriteString("}\n")
+	return sb.String()
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/detector"
+)
+
+func TestPACHosts(t *testing.T) {
+	services := []detector.Service{
+		{Name: "Grafana", Domain: "grafana.internal.example.com"},
+		{Name: "PostgreSQL", TargetHost: "172.18.0.5"},
+		{Name: "Redis", TargetHost: "172.18.0.5"},
+		{Name: "Prometheus", TargetHost: "localhost"},
+		{Name: "Web", Domain: "app.example.com, www.example.com"},
+		{Name: "Loopback", TargetHost: "127.0.0.1"},
+	}
+
+	got := pacHosts(services)
+	want := []string{"172.18.0.5", "app.example.com", "grafana.internal.example.com", "www.example.com"}
+
+	if len(got) != len(want) {
+		t.Fatalf("pacHosts() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pacHosts()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGeneratePAC(t *testing.T) {
+	pac := generatePAC([]string{"app.example.com"}, 1080)
+
+	if !strings.Contains(pac, "function FindProxyForURL(url, host)") {
+		t.Error("PAC file is missing FindProxyForURL")
+	}
+	if !strings.Contains(pac, `["app.example.com"]`) {
+		t.Error("PAC file is missing the host list")
+	}
+	if !strings.Contains(pac, "SOCKS5 127.0.0.1:1080") {
+		t.Error("PAC file is missing the SOCKS proxy address")
+	}
+	if !strings.Contains(pac, `return "DIRECT"`) {
+		t.Error("PAC file should default to DIRECT")
+	}
+
+	empty := generatePAC(nil, 1080)
+	if !strings.Contains(empty, "var hosts = [];") {
+		t.Error("PAC file without hosts should contain an empty list")
+	}
+}
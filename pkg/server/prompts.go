@@ -0,0 +1,105 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"mime"
+	"net"
+	"net/http"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/askpass"
+)
+
+// promptModal is added to every page when prompts are answered in the
+// dashboard. It polls /api/prompts and shows the oldest one.
+//
+//go:embed prompts.html
+var promptModal string
+
+// SetPrompts makes the dashboard answer the ssh prompts waiting in q.
+func (s *Server) SetPrompts(q *askpass.Queue) {
+	s.prompts = q
+}
+
+// withPromptModal adds the prompt modal to page when prompts are enabled.
+func (s *Server) withPromptModal(page string) string {
+	if s.prompts == nil {
+		return page
+	}
+	return insertBeforeBodyEnd(page, promptModal)
+}
+
+// fromLoopback reports whether r comes from this machine. Prompts carry
+// secrets, so they are not served to the rest of the network even when
+// --dashboard-bind makes the dashboard listen on other interfaces.
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handlePrompts lists the prompts waiting for an answer.
+func (s *Server) handlePrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !fromLoopback(r) {
+		http.Error(w, "Prompts are only available on localhost", http.StatusForbidden)
+		return
+	}
+
+	pending := []askpass.PendingPrompt{}
+	if s.prompts != nil {
+		pending = s.prompts.Pending()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(pending) //nolint:errcheck // Ignore encode error
+}
+
+// promptAnswer is the body of POST /api/prompts/answer.
+type promptAnswer struct {
+	ID     string `json:"id"`
+	Answer string `json:"answer"`
+	Cancel bool   `json:"cancel"`
+}
+
+// handleAnswerPrompt answers (POST {"id", "answer"}) or dismisses
+// (POST {"id", "cancel": true}) a waiting prompt.
+func (s *Server) handleAnswerPrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !fromLoopback(r) {
+		http.Error(w, "Prompts are only available on localhost", http.StatusForbidden)
+		return
+	}
+	if s.prompts == nil {
+		http.Error(w, "Prompts are not answered in the dashboard", http.StatusNotFound)
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body promptAnswer
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.prompts.Answer(body.ID, body.Answer, body.Cancel); err != nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck // Ignore encode error
+			"error": err.Error(),
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"answered": body.ID}) //nolint:errcheck // Ignore encode error
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/askpass"
+)
+
+func TestPromptEndpoints(t *testing.T) {
+	queue := askpass.NewQueue()
+	s := NewServer(0, nil)
+	s.SetPrompts(queue)
+
+	answered := make(chan string, 1)
+	go func() {
+		answer, _ := queue.Prompt(context.Background(), askpass.Request{Prompt: "Verification code: "})
+		answered <- answer
+	}()
+	for len(queue.Pending()) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/prompts", nil)
+	req.RemoteAddr = "192.0.2.10:51000"
+	rec := httptest.NewRecorder()
+	s.handlePrompts(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected prompts to be refused to other hosts, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/prompts", nil)
+	req.RemoteAddr = "127.0.0.1:51000"
+	rec = httptest.NewRecorder()
+	s.handlePrompts(rec, req)
+	var pending []askpass.PendingPrompt
+	if err := json.NewDecoder(rec.Body).Decode(&pending); err != nil || len(pending) != 1 {
+		t.Fatalf("expected one pending prompt, got %v (%v)", pending, err)
+	}
+
+	body := `{"id": "` + pending[0].ID + `", "answer": "123456"}`
+	req = httptest.NewRequest(http.MethodPost, "/api/prompts/answer", strings.NewReader(body))
+	req.RemoteAddr = "[::1]:51000"
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(tokenHeader, s.Token())
+	rec = httptest.NewRecorder()
+	s.guarded(s.handleAnswerPrompt)(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a form-encodable body to be refused, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/prompts/answer", strings.NewReader(body))
+	req.RemoteAddr = "[::1]:51000"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tokenHeader, s.Token())
+	rec = httptest.NewRecorder()
+	s.guarded(s.handleAnswerPrompt)(rec, req)
+	if got := <-answered; got != "123456" {
+		t.Errorf("Prompt() = %q, want the dashboard's answer", got)
+	}
+
+	if page := s.withPromptModal(loadingPage); !strings.Contains(page, "askpass-modal") || !strings.HasSuffix(page, "</body></html>") {
+		t.Error("expected the prompt modal inside the loading page body")
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// tokenHeader carries the session token on requests that change state.
+// Browsers only let scripts of the dashboard's own origin set it, and only
+// those pages embed the token.
+const tokenHeader = "X-Dashboard-Token"
+
+// newToken returns a random session token.
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Token returns the session token that requests changing state must send in
+// the X-Dashboard-Token header.
+func (s *Server) Token() string {
+	return s.token
+}
+
+// withToken embeds the session token in page for its scripts.
+func (s *Server) withToken(page string) string {
+	return insertBeforeBodyEnd(page, `<script>const dashboardToken = '`+s.token+`';</script>`)
+}
+
+// insertBeforeBodyEnd adds snippet at the end of page's body.
+func insertBeforeBodyEnd(page, snippet string) string {
+	if i := strings.LastIndex(page, "</body>"); i >= 0 {
+		return page[:i] + snippet + page[i:]
+	}
+	return page + snippet
+}
+
+// knownHost reports whether r is addressed to this server by "localhost" or
+// an IP address. Any other name may be a DNS rebinding attack, where a site
+// points its own name at 127.0.0.1 to read the dashboard as same-origin.
+func knownHost(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || strings.HasSuffix(host, ".localhost") || net.ParseIP(host) != nil
+}
+
+// sameOrigin reports whether r was sent by a page of this server. Requests
+// without an Origin header do not come from a browser's cross-site fetch.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// checkHost rejects requests addressed to a name other than localhost or an
+// IP address, see knownHost.
+func checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !knownHost(r) {
+			http.Error(w, "Unknown host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// guarded only lets requests through that carry the session token and come
+// from the dashboard's own pages, so other sites open in the same browser
+// cannot make the dashboard act on their behalf.
+func (s *Server) guarded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		token := r.Header.Get(tokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			http.Error(w, "Missing or invalid dashboard token", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
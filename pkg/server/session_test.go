@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGuarded(t *testing.T) {
+	s := NewServer(8080, nil)
+	handler := s.guarded(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		origin string
+		want   int
+	}{
+		{"dashboard page", s.Token(), "http://localhost:8080", http.StatusNoContent},
+		{"no browser", s.Token(), "", http.StatusNoContent},
+		{"missing token", "", "http://localhost:8080", http.StatusForbidden},
+		{"wrong token", "0123", "", http.StatusForbidden},
+		{"other site", s.Token(), "https://evil.example", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/tunnels/reverse?spec=8000:3000", nil)
+			if tt.token != "" {
+				req.Header.Set(tokenHeader, tt.token)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	handler := checkHost(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := map[string]int{
+		"localhost:8080":         http.StatusNoContent,
+		"127.0.0.1:8080":         http.StatusNoContent,
+		"[::1]:8080":             http.StatusNoContent,
+		"192.0.2.10:8080":        http.StatusNoContent,
+		"rebind.example:8080":    http.StatusForbidden,
+		"localhost.example:8080": http.StatusForbidden,
+	}
+
+	for host, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/services", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Host %s: status = %d, want %d", host, rec.Code, want)
+		}
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	s := NewServer(8080, nil)
+	page := s.withPromptModal(s.withToken(loadingPage))
+	if !strings.Contains(page, "const dashboardToken = '"+s.Token()+"'") {
+		t.Error("expected the token in the page")
+	}
+	if other := NewServer(8080, nil); other.Token() == s.Token() {
+		t.Error("expected a new token per server")
+	}
+}
@@ -3,19 +3,47 @@ package ssh
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/sshconfig"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 )
 
 type Config struct {
-	Server       string
-	User         string
-	KeyPath      string
+	Server  string
+	User    string
+	KeyPath string
+	// IdentityFiles are tried after KeyPath, in order, each passed with -i
+	IdentityFiles []string
+	// Port is the server's SSH port, 0 for the default
+	Port int
+	// ProxyJump is a comma-separated list of jump hosts for -J
+	ProxyJump    string
 	UseHostAlias bool
 	HostAlias    string
 	Insecure     bool
+	// KnownHostsFile replaces the user's known_hosts, and host keys missing
+	// from it are rejected instead of prompted for
+	KnownHostsFile string
+	// ControlPath is the socket of a shared ControlMaster connection. When set,
+	// commands are sent over the existing connection instead of a new login.
+	ControlPath string
+	// Env is added to the environment of every ssh process, e.g. SSH_ASKPASS
+	Env []string
+	// ProxyCommand connects to the server instead of ssh, see
+	// HelperProxyCommand. With ProxyJump it connects to the first jump host.
+	ProxyCommand string
 }
 
 type Client struct {
+	// mu guards config.ControlPath, which SetControlPath may change while
+	// commands are being built
+	mu     sync.RWMutex
 	config Config
 }
 
@@ -23,64 +51,262 @@ func NewClient(config Config) *Client {
 	return &Client{config: config}
 }
 
+// SetInsecure turns strict host key checking off or back on. It must be
+// called before the client runs anything.
+func (c *Client) SetInsecure(insecure bool) {
+	c.config.Insecure = insecure
+}
+
 func (c *Client) BuildCommand(remoteCmd string) *exec.Cmd {
 	args := c.buildSSHArgs(remoteCmd)
-	return exec.Command("ssh", args...)
+	cmd := exec.Command("ssh", args...)
+	c.setEnv(cmd)
+	return cmd
 }
 
 // BuildCommandWithContext builds an SSH command with context support for cancellation.
 func (c *Client) BuildCommandWithContext(ctx context.Context, remoteCmd string) *exec.Cmd {
 	args := c.buildSSHArgs(remoteCmd)
-	return exec.CommandContext(ctx, "ssh", args...)
+	return c.command(ctx, args)
+}
+
+// command creates an ssh process with args and Config.Env.
+func (c *Client) command(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	c.setEnv(cmd)
+	return cmd
+}
+
+func (c *Client) setEnv(cmd *exec.Cmd) {
+	if len(c.config.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.config.Env...)
+	}
 }
 
 func (c *Client) BuildTunnelCommand(ctx context.Context, localPort, remotePort int) *exec.Cmd {
+	return c.BuildTunnelCommandTo(ctx, localPort, DefaultTargetHost, remotePort)
+}
+
+// BuildTunnelCommandTo builds a local forward to targetHost:remotePort as seen
+// from the SSH server, e.g. a container IP on a Docker network.
+func (c *Client) BuildTunnelCommandTo(ctx context.Context, localPort int, targetHost string, remotePort int) *exec.Cmd {
+	return c.BuildForwardCommand(ctx, "-L", ForwardSpec(localPort, targetHost, remotePort))
+}
+
+// BuildReverseTunnelCommand builds a remote forward that exposes localPort on
+// this machine as remotePort on the SSH server. An empty bindAddress listens
+// on the server's loopback interface only.
+func (c *Client) BuildReverseTunnelCommand(ctx context.Context, bindAddress string, remotePort, localPort int) *exec.Cmd {
+	return c.BuildForwardCommand(ctx, "-R", ReverseForwardSpec(bindAddress, remotePort, DefaultTargetHost, localPort))
+}
+
+// BuildForwardCommand builds a long-running ssh process that only carries
+// forwards, e.g. "-L", spec or "-D", address. The process exits if a forward
+// cannot be set up, so a failed remote bind is not mistaken for a live tunnel.
+func (c *Client) BuildForwardCommand(ctx context.Context, forwardArgs ...string) *exec.Cmd {
+	args := append([]string{}, forwardArgs...)
+	args = append(args, "-N", "-o", "ExitOnForwardFailure=yes")
+	args = append(args, c.optionArgs()...)
+	args = append(args, c.destinationArgs()...)
+
+	return c.command(ctx, args)
+}
+
+// BuildStdioForwardCommand builds an ssh process whose stdin and stdout are
+// connected to addr as reached from the server (ssh -W).
+func (c *Client) BuildStdioForwardCommand(ctx context.Context, addr string) *exec.Cmd {
+	args := []string{"-W", addr, "-o", "ClearAllForwardings=yes"}
+	args = append(args, c.optionArgs()...)
+	args = append(args, c.destinationArgs()...)
+
+	return c.command(ctx, args)
+}
+
+// BuildMasterCommand builds the long-running ControlMaster process that owns
+// the shared connection at ControlPath.
+func (c *Client) BuildMasterCommand(ctx context.Context) *exec.Cmd {
 	args := []string{
-		"-L", fmt.Sprintf("%d:localhost:%d", localPort, remotePort),
-		"-N",
+		"-M", "-N",
+		"-o", "ControlPersist=no",
 	}
+	args = append(args, c.optionArgs()...)
+	args = append(args, c.destinationArgs()...)
 
-	if c.config.Insecure {
-		args = append(args,
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-		)
-	}
+	return c.command(ctx, args)
+}
 
-	args = append(args, "-o", "LogLevel=ERROR")
+// BuildControlCommand builds a request to the running master, such as
+// "check", "exit", or "forward"/"cancel" combined with a -L spec.
+func (c *Client) BuildControlCommand(ctx context.Context, operation string, extraArgs ...string) *exec.Cmd {
+	args := []string{"-O", operation}
+	args = append(args, extraArgs...)
+	args = append(args, c.optionArgs()...)
+	args = append(args, c.destinationArgs()...)
 
-	if c.config.UseHostAlias {
-		args = append(args, c.config.HostAlias)
-	} else {
-		if c.config.KeyPath != "" {
-			args = append(args, "-i", c.config.KeyPath)
-		}
-		args = append(args, fmt.Sprintf("%s@%s", c.config.User, c.config.Server))
+	return c.command(ctx, args)
+}
+
+// DefaultTargetHost is the forward destination when none is given: the SSH
+// server itself.
+const DefaultTargetHost = transport.DefaultTargetHost
+
+// ForwardSpec formats a local forward specification for -L.
+func ForwardSpec(localPort int, targetHost string, remotePort int) string {
+	return fmt.Sprintf("%d:%s:%d", localPort, bracketIPv6(transport.TargetHost(targetHost)), remotePort)
+}
+
+// ReverseForwardSpec formats a remote forward specification for -R.
+func ReverseForwardSpec(bindAddress string, remotePort int, localHost string, localPort int) string {
+	spec := fmt.Sprintf("%d:%s:%d", remotePort, bracketIPv6(transport.TargetHost(localHost)), localPort)
+	if bindAddress != "" {
+		spec = bracketIPv6(bindAddress) + ":" + spec
 	}
+	return spec
+}
+
+// SocketForwardSpec formats a -L specification that forwards local, a port
+// number or a local socket path, to a Unix socket on the SSH server.
+func SocketForwardSpec(local, remoteSocket string) string {
+	return local + ":" + remoteSocket
+}
 
-	return exec.CommandContext(ctx, "ssh", args...)
+func bracketIPv6(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
 }
 
 func (c *Client) buildSSHArgs(remoteCmd string) []string {
-	var args []string
+	// Commands never carry forwards, so LocalForward entries in ssh_config
+	// must not be bound by every one of them
+	args := []string{"-o", "ClearAllForwardings=yes"}
+	args = append(args, c.optionArgs()...)
+	args = append(args, c.destinationArgs()...)
+	return append(args, remoteCmd)
+}
+
+// optionArgs returns the -o options shared by every ssh invocation.
+func (c *Client) optionArgs() []string {
+	args := c.hostKeyArgs()
+	args = append(args, "-o", "LogLevel=ERROR")
+
+	c.mu.RLock()
+	controlPath := c.config.ControlPath
+	c.mu.RUnlock()
+	if controlPath != "" {
+		args = append(args, "-o", "ControlPath="+controlPath)
+	}
 
+	return args
+}
+
+// hostKeyArgs returns the options that replace the user's known_hosts
+// checks, nil to keep them.
+func (c *Client) hostKeyArgs() []string {
 	if c.config.Insecure {
-		args = append(args,
+		return []string{
 			"-o", "StrictHostKeyChecking=no",
 			"-o", "UserKnownHostsFile=/dev/null",
-		)
+		}
 	}
+	if c.config.KnownHostsFile != "" {
+		return []string{
+			"-o", "StrictHostKeyChecking=yes",
+			"-o", "UserKnownHostsFile=" + c.config.KnownHostsFile,
+		}
+	}
+	return nil
+}
 
-	args = append(args, "-o", "LogLevel=ERROR")
+// jumpProxyCommand returns a ProxyCommand that reaches the server through
+// the ProxyJump hosts with the same host key options as the server, or ""
+// when -J does as well. ssh -J does not give the jump connections the
+// options of the command line, so --insecure or tunnel-dash's own
+// known_hosts would only apply to the last hop, and it cannot reach the
+// first hop through Config.ProxyCommand. Each hop is an "ssh -W" whose own
+// ProxyCommand is the hop before it, and the first hop's is
+// Config.ProxyCommand. They inherit Config.Env from the ssh process.
+func (c *Client) jumpProxyCommand() string {
+	options := c.hostKeyArgs()
+	if c.config.ProxyJump == "" || (len(options) == 0 && c.config.ProxyCommand == "") {
+		return ""
+	}
+	hops, err := sshconfig.ParseProxyJump(c.config.ProxyJump)
+	if err != nil || len(hops) == 0 {
+		// Leave it to ssh -J to report
+		return ""
+	}
 
-	if c.config.UseHostAlias {
-		args = append(args, c.config.HostAlias, remoteCmd)
-	} else {
-		if c.config.KeyPath != "" {
-			args = append(args, "-i", c.config.KeyPath)
+	var quoted []string
+	for _, option := range append(options, "-o", "LogLevel=ERROR") {
+		if option != "-o" {
+			option = shellQuote(option)
 		}
-		args = append(args, fmt.Sprintf("%s@%s", c.config.User, c.config.Server), remoteCmd)
+		quoted = append(quoted, option)
 	}
 
-	return args
+	command := c.config.ProxyCommand
+	for _, hop := range hops {
+		args := append([]string{"ssh"}, quoted...)
+		if command != "" {
+			// ssh expands %h and %p in the whole command; the inner ones
+			// belong to the next ssh in the chain
+			args = append(args, "-o", shellQuote("ProxyCommand="+strings.ReplaceAll(command, "%", "%%")))
+		}
+		if hop.User != "" {
+			args = append(args, "-l", shellQuote(hop.User))
+		}
+		if hop.Port != 0 {
+			args = append(args, "-p", strconv.Itoa(hop.Port))
+		}
+		args = append(args, "-W", shellQuote("[%h]:%p"), shellQuote(hop.Host))
+		command = strings.Join(args, " ")
+	}
+	return command
+}
+
+// destinationArgs returns the key, port, jump and destination arguments.
+func (c *Client) destinationArgs() []string {
+	var args []string
+	if command := c.jumpProxyCommand(); command != "" {
+		// ssh refuses ProxyCommand together with -J
+		args = append(args, "-o", "ProxyCommand="+command)
+	} else if c.config.ProxyCommand != "" {
+		args = append(args, "-o", "ProxyCommand="+c.config.ProxyCommand)
+	} else if c.config.ProxyJump != "" {
+		// Overrides a ProxyJump from ssh_config, like it does on the command line
+		args = append(args, "-J", c.config.ProxyJump)
+	}
+	if c.config.UseHostAlias {
+		return append(args, c.config.HostAlias)
+	}
+
+	if c.config.KeyPath != "" {
+		args = append(args, "-i", c.config.KeyPath)
+	}
+	for _, identity := range c.config.IdentityFiles {
+		args = append(args, "-i", identity)
+	}
+	if c.config.Port != 0 && c.config.Port != 22 {
+		args = append(args, "-p", strconv.Itoa(c.config.Port))
+	}
+	return append(args, fmt.Sprintf("%s@%s", c.config.User, c.config.Server))
+}
+
+// HelperProxyCommand returns a ProxyCommand that runs helper, the
+// tunnel-dash binary, as "proxy-connect" to connect through the proxy in
+// the proxy.URLEnv environment variable. The URL stays out of the command
+// line, which other users can read, because it may carry a password.
+func HelperProxyCommand(helper string) string {
+	// ssh expands %h and %p; percent signs in the path must survive that
+	return strings.ReplaceAll(shellQuote(helper), "%", "%%") + " proxy-connect %h %p"
+}
+
+// shellQuote quotes s for the shell that ssh runs ProxyCommand with.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
@@ -2,8 +2,17 @@ package ssh
 
 import (
 	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 )
 
 func TestNewClient(t *testing.T) {
@@ -141,6 +150,134 @@ func TestBuildCommandInsecure(t *testing.T) {
 	}
 }
 
+func TestBuildCommandKnownHostsFile(t *testing.T) {
+	client := NewClient(Config{
+		Server:         "example.com",
+		User:           "testuser",
+		KnownHostsFile: "/home/me/.config/tunnel-dash/known_hosts",
+	})
+	args := strings.Join(client.BuildCommand("ls").Args, " ")
+
+	for _, want := range []string{"-o StrictHostKeyChecking=yes", "-o UserKnownHostsFile=/home/me/.config/tunnel-dash/known_hosts"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Expected %q in %s", want, args)
+		}
+	}
+}
+
+func TestBuildCommandPortAndJump(t *testing.T) {
+	client := NewClient(Config{
+		Server:    "example.com",
+		User:      "testuser",
+		Port:      2222,
+		ProxyJump: "admin@bastion:22,inner",
+	})
+
+	args := strings.Join(client.BuildCommand("ls").Args, " ")
+	for _, want := range []string{"-o ClearAllForwardings=yes", "-p 2222", "-J admin@bastion:22,inner", "testuser@example.com ls"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Expected %q in %s", want, args)
+		}
+	}
+
+	alias := NewClient(Config{UseHostAlias: true, HostAlias: "myserver", ProxyJump: "bastion"})
+	if args := strings.Join(alias.BuildCommand("ls").Args, " "); !strings.Contains(args, "-J bastion myserver ls") {
+		t.Errorf("Expected jump before the host alias in %s", args)
+	}
+
+	// Forward processes must keep their own -L
+	forward := strings.Join(client.BuildTunnelCommand(context.Background(), 9000, 3000).Args, " ")
+	if strings.Contains(forward, "ClearAllForwardings") {
+		t.Errorf("Forward command must not clear forwardings: %s", forward)
+	}
+}
+
+func TestBuildCommandIdentityFiles(t *testing.T) {
+	client := NewClient(Config{
+		Server:        "example.com",
+		User:          "testuser",
+		KeyPath:       "/keys/cli",
+		IdentityFiles: []string{"/keys/app", "/keys/app-old"},
+	})
+
+	args := strings.Join(client.BuildCommand("ls").Args, " ")
+	if want := "-i /keys/cli -i /keys/app -i /keys/app-old"; !strings.Contains(args, want) {
+		t.Errorf("Expected %q in %s", want, args)
+	}
+}
+
+func TestBuildCommandJumpHostKeyOptions(t *testing.T) {
+	client := NewClient(Config{
+		Server:         "example.com",
+		User:           "testuser",
+		ProxyJump:      "admin@bastion:2200,inner",
+		KnownHostsFile: "/home/me/known_hosts",
+	})
+
+	args := client.BuildCommand("ls").Args
+	if joined := strings.Join(args, " "); strings.Contains(joined, " -J ") {
+		t.Errorf("-J would not pass the known_hosts file to the jump hosts: %s", joined)
+	}
+
+	inner := `ssh -o 'StrictHostKeyChecking=yes' -o 'UserKnownHostsFile=/home/me/known_hosts' -o 'LogLevel=ERROR' -l 'admin' -p 2200 -W '[%h]:%p' 'bastion'`
+	want := `ProxyCommand=ssh -o 'StrictHostKeyChecking=yes' -o 'UserKnownHostsFile=/home/me/known_hosts' -o 'LogLevel=ERROR' ` +
+		`-o 'ProxyCommand=` + strings.ReplaceAll(strings.ReplaceAll(inner, "%", "%%"), "'", `'\''`) + `' -W '[%h]:%p' 'inner'`
+	if runtime.GOOS != "windows" && !slices.Contains(args, want) {
+		t.Errorf("Expected %s in %q", want, args)
+	}
+
+	// Without options to pass on, -J is enough
+	plain := NewClient(Config{Server: "example.com", User: "testuser", ProxyJump: "bastion"})
+	if joined := strings.Join(plain.BuildCommand("ls").Args, " "); !strings.Contains(joined, "-J bastion") {
+		t.Errorf("Expected -J in %s", joined)
+	}
+}
+
+func TestBuildCommandProxyCommand(t *testing.T) {
+	proxyCommand := HelperProxyCommand("/opt/tunnel%dash")
+	want := `'/opt/tunnel%%dash' proxy-connect %h %p`
+	if runtime.GOOS != "windows" && proxyCommand != want {
+		t.Errorf("HelperProxyCommand() = %s, want %s", proxyCommand, want)
+	}
+
+	client := NewClient(Config{Server: "example.com", User: "testuser", ProxyCommand: proxyCommand})
+	if args := client.BuildCommand("ls").Args; !slices.Contains(args, "ProxyCommand="+proxyCommand) {
+		t.Errorf("Expected the ProxyCommand option in %q", args)
+	}
+
+	// The first jump host is reached through the proxy, the server through
+	// the jump hosts
+	client = NewClient(Config{Server: "example.com", User: "testuser", ProxyJump: "bastion,admin@inner:2222", ProxyCommand: proxyCommand})
+	args := client.BuildCommand("ls").Args
+	if joined := strings.Join(args, " "); strings.Contains(joined, " -J ") {
+		t.Errorf("ProxyCommand must replace -J: %s", joined)
+	}
+	bastion := `ssh -o 'LogLevel=ERROR' -o 'ProxyCommand=` + strings.ReplaceAll(strings.ReplaceAll(proxyCommand, "%", "%%"), "'", `'\''`) + `' -W '[%h]:%p' 'bastion'`
+	want = `ProxyCommand=ssh -o 'LogLevel=ERROR' -o 'ProxyCommand=` + strings.ReplaceAll(strings.ReplaceAll(bastion, "%", "%%"), "'", `'\''`) +
+		`' -l 'admin' -p 2222 -W '[%h]:%p' 'inner'`
+	if runtime.GOOS != "windows" && !slices.Contains(args, want) {
+		t.Errorf("Expected %s in %q", want, args)
+	}
+}
+
+func TestBuildCommandEnv(t *testing.T) {
+	plain := NewClient(Config{Server: "example.com", User: "testuser"})
+	if cmd := plain.BuildCommand("ls"); cmd.Env != nil {
+		t.Errorf("Expected the inherited environment without Env, got %v", cmd.Env)
+	}
+
+	client := NewClient(Config{Server: "example.com", User: "testuser", Env: []string{"SSH_ASKPASS=/usr/bin/tunnel-dash"}})
+	for _, cmd := range []*exec.Cmd{
+		client.BuildCommand("ls"),
+		client.BuildTunnelCommand(context.Background(), 9000, 3000),
+		client.BuildMasterCommand(context.Background()),
+	} {
+		if len(cmd.Env) == 0 || cmd.Env[len(cmd.Env)-1] != "SSH_ASKPASS=/usr/bin/tunnel-dash" {
+			t.Errorf("Expected SSH_ASKPASS in the environment of %v", cmd.Args)
+		}
+	}
+}
+
 func TestBuildTunnelCommand(t *testing.T) {
 	config := Config{
 		Server:   "example.com",
@@ -214,3 +351,225 @@ func TestBuildTunnelCommandWithHostAlias(t *testing.T) {
 		t.Error("Host alias not found in tunnel command args")
 	}
 }
+
+func TestBuildCommandWithControlPath(t *testing.T) {
+	config := Config{
+		Server:      "example.com",
+		User:        "testuser",
+		ControlPath: "/tmp/tdash-1/cm",
+	}
+
+	client := NewClient(config)
+	cmd := client.BuildCommand("ss -tlnp")
+
+	found := false
+	args := cmd.Args
+	for i, arg := range args {
+		if arg == "-o" && i+1 < len(args) && args[i+1] == "ControlPath=/tmp/tdash-1/cm" {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Error("ControlPath option not found in command args")
+	}
+}
+
+func TestBuildMasterCommand(t *testing.T) {
+	config := Config{
+		UseHostAlias: true,
+		HostAlias:    "myserver",
+		ControlPath:  "/tmp/tdash-1/cm",
+	}
+
+	client := NewClient(config)
+	cmd := client.BuildMasterCommand(context.Background())
+
+	args := cmd.Args
+	if len(args) < 3 || args[1] != "-M" || args[2] != "-N" {
+		t.Errorf("Expected command to start with -M -N, got %v", args)
+	}
+	if args[len(args)-1] != "myserver" {
+		t.Errorf("Expected host alias as last arg, got %s", args[len(args)-1])
+	}
+}
+
+func TestBuildControlCommand(t *testing.T) {
+	config := Config{
+		Server:      "example.com",
+		User:        "testuser",
+		ControlPath: "/tmp/tdash-1/cm",
+	}
+
+	client := NewClient(config)
+	cmd := client.BuildControlCommand(context.Background(), "forward", "-L", ForwardSpec(9000, "", 3000))
+
+	want := []string{"-O", "forward", "-L", "9000:localhost:3000"}
+	args := cmd.Args[1:]
+	for i, arg := range want {
+		if args[i] != arg {
+			t.Fatalf("Expected args to start with %v, got %v", want, args)
+		}
+	}
+	if args[len(args)-1] != "testuser@example.com" {
+		t.Errorf("Expected user@host as last arg, got %s", args[len(args)-1])
+	}
+}
+
+func TestBuildTunnelCommandTo(t *testing.T) {
+	tests := []struct {
+		name       string
+		targetHost string
+		want       string
+	}{
+		{name: "container IPv4", targetHost: "172.18.0.5", want: "15432:172.18.0.5:5432"},
+		{name: "container IPv6", targetHost: "fd00::5", want: "15432:[fd00::5]:5432"},
+		{name: "default host", targetHost: "", want: "15432:localhost:5432"},
+	}
+
+	client := NewClient(Config{Server: "example.com", User: "testuser"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := client.BuildTunnelCommandTo(context.Background(), 15432, tt.targetHost, 5432)
+			if len(cmd.Args) < 3 || cmd.Args[1] != "-L" || cmd.Args[2] != tt.want {
+				t.Errorf("Expected -L %s, got %v", tt.want, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildReverseTunnelCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		bindAddress string
+		want        string
+	}{
+		{name: "loopback", bindAddress: "", want: "8000:localhost:3000"},
+		{name: "all interfaces", bindAddress: "0.0.0.0", want: "0.0.0.0:8000:localhost:3000"},
+		{name: "docker bridge IPv6", bindAddress: "fd00::1", want: "[fd00::1]:8000:localhost:3000"},
+	}
+
+	client := NewClient(Config{Server: "example.com", User: "testuser"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := client.BuildReverseTunnelCommand(context.Background(), tt.bindAddress, 8000, 3000)
+			if len(cmd.Args) < 3 || cmd.Args[1] != "-R" || cmd.Args[2] != tt.want {
+				t.Errorf("Expected -R %s, got %v", tt.want, cmd.Args)
+			}
+
+			found := false
+			for i, arg := range cmd.Args {
+				if arg == "-o" && i+1 < len(cmd.Args) && cmd.Args[i+1] == "ExitOnForwardFailure=yes" {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Error("ExitOnForwardFailure option not found in command args")
+			}
+		})
+	}
+}
+
+func TestSocketForwardSpec(t *testing.T) {
+	if got := SocketForwardSpec("9000", "/var/run/docker.sock"); got != "9000:/var/run/docker.sock" {
+		t.Errorf("SocketForwardSpec() = %q, want %q", got, "9000:/var/run/docker.sock")
+	}
+	if got := SocketForwardSpec("/tmp/docker.sock", "/var/run/docker.sock"); got != "/tmp/docker.sock:/var/run/docker.sock" {
+		t.Errorf("SocketForwardSpec() = %q, want %q", got, "/tmp/docker.sock:/var/run/docker.sock")
+	}
+}
+
+func TestForwardArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		forward transport.Forward
+		want    []string
+	}{
+		{
+			name:    "local forward",
+			forward: transport.Forward{Kind: transport.ForwardLocal, LocalPort: 9000, RemotePort: 3000},
+			want:    []string{"-L", "9000:localhost:3000"},
+		},
+		{
+			name:    "container forward",
+			forward: transport.Forward{Kind: transport.ForwardLocal, LocalPort: 5432, RemoteHost: "172.18.0.5", RemotePort: 5432},
+			want:    []string{"-L", "5432:172.18.0.5:5432"},
+		},
+		{
+			name:    "dynamic forward",
+			forward: transport.Forward{Kind: transport.ForwardDynamic, LocalPort: 1080},
+			want:    []string{"-D", "127.0.0.1:1080"},
+		},
+		{
+			name:    "reverse forward",
+			forward: transport.Forward{Kind: transport.ForwardReverse, BindAddress: "172.17.0.1", RemotePort: 8000, LocalPort: 3000},
+			want:    []string{"-R", "172.17.0.1:8000:localhost:3000"},
+		},
+		{
+			name:    "socket to local port",
+			forward: transport.Forward{Kind: transport.ForwardLocal, LocalPort: 9005, RemoteSocket: "/var/run/docker.sock"},
+			want:    []string{"-L", "9005:/var/run/docker.sock"},
+		},
+		{
+			name:    "socket to local socket",
+			forward: transport.Forward{Kind: transport.ForwardLocal, LocalSocket: "/tmp/docker.sock", RemoteSocket: "/var/run/docker.sock"},
+			want:    []string{"-L", "/tmp/docker.sock:/var/run/docker.sock"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ForwardArgs(tt.forward)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("ForwardArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetControlPath(t *testing.T) {
+	client := NewClient(Config{UseHostAlias: true, HostAlias: "myserver"})
+	client.SetControlPath("/tmp/tdash-1/cm")
+
+	cmd := client.BuildControlCommand(context.Background(), "check")
+	if !strings.Contains(strings.Join(cmd.Args, " "), "-o ControlPath=/tmp/tdash-1/cm") {
+		t.Errorf("Expected ControlPath option after SetControlPath, got %v", cmd.Args)
+	}
+}
+
+func TestDial(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as ssh")
+	}
+
+	// A fake ssh that echoes its stdin stands in for "ssh -W"
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\nexec cat\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	client := NewClient(Config{Server: "bastion", User: "admin"})
+	conn, err := client.Dial(context.Background(), "tcp", "10.0.0.5:22")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("read %q, %v; want ping", buf, err)
+	}
+
+	if _, err := client.Dial(context.Background(), "unix", "/tmp/sock"); err == nil {
+		t.Error("expected an error for a unix socket")
+	}
+}
@@ -0,0 +1,178 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// Client runs everything through the OpenSSH binary.
+var (
+	_ transport.Transport   = (*Client)(nil)
+	_ transport.Multiplexer = (*Client)(nil)
+	_ transport.Dialer      = (*Client)(nil)
+)
+
+// Run executes command on the server and returns its standard output. When
+// the command fails, its stderr is included in the error.
+func (c *Client) Run(ctx context.Context, command string) ([]byte, error) {
+	cmd := c.BuildCommandWithContext(ctx, command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return output, remoteError(command, err, stderr.String())
+	}
+	return output, nil
+}
+
+// Forward starts an ssh process that carries f until ctx is canceled.
+func (c *Client) Forward(ctx context.Context, f transport.Forward) (transport.Handle, error) {
+	args := ForwardArgs(f)
+	return startProcess(c.BuildForwardCommand(ctx, args...), strings.Join(args, " "))
+}
+
+// Dial connects to addr as reached from the server through an ssh -W
+// process. Closing the connection stops the process.
+func (c *Client) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	local, remote := net.Pipe()
+	cmd := c.BuildStdioForwardCommand(ctx, addr)
+	cmd.Stdin = remote
+	cmd.Stdout = remote
+	// Stops copying stdin once ssh has exited on its own
+	cmd.WaitDelay = time.Second
+
+	p, err := startProcess(cmd, "-W "+addr)
+	if err != nil {
+		cancel()
+		_ = local.Close()  //nolint:errcheck // Never used
+		_ = remote.Close() //nolint:errcheck // Never used
+		return nil, err
+	}
+	go func() {
+		_ = p.Wait()       //nolint:errcheck // The reader sees EOF instead
+		_ = remote.Close() //nolint:errcheck // Ends reads on local
+	}()
+	return &processConn{Conn: local, cancel: cancel}, nil
+}
+
+// processConn is one end of a pipe whose other end is an ssh process.
+type processConn struct {
+	net.Conn
+	cancel context.CancelFunc
+}
+
+func (c *processConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// SetControlPath makes later commands and forwards use the ControlMaster
+// socket at path.
+func (c *Client) SetControlPath(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.ControlPath = path
+}
+
+// Master starts the ControlMaster process for the configured control path.
+func (c *Client) Master(ctx context.Context) (transport.Handle, error) {
+	return startProcess(c.BuildMasterCommand(ctx), "ControlMaster")
+}
+
+// Control sends an "ssh -O" request to the running master, adding or
+// canceling f for the "forward" and "cancel" operations.
+func (c *Client) Control(ctx context.Context, operation string, f *transport.Forward) error {
+	var extraArgs []string
+	if f != nil {
+		extraArgs = ForwardArgs(*f)
+	}
+
+	output, err := c.BuildControlCommand(ctx, operation, extraArgs...).CombinedOutput()
+	if err != nil {
+		return remoteError(strings.Join(append([]string{"-O", operation}, extraArgs...), " "), err, string(output))
+	}
+	return nil
+}
+
+// ForwardArgs returns the -L, -D or -R arguments that request f.
+func ForwardArgs(f transport.Forward) []string {
+	switch f.Kind {
+	case transport.ForwardDynamic:
+		return []string{"-D", fmt.Sprintf("127.0.0.1:%d", f.LocalPort)}
+	case transport.ForwardReverse:
+		return []string{"-R", ReverseForwardSpec(f.BindAddress, f.RemotePort, DefaultTargetHost, f.LocalPort)}
+	}
+
+	if f.RemoteSocket != "" {
+		local := f.LocalSocket
+		if local == "" {
+			local = strconv.Itoa(f.LocalPort)
+		}
+		return []string{"-L", SocketForwardSpec(local, f.RemoteSocket)}
+	}
+	return []string{"-L", ForwardSpec(f.LocalPort, f.RemoteHost, f.RemotePort)}
+}
+
+// process is a running ssh command whose stderr is kept for error reports.
+type process struct {
+	cmd *exec.Cmd
+	// op describes the process in errors
+	op     string
+	stderr bytes.Buffer
+	done   chan struct{}
+	err    error
+}
+
+func startProcess(cmd *exec.Cmd, op string) (*process, error) {
+	p := &process{cmd: cmd, op: op, done: make(chan struct{})}
+	cmd.Stderr = &p.stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			err = remoteError(p.op, err, p.stderr.String())
+		}
+		p.err = err
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Wait returns once the process has exited.
+func (p *process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// remoteError classifies a failed ssh invocation from its exit status and
+// stderr.
+func remoteError(command string, err error, stderr string) error {
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	return transport.NewError(command, err, exitCode, stderr)
+}
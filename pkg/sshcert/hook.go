@@ -0,0 +1,223 @@
+// Package sshcert keeps short-lived SSH certificates fresh. A Hook runs the
+// user's cert_command, which asks a CA such as step-ca or Vault for a
+// certificate and writes it next to the identity file as <key>-cert.pub,
+// where ssh and the native backend pick it up. It is run again before the
+// certificate expires and when a login fails with an expired one.
+package sshcert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultRenewBefore is how long before expiry the command runs again
+	DefaultRenewBefore = 15 * time.Minute
+	// retryInterval paces renewals after the command failed
+	retryInterval = time.Minute
+	// clockSkew is tolerated between this machine and the server
+	clockSkew = time.Minute
+)
+
+// Environment variables set for the command, besides the ssh_config tokens
+// already expanded in it.
+const (
+	HostEnv     = "TUNNEL_DASH_HOST"
+	UserEnv     = "TUNNEL_DASH_USER"
+	IdentityEnv = "TUNNEL_DASH_IDENTITY"
+	CertEnv     = "TUNNEL_DASH_CERT"
+)
+
+// ErrNoCertificate is returned when the command did not leave a certificate.
+var ErrNoCertificate = errors.New("no certificate")
+
+// CertPath is where ssh looks for the certificate of identity.
+func CertPath(identity string) string {
+	return identity + "-cert.pub"
+}
+
+// Validity returns the validity window of the certificate at path. before is
+// zero for certificates that never expire.
+func Validity(path string) (after, before time.Time, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return after, before, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return after, before, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return after, before, fmt.Errorf("%s: %w, found a plain %s key", path, ErrNoCertificate, key.Type())
+	}
+
+	after = time.Unix(int64(cert.ValidAfter), 0)
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		before = time.Unix(int64(cert.ValidBefore), 0)
+	}
+	return after, before, nil
+}
+
+// Hook runs Command to obtain the certificate for Identity.
+type Hook struct {
+	// Host names the server in messages and the environment
+	Host string
+	User string
+	// Identity is the private key the certificate is issued for
+	Identity string
+	Command  string
+	// RenewBefore is how long before expiry to renew, DefaultRenewBefore
+	// when zero. Certificates shorter-lived than twice that are renewed
+	// halfway through.
+	RenewBefore time.Duration
+
+	mu sync.Mutex
+	// after and before are the validity of the last certificate seen
+	after, before time.Time
+}
+
+// Ensure runs the command unless the certificate is valid and not due for
+// renewal. It returns when the certificate expires, zero for never.
+func (h *Hook) Ensure(ctx context.Context) (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.load() && time.Now().Before(h.renewAt()) {
+		return h.before, nil
+	}
+	return h.run(ctx)
+}
+
+// Refresh runs the command again if the certificate has expired or is not
+// valid yet, as seen by a server whose clock may be off by a minute. It
+// reports whether the command ran; after an authentication failure that
+// tells whether a retry can help.
+func (h *Hook) Refresh(ctx context.Context) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.load() && h.validAt(time.Now().Add(clockSkew)) {
+		return false, nil
+	}
+	_, err := h.run(ctx)
+	return true, err
+}
+
+// RenewAt returns when Watch renews the certificate, zero when it does not
+// expire or was never obtained.
+func (h *Hook) RenewAt() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.renewAt()
+}
+
+// load reads the certificate's validity, reporting whether there is one.
+func (h *Hook) load() bool {
+	after, before, err := Validity(CertPath(h.Identity))
+	if err != nil {
+		return false
+	}
+	h.after, h.before = after, before
+	return true
+}
+
+// validAt reports whether the last certificate seen is valid now and at t.
+func (h *Hook) validAt(t time.Time) bool {
+	if h.after.After(time.Now().Add(clockSkew)) {
+		return false
+	}
+	return h.before.IsZero() || h.before.After(t)
+}
+
+func (h *Hook) renewAt() time.Time {
+	if h.before.IsZero() {
+		return time.Time{}
+	}
+	lead := h.RenewBefore
+	if lead <= 0 {
+		lead = DefaultRenewBefore
+	}
+	if lifetime := h.before.Sub(h.after); lead > lifetime/2 {
+		lead = lifetime / 2
+	}
+	return h.before.Add(-lead)
+}
+
+// run executes the command and checks that it left a valid certificate.
+func (h *Hook) run(ctx context.Context) (time.Time, error) {
+	cmd := shellCommand(ctx, h.Command)
+	cmd.Env = append(os.Environ(),
+		HostEnv+"="+h.Host,
+		UserEnv+"="+h.User,
+		IdentityEnv+"="+h.Identity,
+		CertEnv+"="+CertPath(h.Identity),
+	)
+	// The CA may need a browser login or a PIN
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return time.Time{}, fmt.Errorf("cert_command for %s failed: %w", h.Host, err)
+	}
+
+	if !h.load() || !h.validAt(time.Now().Add(clockSkew)) {
+		return time.Time{}, fmt.Errorf("cert_command for %s left %w valid now in %s", h.Host, ErrNoCertificate, CertPath(h.Identity))
+	}
+	return h.before, nil
+}
+
+// Watch renews the certificate ahead of its expiry until ctx ends. renewed
+// is called after every renewal, with the error if it failed.
+func (h *Hook) Watch(ctx context.Context, renewed func(expires time.Time, err error)) {
+	for {
+		renewAt := h.RenewAt()
+		if renewAt.IsZero() {
+			// Never expires, or was never obtained; Ensure reports the latter
+			return
+		}
+
+		// Timers stop while the machine sleeps, so wake up regularly to
+		// notice a certificate that expired meanwhile
+		if wait := time.Until(renewAt); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(min(wait, 10*time.Minute)):
+				continue
+			}
+		}
+
+		expires, err := h.Ensure(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if renewed != nil {
+			renewed(expires, err)
+		}
+		// A CA that keeps handing out the same certificate is not asked
+		// again right away
+		if err != nil || !time.Now().Before(h.RenewAt()) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,148 @@
+package sshcert
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeCert writes a certificate for a new key, signed by a new CA and valid
+// from after until before, to path.
+func writeCert(t *testing.T, path string, after, before time.Time) {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"deploy"},
+		ValidAfter:      uint64(after.Unix()),
+		ValidBefore:     uint64(before.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	now := time.Now()
+	dir := t.TempDir()
+	fresh := filepath.Join(dir, "fresh-cert.pub")
+	writeCert(t, fresh, now.Add(-time.Minute), now.Add(8*time.Hour))
+
+	tests := []struct {
+		name    string
+		current func(path string)
+		command string
+		wantRun bool
+		wantErr bool
+	}{
+		{"missing certificate is obtained", func(string) {}, `cp ` + fresh + ` "$TUNNEL_DASH_CERT"`, true, false},
+		{"valid certificate is kept", func(path string) { writeCert(t, path, now.Add(-time.Hour), now.Add(7*time.Hour)) }, "exit 1", false, false},
+		{"certificate close to expiry is renewed", func(path string) { writeCert(t, path, now.Add(-8*time.Hour), now.Add(5*time.Minute)) }, `cp ` + fresh + ` "$TUNNEL_DASH_CERT"`, true, false},
+		{"command without a certificate fails", func(string) {}, "true", true, true},
+		{"failing command", func(string) {}, "exit 3", true, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := filepath.Join(dir, "id_"+string(rune('a'+i)))
+			tt.current(CertPath(identity))
+			marker := identity + ".ran"
+
+			h := &Hook{Host: "app", User: "deploy", Identity: identity, Command: "touch " + marker + " && " + tt.command}
+			expires, err := h.Ensure(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Ensure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(marker); (statErr == nil) != tt.wantRun {
+				t.Errorf("command ran = %v, want %v", statErr == nil, tt.wantRun)
+			}
+			if err == nil && !expires.After(now) {
+				t.Errorf("Ensure() expiry = %v, want a time after now", expires)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	now := time.Now()
+	dir := t.TempDir()
+	identity := filepath.Join(dir, "id_ed25519")
+	h := &Hook{Host: "app", Identity: identity, Command: "exit 1"}
+
+	// Close to expiry but still valid: a login failure has another cause
+	writeCert(t, CertPath(identity), now.Add(-8*time.Hour), now.Add(10*time.Minute))
+	if ran, err := h.Refresh(context.Background()); ran || err != nil {
+		t.Errorf("Refresh() = %v, %v for a valid certificate, want no run", ran, err)
+	}
+
+	writeCert(t, CertPath(identity), now.Add(-8*time.Hour), now.Add(-time.Minute))
+	if ran, err := h.Refresh(context.Background()); !ran || err == nil {
+		t.Errorf("Refresh() = %v, %v for an expired certificate, want a failed run", ran, err)
+	}
+}
+
+func TestRenewAt(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	identity := filepath.Join(t.TempDir(), "id_ed25519")
+
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{"renewed RenewBefore ahead", 8 * time.Hour, 8*time.Hour - DefaultRenewBefore},
+		{"short-lived certificates halfway", 10 * time.Minute, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		writeCert(t, CertPath(identity), now, now.Add(tt.lifetime))
+		h := &Hook{Identity: identity}
+		if !h.load() {
+			t.Fatalf("%s: certificate not loaded", tt.name)
+		}
+		if got := h.RenewAt(); !got.Equal(now.Add(tt.want)) {
+			t.Errorf("%s: RenewAt() = %v, want %v", tt.name, got, now.Add(tt.want))
+		}
+	}
+
+	if err := os.WriteFile(CertPath(identity), []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Validity(CertPath(identity)); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("Validity() error = %v, want ErrNoCertificate for a plain key", err)
+	}
+}
@@ -2,116 +2,630 @@ package sshconfig
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// Config is the effective configuration of one host, resolved the way
+// `ssh -G` does: the first value obtained for a keyword wins, except for
+// IdentityFile and LocalForward which accumulate.
 type Config struct {
-	Host         string
-	HostName     string
-	User         string
-	IdentityFile string
-	Port         int
+	Host     string
+	HostName string
+	User     string
+	// IdentityFile is the first of IdentityFiles
+	IdentityFile  string
+	IdentityFiles []string
+	Port          int
+	// ProxyJump is the comma-separated jump host list, empty for none
+	ProxyJump     string
+	LocalForwards []LocalForward
+	// CertCommand obtains a short-lived certificate for IdentityFile before
+	// connecting, see package sshcert. OpenSSH needs "IgnoreUnknown
+	// CertCommand" to accept the keyword.
+	CertCommand string
+	// Escalate runs discovery commands on this host through sudo or doas,
+	// see transport.ParseEscalation, and EscalateCommands names the programs
+	// it applies to. OpenSSH needs "IgnoreUnknown Escalate,EscalateCommands".
+	Escalate         string
+	EscalateCommands []string
 }
 
+// LocalForward is a LocalForward entry: connections to BindAddress:LocalPort
+// on this machine are forwarded to RemoteHost:RemotePort from the server.
+type LocalForward struct {
+	BindAddress string
+	LocalPort   int
+	RemoteHost  string
+	RemotePort  int
+}
+
+// maxIncludeDepth matches OpenSSH's limit on nested Include directives.
+const maxIncludeDepth = 16
+
+const systemConfigPath = "/etc/ssh/ssh_config"
+
+// ParseSSHConfig resolves host from $SSH_CONFIG if set, otherwise from
+// ~/.ssh/config followed by /etc/ssh/ssh_config. Missing default files are
+// skipped.
 func ParseSSHConfig(host string) (*Config, error) {
-	configPath := os.Getenv("SSH_CONFIG")
-	if configPath == "" {
-		usr, err := user.Current()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get current user: %w", err)
+	if configPath := os.Getenv("SSH_CONFIG"); configPath != "" {
+		if _, err := os.Stat(configPath); err != nil {
+			return nil, fmt.Errorf("failed to open SSH config: %w", err)
 		}
-		configPath = filepath.Join(usr.HomeDir, ".ssh", "config")
+		return ParseFiles(host, configPath)
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+	return ParseFiles(host, filepath.Join(usr.HomeDir, ".ssh", "config"), systemConfigPath)
+}
+
+// ParseFiles resolves host from the given config files in order. Files that
+// do not exist are skipped.
+func ParseFiles(host string, paths ...string) (*Config, error) {
+	r, err := newResolver(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		if err := r.readFile(path, true, 0); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+	}
+
+	return r.finish()
+}
+
+// resolver accumulates the effective configuration while files are read.
+type resolver struct {
+	host      string
+	localUser string
+	home      string
+	config    Config
+	// set records single-valued keywords that already have their value
+	set              map[string]bool
+	rawIdentities    []string
+	rawLocalForwards []string
+}
+
+func newResolver(host string) (*resolver, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
 	}
+	return &resolver{
+		host:      host,
+		localUser: usr.Username,
+		home:      usr.HomeDir,
+		config:    Config{Host: host},
+		set:       make(map[string]bool),
+	}, nil
+}
 
-	file, err := os.Open(configPath)
+// readFile applies the directives in path. active tells whether the
+// enclosing Host or Match block applies, as Include can appear inside one.
+func (r *resolver) readFile(path string, active bool, depth int) error {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open SSH config: %w", err)
+		if errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return fmt.Errorf("failed to open SSH config: %w", err)
 	}
 	defer func() {
 		_ = file.Close() //nolint:errcheck // Ignore error on close
 	}()
 
-	config := &Config{
-		Host: host,
-		Port: 22,
-	}
-
 	scanner := bufio.NewScanner(file)
-	inHostBlock := false
-
+	lineNum := 0
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if line == "" || strings.HasPrefix(line, "#") {
+		lineNum++
+		keyword, args, err := splitLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, lineNum, err)
+		}
+		if keyword == "" {
 			continue
 		}
 
-		if strings.HasPrefix(strings.ToLower(line), "host ") {
-			parts := strings.Fields(line)
-			if len(parts) < 2 {
+		switch keyword {
+		case "host":
+			active = r.matchHost(args)
+		case "match":
+			active, err = r.match(args)
+			if errors.Is(err, errUnsupportedCriterion) {
+				fmt.Fprintf(os.Stderr, "Warning: %s line %d: %v, skipping the block\n", path, lineNum, err)
+			} else if err != nil {
+				return fmt.Errorf("%s line %d: %w", path, lineNum, err)
+			}
+		case "include":
+			if !active {
 				continue
 			}
-
-			hostPatterns := parts[1:]
-			matches := false
-			for _, pattern := range hostPatterns {
-				if pattern == host || pattern == "*" {
-					matches = true
-					break
-				}
-				if strings.HasPrefix(pattern, "*") && strings.HasSuffix(host, strings.TrimPrefix(pattern, "*")) {
-					matches = true
-					break
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s line %d: too many nested includes", path, lineNum)
+			}
+			for _, pattern := range args {
+				if err := r.include(path, pattern, depth); err != nil {
+					return err
 				}
 			}
+		default:
+			if active {
+				r.apply(keyword, args)
+			}
+		}
+	}
 
-			if matches {
-				inHostBlock = true
-			} else {
-				inHostBlock = false
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading SSH config: %w", err)
+	}
+	return nil
+}
+
+// include reads the files matching pattern. Relative paths are resolved
+// against ~/.ssh, or /etc/ssh for the system config.
+func (r *resolver) include(from, pattern string, depth int) error {
+	pattern = r.expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		if from == systemConfigPath {
+			pattern = filepath.Join(filepath.Dir(systemConfigPath), pattern)
+		} else {
+			pattern = filepath.Join(r.home, ".ssh", pattern)
+		}
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("bad Include pattern %q: %w", pattern, err)
+	}
+	for _, match := range matches {
+		// Blocks in the included file start out active and do not leak back
+		if err := r.readFile(match, true, depth+1); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply records a directive unless an earlier one already set it.
+func (r *resolver) apply(keyword string, args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	switch keyword {
+	case "identityfile":
+		r.rawIdentities = append(r.rawIdentities, args[0])
+		return
+	case "localforward":
+		r.rawLocalForwards = append(r.rawLocalForwards, strings.Join(args, " "))
+		return
+	}
+
+	if r.set[keyword] {
+		return
+	}
+
+	switch keyword {
+	case "hostname":
+		r.config.HostName = args[0]
+	case "user":
+		r.config.User = args[0]
+	case "port":
+		port, err := strconv.Atoi(args[0])
+		if err != nil || port < 1 || port > 65535 {
+			return
+		}
+		r.config.Port = port
+	case "proxyjump":
+		r.config.ProxyJump = strings.Join(args, ",")
+	case "certcommand":
+		r.config.CertCommand = strings.Join(args, " ")
+	case "escalate":
+		r.config.Escalate = args[0]
+	case "escalatecommands":
+		r.config.EscalateCommands = strings.FieldsFunc(strings.Join(args, ","), func(c rune) bool { return c == ',' })
+	default:
+		return
+	}
+	r.set[keyword] = true
+}
+
+// matchHost evaluates the patterns of a Host line against the host name
+// given on the command line.
+func (r *resolver) matchHost(patterns []string) bool {
+	return matchList(strings.ToLower(r.host), patterns)
+}
+
+// errUnsupportedCriterion reports a Match criterion this resolver does not
+// know, such as one added by a newer OpenSSH. The block is skipped.
+var errUnsupportedCriterion = errors.New("unsupported Match criterion")
+
+// match evaluates the criteria of a Match line. Every criterion must match,
+// and like OpenSSH the rest of the line, exec commands included, is not
+// evaluated once one fails.
+func (r *resolver) match(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("Match needs criteria")
+	}
+
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var matched bool
+		switch criterion {
+		case "all":
+			matched = true
+		case "final":
+			// Hostnames are not canonicalized, so the single pass is final
+			matched = true
+		case "canonical":
+			// Only the pass after CanonicalizeHostname is canonical, and
+			// hostnames are never canonicalized here
+			matched = false
+		case "host", "originalhost", "user", "localuser", "exec", "tagged", "localnetwork":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("Match %s needs an argument", criterion)
 			}
+			i++
+			matched = r.matchCriterion(criterion, args[i])
+		default:
+			return false, fmt.Errorf("%w %q", errUnsupportedCriterion, criterion)
+		}
+
+		if matched == negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (r *resolver) matchCriterion(criterion, arg string) bool {
+	patterns := strings.Split(arg, ",")
+
+	switch criterion {
+	case "host":
+		return matchList(strings.ToLower(r.hostName()), patterns)
+	case "originalhost":
+		return matchList(strings.ToLower(r.host), patterns)
+	case "user":
+		return matchList(r.user(), patterns)
+	case "localuser":
+		return matchList(r.localUser, patterns)
+	case "exec":
+		cmd := exec.Command("/bin/sh", "-c", r.expandTokens(arg)) //nolint:gosec // Runs the user's own ssh_config
+		return cmd.Run() == nil
+	default:
+		// Tags and local networks are not tracked
+		return false
+	}
+}
+
+// hostName returns the target host name as resolved so far.
+func (r *resolver) hostName() string {
+	if r.config.HostName == "" {
+		return r.host
+	}
+	return strings.ReplaceAll(r.config.HostName, "%h", r.host)
+}
+
+// user returns the remote user as resolved so far.
+func (r *resolver) user() string {
+	if r.config.User == "" {
+		return r.localUser
+	}
+	return r.config.User
+}
+
+func (r *resolver) port() int {
+	if r.config.Port == 0 {
+		return 22
+	}
+	return r.config.Port
+}
+
+// finish applies defaults and expands paths once every file has been read.
+func (r *resolver) finish() (*Config, error) {
+	config := r.config
+	config.HostName = r.hostName()
+	config.Port = r.port()
+	if strings.EqualFold(config.ProxyJump, "none") {
+		config.ProxyJump = ""
+	}
+	if strings.EqualFold(config.CertCommand, "none") {
+		config.CertCommand = ""
+	}
+	config.CertCommand = r.expandTokens(config.CertCommand)
+	if strings.EqualFold(config.Escalate, "none") {
+		config.Escalate = ""
+	}
+
+	for _, identity := range r.rawIdentities {
+		config.IdentityFiles = append(config.IdentityFiles, r.expandHome(r.expandTokens(identity)))
+	}
+	if len(config.IdentityFiles) > 0 {
+		config.IdentityFile = config.IdentityFiles[0]
+	}
+
+	for _, spec := range r.rawLocalForwards {
+		forward, err := ParseLocalForward(spec)
+		if err != nil {
+			return nil, err
+		}
+		config.LocalForwards = append(config.LocalForwards, forward)
+	}
+
+	return &config, nil
+}
+
+// expandTokens replaces the ssh_config percent tokens.
+func (r *resolver) expandTokens(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 == len(s) {
+			b.WriteByte(s[i])
 			continue
 		}
+		i++
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'd':
+			b.WriteString(r.home)
+		case 'h':
+			b.WriteString(r.hostName())
+		case 'n':
+			b.WriteString(r.host)
+		case 'p':
+			b.WriteString(strconv.Itoa(r.port()))
+		case 'r':
+			b.WriteString(r.user())
+		case 'u':
+			b.WriteString(r.localUser)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
 
-		if inHostBlock {
-			parts := strings.Fields(line)
-			if len(parts) < 2 {
-				continue
+func (r *resolver) expandHome(path string) string {
+	if path == "~" {
+		return r.home
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(r.home, path[2:])
+	}
+	return path
+}
+
+// ParseLocalForward parses a LocalForward value such as "8080 localhost:80"
+// or "127.0.0.1:8080 [fd00::5]:80".
+func ParseLocalForward(spec string) (LocalForward, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return LocalForward{}, fmt.Errorf("invalid LocalForward %q", spec)
+	}
+
+	var forward LocalForward
+	var err error
+
+	local := fields[0]
+	if i := strings.LastIndex(local, ":"); i >= 0 {
+		forward.BindAddress = strings.Trim(local[:i], "[]")
+		local = local[i+1:]
+	}
+	if forward.LocalPort, err = strconv.Atoi(local); err != nil {
+		return LocalForward{}, fmt.Errorf("invalid LocalForward port in %q", spec)
+	}
+
+	i := strings.LastIndex(fields[1], ":")
+	if i < 0 {
+		return LocalForward{}, fmt.Errorf("invalid LocalForward target in %q", spec)
+	}
+	forward.RemoteHost = strings.Trim(fields[1][:i], "[]")
+	if forward.RemotePort, err = strconv.Atoi(fields[1][i+1:]); err != nil {
+		return LocalForward{}, fmt.Errorf("invalid LocalForward target port in %q", spec)
+	}
+	return forward, nil
+}
+
+// splitLine returns the lower-cased keyword of a config line and its
+// arguments. Arguments may be quoted and the keyword may be followed by "=".
+func splitLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	var args []string
+	for rest != "" {
+		if rest[0] == '#' {
+			break
+		}
+
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			arg, rest = rest[1:closing+1], rest[closing+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
 			}
+			arg, rest = rest[:end], rest[end:]
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
 
-			key := strings.ToLower(parts[0])
-			value := strings.Join(parts[1:], " ")
-
-			switch key {
-			case "hostname":
-				config.HostName = value
-			case "user":
-				config.User = value
-			case "identityfile":
-				if strings.HasPrefix(value, "~") {
-					usr, err := user.Current()
-					if err == nil {
-						value = filepath.Join(usr.HomeDir, strings.TrimPrefix(value, "~"))
-					}
+// matchList reports whether name matches a pattern list: any negated match
+// rejects it, otherwise one positive match accepts it.
+func matchList(name string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			if matchPattern(name, strings.ToLower(pattern[1:])) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(name, strings.ToLower(pattern)) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern matches name against a pattern where "*" matches any run of
+// characters and "?" exactly one.
+func matchPattern(name, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchPattern(name[i:], pattern) {
+					return true
 				}
-				config.IdentityFile = value
-			case "port":
-				_, _ = fmt.Sscanf(value, "%d", &config.Port) // Ignore parse error, defaults to 22
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
 			}
 		}
+		name, pattern = name[1:], pattern[1:]
 	}
+	return name == ""
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading SSH config: %w", err)
+// Jump is one hop of a ProxyJump list.
+type Jump struct {
+	User string
+	Host string
+	// Port is 0 when the hop does not name one
+	Port int
+}
+
+// ParseProxyJump splits a ProxyJump value such as
+// "admin@bastion:2222,inner" into its hops, in connection order.
+func ParseProxyJump(value string) ([]Jump, error) {
+	if value == "" || strings.EqualFold(value, "none") {
+		return nil, nil
+	}
+
+	var jumps []Jump
+	for _, hop := range strings.Split(value, ",") {
+		hop = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(hop), "ssh://"))
+		if hop == "" {
+			return nil, fmt.Errorf("empty jump host in %q", value)
+		}
+
+		var jump Jump
+		if at := strings.LastIndex(hop, "@"); at >= 0 {
+			jump.User, hop = hop[:at], hop[at+1:]
+		}
+
+		host := hop
+		if strings.HasPrefix(hop, "[") {
+			closing := strings.Index(hop, "]")
+			if closing < 0 {
+				return nil, fmt.Errorf("invalid jump host %q", hop)
+			}
+			host = hop[1:closing]
+			hop = hop[closing+1:]
+			if hop != "" && !strings.HasPrefix(hop, ":") {
+				return nil, fmt.Errorf("invalid jump host %q", hop)
+			}
+			hop = strings.TrimPrefix(hop, ":")
+		} else if i := strings.LastIndex(hop, ":"); i >= 0 && strings.Count(hop, ":") == 1 {
+			host, hop = hop[:i], hop[i+1:]
+		} else {
+			hop = ""
+		}
+		if hop != "" {
+			port, err := strconv.Atoi(hop)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid port in jump host %q", host+":"+hop)
+			}
+			jump.Port = port
+		}
+
+		jump.Host = host
+		jumps = append(jumps, jump)
 	}
+	return jumps, nil
+}
 
-	if config.HostName == "" {
-		config.HostName = host
+// ResolveJumps resolves every hop of a ProxyJump value through the SSH
+// config, so hops can be aliases with their own HostName, User and keys. A
+// user or port written in the hop overrides the config.
+func ResolveJumps(value string) ([]*Config, error) {
+	jumps, err := ParseProxyJump(value)
+	if err != nil {
+		return nil, err
 	}
 
-	return config, nil
+	var hops []*Config
+	for _, jump := range jumps {
+		hop, err := ParseSSHConfig(jump.Host)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", jump.Host, err)
+		}
+		if jump.User != "" {
+			hop.User = jump.User
+		}
+		if jump.Port != 0 {
+			hop.Port = jump.Port
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
 }
@@ -3,6 +3,7 @@ package sshconfig
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -152,3 +153,285 @@ func TestParseSSHConfig_NonExistentHost(t *testing.T) {
 		t.Errorf("Expected HostName to be 'non-existent', got '%s'", config.HostName)
 	}
 }
+
+func TestParseFiles(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(t.TempDir(), "exec-ran")
+
+	tests := []struct {
+		name   string
+		config string
+		host   string
+		check  func(t *testing.T, c *Config)
+	}{
+		{
+			name: "first value wins",
+			config: `Host web
+    Port 2200
+Host *
+    Port 22
+    User fallback
+`,
+			host: "web",
+			check: func(t *testing.T, c *Config) {
+				if c.Port != 2200 || c.User != "fallback" {
+					t.Errorf("got Port %d User %q, want 2200 and fallback", c.Port, c.User)
+				}
+			},
+		},
+		{
+			name: "wildcards and negation",
+			config: `Host !web-2 web-?
+    User webuser
+Host *
+    User other
+`,
+			host: "web-2",
+			check: func(t *testing.T, c *Config) {
+				if c.User != "other" {
+					t.Errorf("User = %q, want other", c.User)
+				}
+			},
+		},
+		{
+			name: "identity files accumulate",
+			config: `Host db
+    IdentityFile ~/.ssh/id_db
+    IdentityFile /keys/%h_%r
+    HostName db.internal
+    User admin
+`,
+			host: "db",
+			check: func(t *testing.T, c *Config) {
+				want := []string{filepath.Join(home, ".ssh", "id_db"), "/keys/db.internal_admin"}
+				if len(c.IdentityFiles) != 2 || c.IdentityFiles[0] != want[0] || c.IdentityFiles[1] != want[1] {
+					t.Errorf("IdentityFiles = %v, want %v", c.IdentityFiles, want)
+				}
+				if c.IdentityFile != want[0] {
+					t.Errorf("IdentityFile = %q, want %q", c.IdentityFile, want[0])
+				}
+			},
+		},
+		{
+			name: "match host uses the resolved host name",
+			config: `Host short
+    HostName %h.example.com
+Match host *.example.com user deploy
+    ProxyJump bastion
+Match host *.example.com
+    ProxyJump admin@gateway:2222
+`,
+			host: "short",
+			check: func(t *testing.T, c *Config) {
+				if c.HostName != "short.example.com" || c.ProxyJump != "admin@gateway:2222" {
+					t.Errorf("got HostName %q ProxyJump %q", c.HostName, c.ProxyJump)
+				}
+			},
+		},
+		{
+			name: "match exec and negation",
+			config: `Match !exec "false" originalhost app
+    User app
+`,
+			host: "app",
+			check: func(t *testing.T, c *Config) {
+				if c.User != "app" {
+					t.Errorf("User = %q, want app", c.User)
+				}
+			},
+		},
+		{
+			name: "match skips exec after a failed criterion",
+			config: `Match originalhost other exec "touch ` + marker + `"
+    User other
+`,
+			host: "app",
+			check: func(t *testing.T, c *Config) {
+				if _, err := os.Stat(marker); !os.IsNotExist(err) {
+					t.Errorf("exec ran after originalhost failed: %v", err)
+				}
+			},
+		},
+		{
+			name: "match canonical and unknown criteria do not apply",
+			config: `Match canonical
+    User canonical
+Match version OpenSSH_9*
+    User versioned
+Host app
+    User app
+`,
+			host: "app",
+			check: func(t *testing.T, c *Config) {
+				if c.User != "app" {
+					t.Errorf("User = %q, want app", c.User)
+				}
+			},
+		},
+		{
+			name: "local forwards",
+			config: `Host app
+    LocalForward 8080 localhost:80
+    LocalForward=127.0.0.1:5433 [fd00::5]:5432
+`,
+			host: "app",
+			check: func(t *testing.T, c *Config) {
+				want := []LocalForward{
+					{LocalPort: 8080, RemoteHost: "localhost", RemotePort: 80},
+					{BindAddress: "127.0.0.1", LocalPort: 5433, RemoteHost: "fd00::5", RemotePort: 5432},
+				}
+				if len(c.LocalForwards) != len(want) || c.LocalForwards[0] != want[0] || c.LocalForwards[1] != want[1] {
+					t.Errorf("LocalForwards = %+v, want %+v", c.LocalForwards, want)
+				}
+			},
+		},
+		{
+			name: "cert command with tokens",
+			config: `IgnoreUnknown CertCommand
+Host app
+    HostName app.internal
+    User deploy
+    CertCommand step ssh certificate --force %r %d/.ssh/id_app
+`,
+			host: "app",
+			check: func(t *testing.T, c *Config) {
+				want := "step ssh certificate --force deploy " + home + "/.ssh/id_app"
+				if c.CertCommand != want {
+					t.Errorf("CertCommand = %q, want %q", c.CertCommand, want)
+				}
+			},
+		},
+		{
+			name: "escalation per host",
+			config: `IgnoreUnknown Escalate,EscalateCommands
+Host db
+    Escalate sudo:postgres
+    EscalateCommands ss,docker
+Host *
+    Escalate doas
+`,
+			host: "db",
+			check: func(t *testing.T, c *Config) {
+				if c.Escalate != "sudo:postgres" {
+					t.Errorf("Escalate = %q, want sudo:postgres", c.Escalate)
+				}
+				if want := []string{"ss", "docker"}; !reflect.DeepEqual(c.EscalateCommands, want) {
+					t.Errorf("EscalateCommands = %v, want %v", c.EscalateCommands, want)
+				}
+			},
+		},
+		{
+			name: "escalation none",
+			config: `Host web
+    Escalate none
+Host *
+    Escalate doas
+`,
+			host: "web",
+			check: func(t *testing.T, c *Config) {
+				if c.Escalate != "" || c.EscalateCommands != nil {
+					t.Errorf("Escalate = %q for %v, want none", c.Escalate, c.EscalateCommands)
+				}
+			},
+		},
+		{
+			name: "proxy jump none",
+			config: `Host app
+    ProxyJump none
+Host *
+    ProxyJump bastion
+`,
+			host: "app",
+			check: func(t *testing.T, c *Config) {
+				if c.ProxyJump != "" {
+					t.Errorf("ProxyJump = %q, want none", c.ProxyJump)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := ParseFiles(tt.host, configPath)
+			if err != nil {
+				t.Fatalf("ParseFiles() error = %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestParseFiles_Include(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "hosts.d")
+	if err := os.Mkdir(included, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		filepath.Join(dir, "config"): `Host app
+    Include ` + included + `/*.conf
+    User outer
+Host other
+    Include ` + filepath.Join(dir, "never.conf") + `
+`,
+		filepath.Join(included, "a.conf"): `HostName app.internal
+Host *
+    User included
+`,
+		filepath.Join(dir, "never.conf"): `Port 1`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := ParseFiles("app", filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatalf("ParseFiles() error = %v", err)
+	}
+	if c.HostName != "app.internal" || c.User != "included" || c.Port != 22 {
+		t.Errorf("got HostName %q User %q Port %d", c.HostName, c.User, c.Port)
+	}
+}
+
+func TestParseProxyJump(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []Jump
+		wantErr bool
+	}{
+		{value: "bastion", want: []Jump{{Host: "bastion"}}},
+		{value: "admin@bastion:2222,inner", want: []Jump{{User: "admin", Host: "bastion", Port: 2222}, {Host: "inner"}}},
+		{value: "ssh://ops@[fd00::1]:22", want: []Jump{{User: "ops", Host: "fd00::1", Port: 22}}},
+		{value: "none"},
+		{value: "bastion:port", wantErr: true},
+		{value: "a,,b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseProxyJump(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProxyJump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseProxyJump() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("hop %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
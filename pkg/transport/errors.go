@@ -0,0 +1,234 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// ErrorKind classifies why a remote command or forward failed.
+type ErrorKind string
+
+const (
+	// KindUnknown is a failure that matched no other kind, usually the
+	// remote command itself reporting an error
+	KindUnknown ErrorKind = "unknown"
+	// KindAuth means the server rejected every key or password
+	KindAuth ErrorKind = "auth"
+	// KindUnreachable means no SSH connection could be made
+	KindUnreachable ErrorKind = "unreachable"
+	// KindHostKey means the server's key is unknown or has changed
+	KindHostKey ErrorKind = "host-key"
+	// KindNotFound means the remote command is not installed
+	KindNotFound ErrorKind = "not-found"
+	// KindPermission means the remote user may not run the command or
+	// read what it needs, such as the Docker socket
+	KindPermission ErrorKind = "permission"
+	// KindTimeout means connecting or the command took too long
+	KindTimeout ErrorKind = "timeout"
+	// KindForwarding means the server refused a port forward
+	KindForwarding ErrorKind = "forwarding-prohibited"
+	// KindEscalation means sudo or doas refused to run a command without
+	// a password
+	KindEscalation ErrorKind = "escalation-denied"
+)
+
+// Connection reports whether the kind is a failure to reach or log in to
+// the server, which any other command would hit as well.
+func (k ErrorKind) Connection() bool {
+	switch k {
+	case KindAuth, KindUnreachable, KindHostKey, KindTimeout:
+		return true
+	}
+	return false
+}
+
+// Error is a failed remote command or forward. Transports return it so
+// callers can tell a missing command from a connection that never worked.
+type Error struct {
+	Kind ErrorKind
+	// Command is the remote command, or the forward arguments
+	Command string
+	// ExitCode is the exit status, -1 when there was none
+	ExitCode int
+	// Stderr is the trimmed error output of ssh and the command
+	Stderr string
+	Err    error
+}
+
+// NewError classifies err from running command and wraps it.
+func NewError(command string, err error, exitCode int, stderr string) *Error {
+	stderr = strings.TrimSpace(stderr)
+	return &Error{
+		Kind:     Classify(err, exitCode, stderr),
+		Command:  command,
+		ExitCode: exitCode,
+		Stderr:   stderr,
+		Err:      err,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Stderr != "" {
+		return e.Stderr + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the first *Error in err's chain, or KindUnknown.
+func KindOf(err error) ErrorKind {
+	var remoteErr *Error
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Kind
+	}
+	return KindUnknown
+}
+
+// errorPatterns maps error output to kinds. Order matters: "Permission
+// denied (publickey)" is an auth failure and "doas: Operation not permitted"
+// a refused escalation, not permission problems, and a timed out connection
+// is a timeout before it is unreachable.
+var errorPatterns = []struct {
+	kind     ErrorKind
+	patterns []string
+}{
+	{KindHostKey, []string{
+		"host key verification failed",
+		"remote host identification has changed",
+		"host key does not match",
+		"host key is not in known_hosts",
+		"host key has changed",
+		"no matching host key type",
+	}},
+	{KindEscalation, []string{
+		"sudo: a password is required",
+		"sudo: a terminal is required",
+		"is not in the sudoers file",
+		"is not allowed to execute",
+		"may not run sudo",
+		"doas: authorization required",
+		"doas: operation not permitted",
+		"doas: not permitted",
+	}},
+	{KindAuth, []string{
+		"permission denied (publickey",
+		"permission denied (password",
+		"permission denied (keyboard-interactive",
+		"too many authentication failures",
+		"unable to authenticate",
+		"no supported authentication methods",
+		"authentication failed",
+		"no ssh keys found",
+		"no usable ssh keys",
+	}},
+	{KindForwarding, []string{
+		"administratively prohibited",
+		"port forwarding is disabled",
+		"remote port forwarding failed",
+		"forwarding disabled",
+		"tcp forwarding disabled",
+		"request denied by peer",
+	}},
+	{KindTimeout, []string{
+		"timed out",
+		"deadline exceeded",
+	}},
+	{KindUnreachable, []string{
+		"could not resolve hostname",
+		"name or service not known",
+		"no such host",
+		"connection refused",
+		"no route to host",
+		"network is unreachable",
+		"connection closed by",
+		"connection reset",
+		"kex_exchange_identification",
+		"failed to connect to",
+		"ssh connection lost",
+		"failed to reach proxy",
+		"proxy refused the connection",
+	}},
+	{KindNotFound, []string{
+		"command not found",
+		": not found",
+		"executable file not found",
+	}},
+	{KindPermission, []string{
+		"permission denied",
+		"operation not permitted",
+		"must be root",
+		"are you root",
+	}},
+}
+
+// Classify derives the kind of a failure from the error, the exit status
+// (-1 for none) and the error output of ssh and the remote command.
+func Classify(err error, exitCode int, stderr string) ErrorKind {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return KindTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindTimeout
+	}
+
+	text := strings.ToLower(stderr)
+	if err != nil {
+		text += "\n" + strings.ToLower(err.Error())
+	}
+	for _, group := range errorPatterns {
+		for _, pattern := range group.patterns {
+			if strings.Contains(text, pattern) {
+				return group.kind
+			}
+		}
+	}
+
+	switch exitCode {
+	case 127:
+		return KindNotFound
+	case 126:
+		return KindPermission
+	case 255:
+		// ssh exits with 255 when the connection itself fails
+		return KindUnreachable
+	}
+	return KindUnknown
+}
+
+// OnError returns a Transport that passes the errors of tr's commands and
+// forwards to fn before returning them, e.g. to renew credentials after an
+// authentication failure. A nil fn returns tr.
+func OnError(tr Transport, fn func(error)) Transport {
+	if fn == nil {
+		return tr
+	}
+	return &observed{Transport: tr, fn: fn}
+}
+
+type observed struct {
+	Transport
+	fn func(error)
+}
+
+func (t *observed) Run(ctx context.Context, command string) ([]byte, error) {
+	output, err := t.Transport.Run(ctx, command)
+	if err != nil {
+		t.fn(err)
+	}
+	return output, err
+}
+
+func (t *observed) Forward(ctx context.Context, f Forward) (Handle, error) {
+	h, err := t.Transport.Forward(ctx, f)
+	if err != nil {
+		t.fn(err)
+	}
+	return h, err
+}
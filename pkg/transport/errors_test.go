@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	exit := errors.New("exit status")
+
+	tests := []struct {
+		name     string
+		err      error
+		exitCode int
+		stderr   string
+		want     ErrorKind
+	}{
+		{"publickey rejected", exit, 255, "admin@example.com: Permission denied (publickey,password).", KindAuth},
+		{"native auth", errors.New("ssh handshake with example.com:22 failed: ssh: unable to authenticate, attempted methods [none publickey]"), -1, "", KindAuth},
+		{"changed host key", exit, 255, "@@@ WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED! @@@\nHost key verification failed.", KindHostKey},
+		{"unknown host", exit, 255, "ssh: Could not resolve hostname nope: Name or service not known", KindUnreachable},
+		{"refused", exit, 255, "ssh: connect to host example.com port 22: Connection refused", KindUnreachable},
+		{"proxy refused", errors.New("proxy http://proxy.corp:3128: proxy refused the connection to example.com:22: 407 Proxy Authentication Required"), -1, "", KindUnreachable},
+		{"connect timeout", exit, 255, "ssh: connect to host example.com port 22: Connection timed out", KindTimeout},
+		{"context deadline", fmt.Errorf("run: %w", context.DeadlineExceeded), -1, "", KindTimeout},
+		{"missing command", exit, 127, "bash: line 1: ss: command not found", KindNotFound},
+		{"missing command by exit code", exit, 127, "", KindNotFound},
+		{"docker socket", exit, 1, "permission denied while trying to connect to the Docker daemon socket at unix:///var/run/docker.sock", KindPermission},
+		{"sudo needs a password", exit, 1, "sudo: a password is required", KindEscalation},
+		{"not in sudoers", exit, 1, "deploy is not in the sudoers file.  This incident will be reported.", KindEscalation},
+		{"doas refused", exit, 1, "doas: Operation not permitted", KindEscalation},
+		{"forward refused", exit, 255, "channel 2: open failed: administratively prohibited: open failed", KindForwarding},
+		{"generic ssh failure", exit, 255, "", KindUnreachable},
+		{"command error", exit, 1, "Error: No such container: web", KindUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err, tt.exitCode, tt.stderr); got != tt.want {
+				t.Errorf("Classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorWrapping(t *testing.T) {
+	cause := errors.New("exit status 127")
+	err := fmt.Errorf("docker ps failed: %w", NewError("docker ps", cause, 127, "  sh: docker: not found\n"))
+
+	if KindOf(err) != KindNotFound {
+		t.Errorf("KindOf() = %s, want %s", KindOf(err), KindNotFound)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected the cause to stay in the chain")
+	}
+	if want := "docker ps failed: sh: docker: not found: exit status 127"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if KindOf(errors.New("plain")) != KindUnknown {
+		t.Error("expected KindUnknown for errors without a kind")
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var seen []ErrorKind
+	tr := OnError(failTransport{}, func(err error) {
+		seen = append(seen, KindOf(err))
+	})
+
+	if _, err := tr.Run(context.Background(), "ss -tlnp"); KindOf(err) != KindAuth {
+		t.Fatalf("Run() error kind = %s, want %s", KindOf(err), KindAuth)
+	}
+	if _, err := tr.Forward(context.Background(), Forward{Kind: ForwardLocal}); err == nil {
+		t.Fatal("expected the forward to fail")
+	}
+	if len(seen) != 2 || seen[0] != KindAuth {
+		t.Errorf("observed %v, want the Run and Forward errors", seen)
+	}
+}
+
+// failTransport fails every command and forward with an auth error.
+type failTransport struct{}
+
+func (failTransport) Run(_ context.Context, command string) ([]byte, error) {
+	return nil, NewError(command, errors.New("exit status 255"), 255, "Permission denied (publickey).")
+}
+
+func (failTransport) Forward(context.Context, Forward) (Handle, error) {
+	return nil, NewError("-L 8080:localhost:80", errors.New("exit status 255"), 255, "Permission denied (publickey).")
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Privilege escalation methods for Escalation.Method.
+const (
+	// EscalateSudo runs commands with "sudo -n", which fails instead of
+	// asking for a password
+	EscalateSudo = "sudo"
+	// EscalateDoas runs commands with "doas -n"
+	EscalateDoas = "doas"
+)
+
+// DefaultEscalateCommands are the programs escalated when none are listed:
+// ss and netstat only name other users' processes to root, and docker needs
+// the docker group. The /proc scan strategy runs cat and ls, which are left
+// out because a sudoers rule for them can read any file; list them to name
+// other users' processes on hosts without ss and netstat.
+var DefaultEscalateCommands = []string{"ss", "netstat", "docker"}
+
+// Escalation says how to run selected remote commands with more privileges.
+type Escalation struct {
+	// Method is EscalateSudo or EscalateDoas
+	Method string
+	// User is the user to run as, root when empty
+	User string
+	// Commands are the programs to escalate, such as "docker"
+	Commands []string
+}
+
+// ParseEscalation parses "sudo", "doas", or either followed by ":user" to
+// run as that user. "" and "none" disable escalation and return nil.
+func ParseEscalation(spec string, commands []string) (*Escalation, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return nil, nil
+	}
+
+	method, user, _ := strings.Cut(spec, ":")
+	switch method {
+	case EscalateSudo, EscalateDoas:
+	default:
+		return nil, fmt.Errorf("unknown escalation method %q (use %s, %s, %s:user or %s:user)", method, EscalateSudo, EscalateDoas, EscalateSudo, EscalateDoas)
+	}
+	if strings.ContainsAny(user, " \t'\"\\;|&$`") {
+		return nil, fmt.Errorf("invalid user name %q", user)
+	}
+
+	if len(commands) == 0 {
+		commands = DefaultEscalateCommands
+	}
+	return &Escalation{Method: method, User: user, Commands: commands}, nil
+}
+
+// Applies reports whether command runs a program that is escalated.
+func (e *Escalation) Applies(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+	for _, name := range e.Commands {
+		if fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap returns command with its program run through the escalation method.
+// Only the program is escalated, not a shell, so sudoers and doas.conf rules
+// can allow exactly the listed commands; later commands of a pipeline keep
+// running as the login user.
+func (e *Escalation) Wrap(command string) string {
+	prefix := e.Method + " -n "
+	if e.User != "" {
+		prefix += "-u " + e.User + " "
+	}
+	return prefix + strings.TrimLeft(command, " \t")
+}
+
+func (e *Escalation) String() string {
+	if e.User != "" {
+		return e.Method + ":" + e.User
+	}
+	return e.Method
+}
+
+// Escalate returns a Transport that runs the commands e applies to through
+// e and passes everything else to tr unchanged. A nil e returns tr.
+func Escalate(tr Transport, e *Escalation) Transport {
+	if e == nil {
+		return tr
+	}
+	return &escalated{Transport: tr, escalation: e}
+}
+
+type escalated struct {
+	Transport
+	escalation *Escalation
+}
+
+func (t *escalated) Run(ctx context.Context, command string) ([]byte, error) {
+	if t.escalation.Applies(command) {
+		command = t.escalation.Wrap(command)
+	}
+	return t.Transport.Run(ctx, command)
+}
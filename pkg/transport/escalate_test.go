@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEscalation(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		command string
+		want    string
+	}{
+		{"sudo", "sudo", "ss -tlnp", "sudo -n ss -tlnp"},
+		{"sudo as user", "sudo:postgres", "docker ps", "sudo -n -u postgres docker ps"},
+		{"doas pipeline", "doas", "docker exec npm find /data | head -1", "doas -n docker exec npm find /data | head -1"},
+		{"not listed", "sudo", "sqlite3 /data/database.sqlite", "sqlite3 /data/database.sqlite"},
+		{"disabled", "none", "ss -tlnp", "ss -tlnp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseEscalation(tt.spec, nil)
+			if err != nil {
+				t.Fatalf("ParseEscalation(%q) error = %v", tt.spec, err)
+			}
+			rec := &recordTransport{}
+			if _, err := Escalate(rec, e).Run(context.Background(), tt.command); err != nil {
+				t.Fatal(err)
+			}
+			if rec.command != tt.want {
+				t.Errorf("ran %q, want %q", rec.command, tt.want)
+			}
+		})
+	}
+
+	for _, spec := range []string{"su", "sudo:bad user", "sudo:x;reboot"} {
+		if _, err := ParseEscalation(spec, nil); err == nil {
+			t.Errorf("ParseEscalation(%q) expected an error", spec)
+		}
+	}
+}
+
+// recordTransport remembers the last command it was asked to run.
+type recordTransport struct {
+	command string
+}
+
+func (r *recordTransport) Run(_ context.Context, command string) ([]byte, error) {
+	r.command = command
+	return nil, nil
+}
+
+func (r *recordTransport) Forward(context.Context, Forward) (Handle, error) {
+	return nil, errors.New("not implemented")
+}
@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ListenHandle is a forward served in this process: it accepts connections
+// on a listener and relays each one to a connection it dials. Transports
+// that do their own forwarding, such as the native SSH client and the local
+// transport, return one from Forward.
+type ListenHandle struct {
+	ln   net.Listener
+	once sync.Once
+	done chan struct{}
+	err  error
+	port int
+}
+
+// Serve starts relaying the connections accepted on ln to the connections
+// returned by dial, which gets the accepted connection, until Finish is
+// called.
+func Serve(ln net.Listener, dial func(net.Conn) (net.Conn, error)) *ListenHandle {
+	h := &ListenHandle{ln: ln, done: make(chan struct{})}
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		h.port = addr.Port
+	}
+	go h.serve(dial)
+	return h
+}
+
+// Port is the TCP port the forward listens on, 0 for a Unix socket.
+func (h *ListenHandle) Port() int {
+	return h.port
+}
+
+func (h *ListenHandle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Finish stops the forward, making Wait return err. Only the first call
+// has an effect; connections already relayed stay open.
+func (h *ListenHandle) Finish(err error) {
+	h.once.Do(func() {
+		h.err = err
+		close(h.done)
+		_ = h.ln.Close() //nolint:errcheck // Ends serve
+	})
+}
+
+func (h *ListenHandle) serve(dial func(net.Conn) (net.Conn, error)) {
+	for {
+		client, err := h.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() {
+				_ = client.Close() //nolint:errcheck // Ignore close error
+			}()
+
+			upstream, err := dial(client)
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = upstream.Close() //nolint:errcheck // Ignore close error
+			}()
+
+			Relay(client, upstream, nil, nil)
+		}()
+	}
+}
+
+// closeWriter is implemented by TCP, Unix socket and SSH channel connections.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Relay copies data between a and b in both directions until both sides
+// are done. Each side's write half is closed when the other one ends, so
+// protocols that half-close keep working. The bytes sent from a to b are
+// added to sent and those from b to a to received, either of which may be
+// nil.
+func Relay(a, b net.Conn, sent, received *atomic.Int64) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	pipe := func(dst, src net.Conn, counter *atomic.Int64) {
+		defer wg.Done()
+		var w io.Writer = dst
+		if counter != nil {
+			w = &countingWriter{w: dst, n: counter}
+		}
+		_, _ = io.Copy(w, src) //nolint:errcheck // Connection errors end the relay
+		if cw, ok := dst.(closeWriter); ok {
+			_ = cw.CloseWrite() //nolint:errcheck // Peer may already be gone
+		}
+	}
+
+	go pipe(b, a, sent)
+	go pipe(a, b, received)
+	wg.Wait()
+}
+
+// countingWriter adds the number of bytes written to n as they are written,
+// so counters move while long-lived connections are still open.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
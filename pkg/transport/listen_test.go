@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	// An upstream that answers with what it read once the client half-closes
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = upstream.Close() //nolint:errcheck // Test cleanup
+	}()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			request, _ := io.ReadAll(conn)          //nolint:errcheck // Test server
+			_, _ = conn.Write(append(request, '!')) //nolint:errcheck // Test server
+			_ = conn.Close()                        //nolint:errcheck // Test server
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Serve(ln, func(net.Conn) (net.Conn, error) {
+		return net.Dial("tcp", upstream.Addr().String())
+	})
+	if want := ln.Addr().(*net.TCPAddr).Port; h.Port() != want {
+		t.Errorf("Port() = %d, want %d", h.Port(), want)
+	}
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close() //nolint:errcheck // Test cleanup
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck // Test timeout
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "ping!" {
+		t.Errorf("reply = %q, want %q", reply, "ping!")
+	}
+
+	stopped := errors.New("stopped")
+	h.Finish(stopped)
+	h.Finish(errors.New("ignored"))
+	if err := h.Wait(); err != stopped {
+		t.Errorf("Wait() = %v, want %v", err, stopped)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("listener still accepts connections after Finish")
+	}
+}
@@ -0,0 +1,106 @@
+// Package transport defines how the dashboard reaches the remote host: it runs
+// commands there and opens port forwards. Every package that talks to the
+// server accepts a Transport, so the OpenSSH client can be swapped for other
+// backends or fakes.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Transport runs commands on and opens forwards to a single remote host.
+type Transport interface {
+	// Run executes command on the remote host and returns its standard output.
+	// A non-zero exit status is returned as an error that includes stderr.
+	Run(ctx context.Context, command string) ([]byte, error)
+
+	// Forward opens f and keeps it open until ctx is canceled or the
+	// connection fails. The returned Handle reports how it ended.
+	Forward(ctx context.Context, f Forward) (Handle, error)
+}
+
+// Handle is a running forward or connection.
+type Handle interface {
+	// Wait blocks until the forward has stopped and returns the reason.
+	Wait() error
+}
+
+// Multiplexer is implemented by transports that can carry every forward and
+// command over one shared connection that is opened separately, such as an
+// OpenSSH ControlMaster.
+type Multiplexer interface {
+	// SetControlPath sets where the shared connection's control socket lives.
+	SetControlPath(path string)
+	// Master opens the shared connection.
+	Master(ctx context.Context) (Handle, error)
+	// Control sends a request to the shared connection: "check" or "exit",
+	// or "forward"/"cancel" together with f.
+	Control(ctx context.Context, operation string, f *Forward) error
+}
+
+// Dialer is implemented by transports that can open a raw connection from the
+// server to another address, like ssh -W. Hosts behind the server, such as
+// the next jump host, are reached this way.
+type Dialer interface {
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// PortBinder is implemented by transports that listen on the local end of
+// forwards in this process, such as the native SSH client. They can listen on
+// a port the kernel picks, which no other program can take between choosing
+// the port and binding it.
+type PortBinder interface {
+	// ForwardAnyPort is Forward for a local forward that listens on a free
+	// loopback port instead of f.LocalPort. It returns the port.
+	ForwardAnyPort(ctx context.Context, f Forward) (Handle, int, error)
+}
+
+// ForwardKind is the direction and type of a forward.
+type ForwardKind string
+
+const (
+	// ForwardLocal listens locally and connects to RemoteHost:RemotePort or
+	// RemoteSocket as seen from the server.
+	ForwardLocal ForwardKind = "local"
+	// ForwardDynamic is a local SOCKS proxy on LocalPort.
+	ForwardDynamic ForwardKind = "dynamic"
+	// ForwardReverse listens on the server at BindAddress:RemotePort and
+	// connects to LocalPort on this machine.
+	ForwardReverse ForwardKind = "reverse"
+)
+
+// Forward describes a single port or socket forward.
+type Forward struct {
+	Kind ForwardKind
+
+	// LocalPort or LocalSocket is the end on this machine
+	LocalPort   int
+	LocalSocket string
+
+	// RemoteHost:RemotePort or RemoteSocket is the end on the server side
+	RemoteHost   string
+	RemotePort   int
+	RemoteSocket string
+
+	// BindAddress is the server-side listen address of a reverse forward
+	BindAddress string
+}
+
+// DefaultTargetHost is the forward destination when none is given: the
+// server itself.
+const DefaultTargetHost = "localhost"
+
+// TargetHost returns host, or DefaultTargetHost when it is empty.
+func TargetHost(host string) string {
+	if host == "" {
+		return DefaultTargetHost
+	}
+	return host
+}
+
+// LocalAddr is the loopback address of a local forward's port.
+func LocalAddr(port int) string {
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
@@ -0,0 +1,37 @@
+// Package transporttest provides a fake transport.Transport for the tests of
+// packages that run remote commands.
+package transporttest
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// Fake answers commands from a fixed table. A key matches the whole command
+// or its first words, so "docker ps" answers "docker ps --format ...", and
+// the longest match wins. Other commands fail.
+type Fake map[string]string
+
+func (f Fake) Run(_ context.Context, command string) ([]byte, error) {
+	if output, ok := f[command]; ok {
+		return []byte(output), nil
+	}
+
+	match, found := "", false
+	for key := range f {
+		if strings.HasPrefix(command, key+" ") && len(key) >= len(match) {
+			match, found = key, true
+		}
+	}
+	if !found {
+		return nil, errors.New("command not found")
+	}
+	return []byte(f[match]), nil
+}
+
+func (f Fake) Forward(context.Context, transport.Forward) (transport.Handle, error) {
+	return nil, errors.New("not implemented")
+}
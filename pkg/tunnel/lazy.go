@@ -0,0 +1,168 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// StateIdle marks a lazy tunnel that is listening locally but has no SSH
+// forward open.
+const StateIdle State = "idle"
+
+// backendReadyTimeout bounds how long a client waits for a lazy forward.
+const backendReadyTimeout = 10 * time.Second
+
+// SetLazy makes CreateTunnel bind local ports itself and only open the SSH
+// forward when the first client connects. Forwards that have had no
+// connections for idleTimeout are torn down again.
+func (m *Manager) SetLazy(idleTimeout time.Duration) {
+	m.lazy = true
+	m.lazyIdle = idleTimeout
+}
+
+// listenLazy binds the tunnel's local port and starts serving it. The SSH
+// forward is opened by the first connection.
+func (m *Manager) listenLazy(t *Tunnel) error {
+	t.lazy = true
+	t.state = StateIdle
+	t.lastActive = time.Now()
+
+	if err := m.listenLocal(t); err != nil {
+		return err
+	}
+
+	go m.reapIdle(t)
+	return nil
+}
+
+// dialBackend connects to the tunnel's SSH forward, opening it first if it
+// is not running or has gone away.
+func (m *Manager) dialBackend(t *Tunnel) (net.Conn, error) {
+	t.backendMu.Lock()
+	defer t.backendMu.Unlock()
+
+	if t.backend != nil {
+		conn, err := net.DialTimeout("tcp", transport.LocalAddr(t.backend.LocalPort), time.Second)
+		if err == nil {
+			return conn, nil
+		}
+		// Forward died underneath us, reopen it
+		m.dropBackend(t, true)
+	}
+
+	return m.openBackend(t)
+}
+
+// openBackend starts an SSH forward on a private loopback port and waits
+// until it accepts connections. Caller must hold t.backendMu.
+func (m *Manager) openBackend(t *Tunnel) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	backend := &Tunnel{
+		RemotePort: t.RemotePort,
+		TargetHost: t.TargetHost,
+		ctx:        ctx,
+		cancel:     cancel,
+		state:      StateUp,
+	}
+
+	var done <-chan error
+	var err error
+	if m.bindsForwards() {
+		done, backend.LocalPort, err = m.startAnyPort(backend)
+	} else if backend.LocalPort, err = freeLocalPort(); err == nil {
+		if m.master != nil {
+			err = m.addForward(backend)
+		} else {
+			done, err = m.startProcess(backend)
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open forward: %w", err)
+	}
+	port := backend.LocalPort
+
+	deadline := time.Now().Add(backendReadyTimeout)
+	for {
+		conn, dialErr := net.DialTimeout("tcp", transport.LocalAddr(port), time.Second)
+		if dialErr == nil {
+			t.backend = backend
+			m.setState(t, StateUp, nil)
+			return conn, nil
+		}
+
+		select {
+		case err = <-done:
+			cancel()
+			if err == nil {
+				err = fmt.Errorf("tunnel process exited unexpectedly with code 0")
+			}
+			return nil, fmt.Errorf("forward failed: %w", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			m.cancelBackend(backend)
+			return nil, fmt.Errorf("forward not ready after %v", backendReadyTimeout)
+		}
+	}
+}
+
+// dropBackend tears down the tunnel's SSH forward. Caller must hold t.backendMu.
+func (m *Manager) dropBackend(t *Tunnel, notify bool) {
+	if t.backend == nil {
+		return
+	}
+	m.cancelBackend(t.backend)
+	t.backend = nil
+
+	if notify {
+		m.setState(t, StateIdle, nil)
+	}
+}
+
+func (m *Manager) cancelBackend(b *Tunnel) {
+	if m.master != nil {
+		m.cancelForward(b)
+	}
+	b.cancel()
+}
+
+// reapIdle closes the SSH forward once the tunnel has had no connections for
+// the configured idle timeout.
+func (m *Manager) reapIdle(t *Tunnel) {
+	interval := m.lazyIdle / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			t.backendMu.Lock()
+			m.dropBackend(t, false)
+			t.backendMu.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		if m.lazyIdle <= 0 {
+			continue
+		}
+
+		t.backendMu.Lock()
+		t.mu.Lock()
+		idle := t.active == 0 && time.Since(t.lastActive) >= m.lazyIdle
+		t.mu.Unlock()
+		if idle {
+			m.dropBackend(t, true)
+		}
+		t.backendMu.Unlock()
+	}
+}
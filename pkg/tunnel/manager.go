@@ -3,163 +3,453 @@ package tunnel
 import (
 	"context"
 	"fmt"
-	"os/exec"
+	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/ssh"
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
 )
 
 type Manager struct {
-	server       string
-	user         string
-	keyPath      string
-	useHostAlias bool
-	hostAlias    string
-	insecure     bool
-	tunnels      map[int]*Tunnel
-	tunnelsMu    sync.RWMutex
-	localPorts   map[int]int
-	portsMu      sync.RWMutex
-	nextPort     int
-	startPort    int
+	// transport opens the forwards, such as a client shared with the scanner
+	// and detectors
+	transport  transport.Transport
+	tunnels    map[Target]*Tunnel
+	tunnelsMu  sync.RWMutex
+	localPorts map[Target]int
+	portsMu    sync.RWMutex
+	nextPort   int
+	startPort  int
+	policy     ReconnectPolicy
+	master     *master
+	lazy       bool
+	lazyIdle   time.Duration
+	// ports remembers local ports across runs, nil when disabled
+	ports       *PortStore
+	ttl         time.Duration
+	idleTimeout time.Duration
+	// socks is the dynamic SOCKS5 forward, if one was started
+	socks *Tunnel
+	// reverse holds remote (-R) forwards keyed by the port they bind on the server
+	reverse map[int]*Tunnel
+	// sockets holds Unix socket forwards keyed by the remote socket path
+	sockets map[string]*Tunnel
+	// onStateChange is called whenever a supervised tunnel changes state
+	onStateChange func(Status)
 }
 
+// Target identifies a forward destination as seen from the SSH server.
+type Target struct {
+	Host string
+	Port int
+}
+
+// defaultTarget returns the target for a port on the SSH server itself.
+func defaultTarget(port int) Target {
+	return Target{Host: transport.DefaultTargetHost, Port: port}
+}
+
+// Kind is the type of SSH forward a tunnel uses.
+type Kind string
+
+const (
+	KindLocal   Kind = "local"
+	KindDynamic Kind = "dynamic"
+	KindReverse Kind = "reverse"
+	KindSocket  Kind = "socket"
+)
+
 type Tunnel struct {
 	RemotePort int
 	LocalPort  int
-	Cmd        *exec.Cmd
-	ctx        context.Context
-	cancel     context.CancelFunc
-	errChan    chan error
+	Kind       Kind
+	// TargetHost is the host the SSH server connects to, "localhost" by default
+	TargetHost string
+	// BindAddress is the server-side listen address of a reverse tunnel
+	BindAddress string
+	// RemoteSocket and LocalSocket are set for Unix socket forwards. An empty
+	// LocalSocket means the socket is forwarded to LocalPort instead.
+	RemoteSocket string
+	LocalSocket  string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mu           sync.Mutex
+	state        State
+	restarts     int
+	lastErr      error
+	// expiresAt is when the tunnel is closed for good, zero for no TTL
+	expiresAt time.Time
+
+	// The manager owns the local listener and relays connections to the SSH
+	// forward on forwardPort, counting traffic on the way
+	listener    net.Listener
+	relayed     bool
+	forwardPort int
+	active      int
+	totalConns  int64
+	lastActive  time.Time
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+
+	// Lazy mode: the SSH forward (backend) is opened on demand
+	lazy      bool
+	backend   *Tunnel
+	backendMu sync.Mutex
 }
 
-func NewManager(server, user, keyPath string, startPort int) *Manager {
+// NewManagerWithTransport creates a manager that opens every forward through
+// tr, such as a client shared with the scanner and detectors.
+func NewManagerWithTransport(tr transport.Transport, startPort int) *Manager {
 	return &Manager{
-		server:       server,
-		user:         user,
-		keyPath:      keyPath,
-		useHostAlias: false,
-		tunnels:      make(map[int]*Tunnel),
-		localPorts:   make(map[int]int),
-		nextPort:     startPort,
-		startPort:    startPort,
+		transport:  tr,
+		tunnels:    make(map[Target]*Tunnel),
+		localPorts: make(map[Target]int),
+		reverse:    make(map[int]*Tunnel),
+		sockets:    make(map[string]*Tunnel),
+		nextPort:   startPort,
+		startPort:  startPort,
+		policy:     DefaultReconnectPolicy(),
 	}
 }
 
+// NewManager creates a manager that forwards through the ssh binary as
+// user@server with keyPath.
+//
+// Deprecated: Use NewManagerWithTransport, which can share one transport
+// with the scanner and detectors.
+func NewManager(server, user, keyPath string, startPort int) *Manager {
+	return NewManagerWithTransport(ssh.NewClient(ssh.Config{Server: server, User: user, KeyPath: keyPath}), startPort)
+}
+
+// NewManagerWithHost creates a manager that forwards through the ssh binary
+// to an ssh_config host alias.
+//
+// Deprecated: Use NewManagerWithTransport.
 func NewManagerWithHost(hostAlias string, startPort int) *Manager {
-	return &Manager{
-		useHostAlias: true,
-		hostAlias:    hostAlias,
-		tunnels:      make(map[int]*Tunnel),
-		localPorts:   make(map[int]int),
-		nextPort:     startPort,
-		startPort:    startPort,
-	}
+	return NewManagerWithTransport(ssh.NewClient(ssh.Config{UseHostAlias: true, HostAlias: hostAlias}), startPort)
 }
 
+// SetInsecure turns off strict host key checking for a manager created by
+// NewManager or NewManagerWithHost, before any tunnel is opened. Other
+// transports are configured when they are built.
+//
+// Deprecated: Set ssh.Config.Insecure on the transport instead.
 func (m *Manager) SetInsecure(insecure bool) {
-	m.insecure = insecure
+	if client, ok := m.transport.(*ssh.Client); ok {
+		client.SetInsecure(insecure)
+	}
+}
+
+// SetPortStore makes the manager reuse and remember local ports across runs.
+func (m *Manager) SetPortStore(store *PortStore) {
+	m.ports = store
+}
+
+// SetReconnectPolicy configures how dead tunnels are restarted.
+func (m *Manager) SetReconnectPolicy(policy ReconnectPolicy) {
+	m.policy = policy
 }
 
+// SetStateChangeFunc registers a callback invoked on every tunnel state change.
+func (m *Manager) SetStateChangeFunc(fn func(Status)) {
+	m.onStateChange = fn
+}
+
+// CreateTunnel forwards a local port to remotePort on the SSH server itself.
 func (m *Manager) CreateTunnel(remotePort int) (int, error) {
+	return m.CreateTunnelTo(transport.DefaultTargetHost, remotePort)
+}
+
+// CreateTunnelTo forwards a local port to targetHost:remotePort as reached
+// from the SSH server, such as a container IP on a Docker network.
+func (m *Manager) CreateTunnelTo(targetHost string, remotePort int) (int, error) {
+	return m.CreateTunnelAt(remotePort, targetHost, remotePort)
+}
+
+// CreateTunnelAt is CreateTunnelTo with the local port to try first, such as
+// the one a LocalForward in ssh_config asks for.
+func (m *Manager) CreateTunnelAt(localPort int, targetHost string, remotePort int) (int, error) {
 	m.tunnelsMu.Lock()
 	defer m.tunnelsMu.Unlock()
 
-	if tunnel, exists := m.tunnels[remotePort]; exists {
-		// Check if existing tunnel is healthy
-		select {
-		case <-tunnel.errChan:
-			// Tunnel dead, clean up and recreate
-			m.cleanupTunnel(remotePort)
-		default:
-			if tunnel.Cmd.ProcessState == nil || !tunnel.Cmd.ProcessState.Exited() {
-				return tunnel.LocalPort, nil
-			}
-			m.cleanupTunnel(remotePort)
+	target := Target{Host: transport.TargetHost(targetHost), Port: remotePort}
+
+	preferredPort := localPort
+	if tunnel, exists := m.tunnels[target]; exists {
+		state := tunnel.status().State
+		if state != StateFailed && state != StateExpired {
+			return tunnel.LocalPort, nil
 		}
+		// Supervisor gave up on this tunnel or it expired, recreate it on the same port
+		preferredPort = tunnel.LocalPort
+		m.cleanupTunnel(target)
 	}
 
-	localPort := remotePort
-	if localPort < 1024 || localPort > 65535 {
-		localPort = m.nextPort
-		m.nextPort++
-	} else {
-		if _, exists := m.localPorts[localPort]; exists {
-			localPort = m.nextPort
-			m.nextPort++
-		}
+	ln, err := m.bindLocalPort(target, preferredPort)
+	if err != nil {
+		return 0, err
 	}
+	localPort = ln.Addr().(*net.TCPAddr).Port
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Use ssh.Client to build the command with proper config
-	sshConfig := ssh.Config{
-		Server:       m.server,
-		User:         m.user,
-		KeyPath:      m.keyPath,
-		UseHostAlias: m.useHostAlias,
-		HostAlias:    m.hostAlias,
-		Insecure:     m.insecure,
-	}
-	sshClient := ssh.NewClient(sshConfig)
-	cmd := sshClient.BuildTunnelCommand(ctx, localPort, remotePort)
-
 	tunnel := &Tunnel{
 		RemotePort: remotePort,
 		LocalPort:  localPort,
-		Cmd:        cmd,
+		TargetHost: target.Host,
+		Kind:       KindLocal,
 		ctx:        ctx,
 		cancel:     cancel,
-		errChan:    make(chan error, 1),
+		state:      StateUp,
+		lastActive: time.Now(),
+		listener:   ln,
+		expiresAt:  m.expiry(),
 	}
 
-	if err := cmd.Start(); err != nil {
+	if m.lazy {
+		if err := m.listenLazy(tunnel); err != nil {
+			cancel()
+			return 0, err
+		}
+		m.registerTunnel(tunnel)
+		go m.watchExpiry(tunnel)
+		return localPort, nil
+	}
+
+	// ssh forwards to a private port and the manager relays from localPort
+	tunnel.relayed = true
+	if !m.bindsForwards() {
+		// The ssh binary does not report a port it picks, so one is chosen
+		// here; ExitOnForwardFailure makes ssh fail if it is taken meanwhile
+		forwardPort, err := freeLocalPort()
+		if err != nil {
+			cancel()
+			_ = ln.Close() //nolint:errcheck // Listener was never served
+			return 0, err
+		}
+		tunnel.forwardPort = forwardPort
+	}
+
+	if err := m.listenLocal(tunnel); err != nil {
 		cancel()
-		return 0, fmt.Errorf("failed to start tunnel: %w", err)
+		return 0, err
+	}
+	if err := m.openTunnel(tunnel); err != nil {
+		tunnel.cancel()
+		return 0, err
 	}
 
-	// Start monitoring routine
-	go m.monitorTunnel(tunnel)
+	m.registerTunnel(tunnel)
+	go m.watchExpiry(tunnel)
+	return localPort, nil
+}
+
+// openTunnel opens the SSH forward for t, either over the shared master or as
+// a supervised ssh process.
+func (m *Manager) openTunnel(t *Tunnel) error {
+	if m.master != nil {
+		return m.addForward(t)
+	}
+
+	done, err := m.startProcess(t)
+	if err != nil {
+		return fmt.Errorf("failed to start tunnel: %w", err)
+	}
 
 	// Wait briefly to catch immediate failures (e.g. auth error, port in use)
 	select {
-	case err := <-tunnel.errChan:
-		cancel()
-		return 0, fmt.Errorf("tunnel failed immediately: %w", err)
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("tunnel process exited unexpectedly with code 0")
+		}
+		return fmt.Errorf("tunnel failed immediately: %w", err)
 	case <-time.After(500 * time.Millisecond):
 		// Tunnel seems stable enough for now
 	}
 
-	m.tunnels[remotePort] = tunnel
+	go m.supervise(t, done)
+	return nil
+}
+
+// forward describes the SSH forward this tunnel needs.
+func (t *Tunnel) forward() transport.Forward {
+	switch t.Kind {
+	case KindDynamic:
+		return transport.Forward{Kind: transport.ForwardDynamic, LocalPort: t.LocalPort}
+	case KindReverse:
+		return transport.Forward{
+			Kind:        transport.ForwardReverse,
+			BindAddress: t.BindAddress,
+			RemotePort:  t.RemotePort,
+			LocalPort:   t.LocalPort,
+		}
+	case KindSocket:
+		return transport.Forward{
+			Kind:         transport.ForwardLocal,
+			LocalPort:    t.LocalPort,
+			LocalSocket:  t.LocalSocket,
+			RemoteSocket: t.RemoteSocket,
+		}
+	default:
+		port := t.LocalPort
+		if t.forwardPort != 0 {
+			port = t.forwardPort
+		}
+		return transport.Forward{
+			Kind:       transport.ForwardLocal,
+			LocalPort:  port,
+			RemoteHost: t.target().Host,
+			RemotePort: t.RemotePort,
+		}
+	}
+}
+
+func (m *Manager) registerTunnel(t *Tunnel) {
+	// Assumes caller holds lock
+	target := t.target()
+	m.tunnels[target] = t
 	m.portsMu.Lock()
-	m.localPorts[remotePort] = localPort
+	m.localPorts[target] = t.LocalPort
 	m.portsMu.Unlock()
 
-	return localPort, nil
+	if m.ports != nil {
+		_ = m.ports.Save(target, t.LocalPort) //nolint:errcheck // The saved mapping is only a preference
+	}
 }
 
-func (m *Manager) monitorTunnel(t *Tunnel) {
-	err := t.Cmd.Wait()
+func (t *Tunnel) target() Target {
+	return Target{Host: transport.TargetHost(t.TargetHost), Port: t.RemotePort}
+}
+
+// allocateLocalPort returns a free local port for forwards that ssh binds
+// itself, such as Unix socket forwards, trying preferred first.
+func (m *Manager) allocateLocalPort(preferred int) (int, error) {
+	// Assumes caller holds lock
+	ln, err := m.bindLocalPort(Target{}, preferred)
 	if err != nil {
-		// If context was canceled, it's an intentional stop
-		if t.ctx.Err() != nil {
-			return
+		return 0, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close() //nolint:errcheck // Only used to reserve the port
+	return port, nil
+}
+
+// bindLocalPort binds the local port for target. It tries the port saved for
+// target in the port store, then preferred, then the next free port from the
+// tunnel start port. Ports owned by other processes or saved for another
+// target are skipped.
+func (m *Manager) bindLocalPort(target Target, preferred int) (net.Listener, error) {
+	// Assumes caller holds lock
+	try := func(port int) net.Listener {
+		if port < 1024 || port > 65535 || m.localPortInUse(port) {
+			return nil
+		}
+		if m.ports != nil {
+			if owner, ok := m.ports.Owner(port); ok && owner != target {
+				return nil
+			}
+		}
+		ln, err := net.Listen("tcp", transport.LocalAddr(port))
+		if err != nil {
+			return nil
+		}
+		return ln
+	}
+
+	if m.ports != nil {
+		if saved, ok := m.ports.Lookup(target); ok {
+			if ln := try(saved); ln != nil {
+				return ln, nil
+			}
+		}
+	}
+	if ln := try(preferred); ln != nil {
+		return ln, nil
+	}
+
+	for ; m.nextPort <= 65535; m.nextPort++ {
+		if ln := try(m.nextPort); ln != nil {
+			m.nextPort++
+			return ln, nil
 		}
-		t.errChan <- err
-	} else if t.ctx.Err() == nil {
-		// Process exited with 0, still means tunnel closed
-		t.errChan <- fmt.Errorf("tunnel process exited unexpectedly with code 0")
 	}
-	close(t.errChan)
+	return nil, fmt.Errorf("no free local port from %d", m.startPort)
 }
 
-func (m *Manager) cleanupTunnel(remotePort int) {
+// localPortInUse reports whether a managed tunnel already listens on port.
+func (m *Manager) localPortInUse(port int) bool {
 	// Assumes caller holds lock
-	if t, ok := m.tunnels[remotePort]; ok {
-		delete(m.tunnels, remotePort)
+	for _, t := range m.tunnels {
+		if t.LocalPort == port {
+			return true
+		}
+	}
+	for _, t := range m.sockets {
+		if t.LocalPort == port {
+			return true
+		}
+	}
+	return m.socks != nil && m.socks.LocalPort == port
+}
+
+// startProcess opens the tunnel's forward and returns a channel that
+// receives the reason it stopped.
+func (m *Manager) startProcess(t *Tunnel) (<-chan error, error) {
+	if t.relayed && m.bindsForwards() {
+		// Every start gets a fresh port that nothing else can hold
+		done, port, err := m.startAnyPort(t)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.forwardPort = port
+		t.mu.Unlock()
+		return done, nil
+	}
+
+	handle, err := m.transport.Forward(t.ctx, t.forward())
+	if err != nil {
+		return nil, err
+	}
+	return waitFor(handle), nil
+}
+
+// startAnyPort is startProcess for a forward on a loopback port that the
+// transport picks as it listens, see bindsForwards. It returns the port.
+func (m *Manager) startAnyPort(t *Tunnel) (<-chan error, int, error) {
+	handle, port, err := m.transport.(transport.PortBinder).ForwardAnyPort(t.ctx, t.forward())
+	if err != nil {
+		return nil, 0, err
+	}
+	return waitFor(handle), port, nil
+}
+
+// bindsForwards reports whether forwards get their local port from the
+// transport as it listens, see transport.PortBinder. Forwards added to the
+// shared OpenSSH master need one chosen up front.
+func (m *Manager) bindsForwards() bool {
+	_, ok := m.transport.(transport.PortBinder)
+	return ok && m.master == nil
+}
+
+// waitFor returns a channel that receives the reason handle stopped.
+func waitFor(handle transport.Handle) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- handle.Wait()
+	}()
+	return done
+}
+
+func (m *Manager) cleanupTunnel(target Target) {
+	// Assumes caller holds lock
+	if t, ok := m.tunnels[target]; ok {
+		delete(m.tunnels, target)
 		m.portsMu.Lock()
-		delete(m.localPorts, remotePort)
+		delete(m.localPorts, target)
 		m.portsMu.Unlock()
 		t.cancel() // Just in case
 	}
@@ -169,26 +459,36 @@ func (m *Manager) cleanupTunnel(remotePort int) {
 func (m *Manager) GetLocalPort(remotePort int) (int, bool) {
 	m.portsMu.RLock()
 	defer m.portsMu.RUnlock()
-	localPort, exists := m.localPorts[remotePort]
+	localPort, exists := m.localPorts[defaultTarget(remotePort)]
 	return localPort, exists
 }
 
 // CloseTunnel closes a specific tunnel by remote port.
 func (m *Manager) CloseTunnel(remotePort int) error {
+	return m.CloseTunnelTo(transport.DefaultTargetHost, remotePort)
+}
+
+// CloseTunnelTo closes the tunnel to targetHost:remotePort.
+func (m *Manager) CloseTunnelTo(targetHost string, remotePort int) error {
 	m.tunnelsMu.Lock()
 	defer m.tunnelsMu.Unlock()
 
-	tunnel, exists := m.tunnels[remotePort]
+	target := Target{Host: transport.TargetHost(targetHost), Port: remotePort}
+
+	tunnel, exists := m.tunnels[target]
 	if !exists {
 		return nil
 	}
 
+	if m.master != nil && !tunnel.lazy {
+		m.cancelForward(tunnel)
+	}
 	tunnel.cancel()
-	// No need to kill explicitly, cancel context does it for exec.CommandContext
+	// No need to stop the forward explicitly, the transport ends it with the context
 
-	delete(m.tunnels, remotePort)
+	delete(m.tunnels, target)
 	m.portsMu.Lock()
-	delete(m.localPorts, remotePort)
+	delete(m.localPorts, target)
 	m.portsMu.Unlock()
 
 	return nil
@@ -202,10 +502,29 @@ func (m *Manager) CloseAll() {
 		tunnel.cancel()
 	}
 
-	m.tunnels = make(map[int]*Tunnel)
+	m.tunnels = make(map[Target]*Tunnel)
 	m.portsMu.Lock()
-	m.localPorts = make(map[int]int)
+	m.localPorts = make(map[Target]int)
 	m.portsMu.Unlock()
+
+	if m.socks != nil {
+		m.socks.cancel()
+		m.socks = nil
+	}
+
+	for _, tunnel := range m.reverse {
+		tunnel.cancel()
+	}
+	m.reverse = make(map[int]*Tunnel)
+
+	for _, tunnel := range m.sockets {
+		tunnel.cancel()
+	}
+	m.sockets = make(map[string]*Tunnel)
+
+	if m.master != nil {
+		m.stopMaster()
+	}
 }
 
 // HealthCheck verifies if a tunnel is still active.
@@ -213,15 +532,63 @@ func (m *Manager) HealthCheck(remotePort int) bool {
 	m.tunnelsMu.RLock()
 	defer m.tunnelsMu.RUnlock()
 
-	tunnel, exists := m.tunnels[remotePort]
+	tunnel, exists := m.tunnels[defaultTarget(remotePort)]
 	if !exists {
 		return false
 	}
 
-	select {
-	case <-tunnel.errChan:
-		return false
-	default:
-		return true
+	state := tunnel.status().State
+	return state == StateUp || state == StateIdle
+}
+
+// Traffic returns the connection and byte counters of the tunnel to
+// targetHost:remotePort.
+func (m *Manager) Traffic(targetHost string, remotePort int) (Traffic, bool) {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+
+	targetHost = transport.TargetHost(targetHost)
+	tunnel, exists := m.tunnels[Target{Host: targetHost, Port: remotePort}]
+	if !exists {
+		return Traffic{}, false
 	}
+
+	traffic := tunnel.traffic()
+	if traffic == nil {
+		return Traffic{}, false
+	}
+	return *traffic, true
+}
+
+// Status returns a snapshot of every managed tunnel, ordered by remote port.
+func (m *Manager) Status() []Status {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+
+	statuses := make([]Status, 0, len(m.tunnels))
+	for _, tunnel := range m.tunnels {
+		statuses = append(statuses, tunnel.status())
+	}
+	if m.socks != nil {
+		statuses = append(statuses, m.socks.status())
+	}
+	for _, tunnel := range m.reverse {
+		statuses = append(statuses, tunnel.status())
+	}
+	for _, tunnel := range m.sockets {
+		statuses = append(statuses, tunnel.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].RemotePort != statuses[j].RemotePort {
+			return statuses[i].RemotePort < statuses[j].RemotePort
+		}
+		if statuses[i].Kind != statuses[j].Kind {
+			return statuses[i].Kind < statuses[j].Kind
+		}
+		if statuses[i].RemoteSocket != statuses[j].RemoteSocket {
+			return statuses[i].RemoteSocket < statuses[j].RemoteSocket
+		}
+		return statuses[i].TargetHost < statuses[j].TargetHost
+	})
+	return statuses
 }
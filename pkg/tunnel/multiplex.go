@@ -0,0 +1,293 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// masterReadyTimeout bounds how long StartMaster waits for the control socket.
+const masterReadyTimeout = 15 * time.Second
+
+// master is a shared connection, such as an OpenSSH ControlMaster, that
+// carries every forward, so adding a tunnel does not require another login.
+type master struct {
+	dir     string
+	path    string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	running bool
+}
+
+// EnableMultiplex switches the manager to a single shared SSH connection.
+// It returns the control socket path that other packages can pass to
+// ssh.Config.ControlPath to reuse the same connection. The manager's
+// transport is switched to the shared connection directly.
+func (m *Manager) EnableMultiplex() (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", fmt.Errorf("connection multiplexing is not supported on Windows")
+	}
+	if m.master != nil {
+		return m.master.path, nil
+	}
+	mux, err := m.multiplexer()
+	if err != nil {
+		return "", err
+	}
+
+	// Keep the path short, unix socket paths are limited to ~100 bytes
+	dir, err := os.MkdirTemp("", "tdash-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.master = &master{
+		dir:    dir,
+		path:   filepath.Join(dir, "cm"),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	mux.SetControlPath(m.master.path)
+	return m.master.path, nil
+}
+
+// multiplexer returns the manager's transport if it can share a connection.
+func (m *Manager) multiplexer() (transport.Multiplexer, error) {
+	mux, ok := m.transport.(transport.Multiplexer)
+	if !ok {
+		return nil, fmt.Errorf("transport does not support connection multiplexing")
+	}
+	return mux, nil
+}
+
+// ControlPath returns the shared control socket path, or "" when
+// multiplexing is disabled.
+func (m *Manager) ControlPath() string {
+	if m.master == nil {
+		return ""
+	}
+	return m.master.path
+}
+
+// StartMaster opens the shared connection and waits until it accepts
+// control requests. It is a no-op if the master is already running.
+func (m *Manager) StartMaster() error {
+	if m.master == nil {
+		return fmt.Errorf("multiplexing is not enabled")
+	}
+
+	m.master.mu.Lock()
+	defer m.master.mu.Unlock()
+	if m.master.running {
+		return nil
+	}
+
+	done, err := m.startMasterProcess()
+	if err != nil {
+		return err
+	}
+	m.master.running = true
+
+	go m.superviseMaster(done)
+	return nil
+}
+
+func (m *Manager) startMasterProcess() (<-chan error, error) {
+	mux, err := m.multiplexer()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each attempt gets its own context so a master that never becomes
+	// ready can be stopped without closing the manager's
+	ctx, cancel := context.WithCancel(m.master.ctx)
+	handle, err := mux.Master(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start ssh master: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := handle.Wait()
+		cancel()
+		done <- err
+	}()
+
+	deadline := time.After(masterReadyTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err == nil {
+				err = fmt.Errorf("exited with code 0")
+			}
+			return nil, fmt.Errorf("ssh master failed: %w", err)
+		case <-deadline:
+			cancel()
+			return nil, fmt.Errorf("ssh master not ready after %v", masterReadyTimeout)
+		case <-ticker.C:
+			if m.controlRequest("check", nil) == nil {
+				return done, nil
+			}
+		}
+	}
+}
+
+// superviseMaster restarts the shared connection when it dies and re-adds
+// every forward once it is back, following the manager's reconnect policy.
+func (m *Manager) superviseMaster(done <-chan error) {
+	attempt := 0
+	startedAt := time.Now()
+
+	for {
+		var err error
+		select {
+		case <-m.master.ctx.Done():
+			return
+		case err = <-done:
+		}
+
+		if m.master.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("ssh master exited unexpectedly with code 0")
+		}
+
+		if time.Since(startedAt) >= m.policy.StableAfter {
+			attempt = 0
+		}
+
+		for {
+			if attempt >= m.policy.MaxRestarts {
+				m.master.mu.Lock()
+				m.master.running = false
+				m.master.mu.Unlock()
+				m.setAllStates(StateFailed, err)
+				return
+			}
+
+			m.setAllStates(StateReconnecting, err)
+
+			select {
+			case <-m.master.ctx.Done():
+				return
+			case <-time.After(m.policy.backoff(attempt)):
+			}
+
+			attempt++
+			done, err = m.startMasterProcess()
+			if err == nil {
+				break
+			}
+		}
+
+		startedAt = time.Now()
+		for _, t := range m.snapshotTunnels() {
+			if t.ctx.Err() != nil || t.status().State == StateExpired {
+				// Closed and expired tunnels stay closed
+				continue
+			}
+			if t.lazy {
+				// Lazy tunnels reopen their forward on the next connection
+				t.backendMu.Lock()
+				m.dropBackend(t, true)
+				t.backendMu.Unlock()
+				continue
+			}
+
+			t.mu.Lock()
+			t.restarts++
+			t.mu.Unlock()
+
+			if ferr := m.addForward(t); ferr != nil {
+				m.setState(t, StateFailed, ferr)
+				continue
+			}
+			m.setState(t, StateUp, nil)
+		}
+	}
+}
+
+func (m *Manager) stopMaster() {
+	if m.master.ctx.Err() != nil {
+		return
+	}
+	_ = m.controlRequest("exit", nil) //nolint:errcheck // Master may already be gone
+	m.master.cancel()
+
+	m.master.mu.Lock()
+	m.master.running = false
+	m.master.mu.Unlock()
+
+	_ = os.RemoveAll(m.master.dir) //nolint:errcheck // Best-effort cleanup
+}
+
+// addForward asks the master to add the tunnel's forward for the tunnel,
+// starting the master first if needed.
+func (m *Manager) addForward(t *Tunnel) error {
+	if err := m.StartMaster(); err != nil {
+		return err
+	}
+	f := t.forward()
+	if err := m.controlRequest("forward", &f); err != nil {
+		return fmt.Errorf("failed to add %s forward on local port %d: %w", f.Kind, f.LocalPort, err)
+	}
+	return nil
+}
+
+func (m *Manager) cancelForward(t *Tunnel) {
+	f := t.forward()
+	_ = m.controlRequest("cancel", &f) //nolint:errcheck // Best-effort
+}
+
+// controlRequest sends a short-lived request, with an optional forward, to
+// the master.
+func (m *Manager) controlRequest(operation string, f *transport.Forward) error {
+	mux, err := m.multiplexer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return mux.Control(ctx, operation, f)
+}
+
+func (m *Manager) snapshotTunnels() []*Tunnel {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+
+	tunnels := make([]*Tunnel, 0, len(m.tunnels)+len(m.reverse)+len(m.sockets)+1)
+	for _, t := range m.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	if m.socks != nil {
+		tunnels = append(tunnels, m.socks)
+	}
+	for _, t := range m.reverse {
+		tunnels = append(tunnels, t)
+	}
+	for _, t := range m.sockets {
+		tunnels = append(tunnels, t)
+	}
+	return tunnels
+}
+
+func (m *Manager) setAllStates(state State, err error) {
+	for _, t := range m.snapshotTunnels() {
+		m.setState(t, state, err)
+	}
+}
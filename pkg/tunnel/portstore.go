@@ -0,0 +1,169 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PortStore persists the local port chosen for each remote target, per SSH
+// host, so services keep the same local port across runs.
+//
+// The state file maps host to "targetHost:port" to local port:
+//
+//	{"prod": {"localhost:3000": 9000, "172.18.0.5:5432": 9001}}
+type PortStore struct {
+	path  string
+	host  string
+	mu    sync.Mutex
+	ports map[Target]int
+}
+
+// DefaultPortStatePath returns the default location of the port state file.
+func DefaultPortStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "tunnel-dash", "ports.json"), nil
+}
+
+// LoadPortStore loads the mappings saved for host from path. A missing file
+// yields an empty store.
+func LoadPortStore(path, host string) (*PortStore, error) {
+	s := &PortStore{
+		path:  path,
+		host:  host,
+		ports: make(map[Target]int),
+	}
+
+	all, err := readPortState(path)
+	if err != nil {
+		return nil, err
+	}
+	for key, port := range all[host] {
+		if target, ok := parseTargetKey(key); ok {
+			s.ports[target] = port
+		}
+	}
+	return s, nil
+}
+
+// Lookup returns the local port saved for target.
+func (s *PortStore) Lookup(target Target) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	port, ok := s.ports[target]
+	return port, ok
+}
+
+// Owner returns the target that port is saved for.
+func (s *PortStore) Owner(port int) (Target, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for target, p := range s.ports {
+		if p == port {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+// Save records port for target and writes the state file if it changed.
+func (s *PortStore) Save(target Target, port int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ports[target] == port {
+		return nil
+	}
+	for t, p := range s.ports {
+		// A port belongs to one target, the newest mapping wins
+		if p == port {
+			delete(s.ports, t)
+		}
+	}
+	s.ports[target] = port
+
+	// Re-read so mappings saved meanwhile for other hosts are kept
+	all, err := readPortState(s.path)
+	if err != nil {
+		return err
+	}
+	hostPorts := make(map[string]int, len(s.ports))
+	for t, p := range s.ports {
+		hostPorts[targetKey(t)] = p
+	}
+	all[s.host] = hostPorts
+
+	return writePortState(s.path, all)
+}
+
+func readPortState(path string) (map[string]map[string]int, error) {
+	all := make(map[string]map[string]int)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read port state: %w", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse port state %s: %w", path, err)
+	}
+	return all, nil
+}
+
+func writePortState(path string, all map[string]map[string]int) error {
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create port state directory: %w", err)
+	}
+
+	// Write to a temp file and rename so a crash never leaves a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ports-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write port state: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name()) //nolint:errcheck // Gone after a successful rename
+	}()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close() //nolint:errcheck // Write error takes precedence
+		return fmt.Errorf("failed to write port state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write port state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write port state: %w", err)
+	}
+	return nil
+}
+
+func targetKey(t Target) string {
+	return t.Host + ":" + strconv.Itoa(t.Port)
+}
+
+func parseTargetKey(key string) (Target, bool) {
+	i := strings.LastIndex(key, ":")
+	if i <= 0 {
+		return Target{}, false
+	}
+	port, err := strconv.Atoi(key[i+1:])
+	if err != nil {
+		return Target{}, false
+	}
+	return Target{Host: key[:i], Port: port}, true
+}
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// Traffic is a snapshot of the connections and bytes relayed by a tunnel.
+type Traffic struct {
+	ActiveConnections int   `json:"activeConnections"`
+	TotalConnections  int64 `json:"totalConnections"`
+	// BytesIn is sent by local clients, BytesOut is returned by the service
+	BytesIn    int64     `json:"bytesIn"`
+	BytesOut   int64     `json:"bytesOut"`
+	LastActive time.Time `json:"lastActive,omitempty"`
+}
+
+// listenLocal binds the tunnel's local port, unless t.listener is already
+// bound, and relays every accepted connection so the manager sees all traffic
+// that goes through it.
+func (m *Manager) listenLocal(t *Tunnel) error {
+	ln := t.listener
+	if ln == nil {
+		var err error
+		ln, err = net.Listen("tcp", transport.LocalAddr(t.LocalPort))
+		if err != nil {
+			return fmt.Errorf("failed to listen on local port %d: %w", t.LocalPort, err)
+		}
+		t.listener = ln
+	}
+
+	cancel := t.cancel
+	t.cancel = func() {
+		cancel()
+		_ = ln.Close() //nolint:errcheck // Accept loop exits on close
+	}
+
+	go m.acceptLocal(t)
+	return nil
+}
+
+func (m *Manager) acceptLocal(t *Tunnel) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.serveLocal(t, conn)
+	}
+}
+
+func (m *Manager) serveLocal(t *Tunnel, conn net.Conn) {
+	defer func() {
+		_ = conn.Close() //nolint:errcheck // Ignore close error
+	}()
+
+	t.mu.Lock()
+	t.active++
+	t.totalConns++
+	t.lastActive = time.Now()
+	forwardPort := t.forwardPort
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		t.active--
+		t.lastActive = time.Now()
+		t.mu.Unlock()
+	}()
+
+	var upstream net.Conn
+	var err error
+	if t.lazy {
+		upstream, err = m.dialBackend(t)
+		if err != nil {
+			m.setState(t, StateIdle, err)
+			return
+		}
+	} else {
+		upstream, err = net.DialTimeout("tcp", transport.LocalAddr(forwardPort), 5*time.Second)
+		if err != nil {
+			return
+		}
+	}
+	defer func() {
+		_ = upstream.Close() //nolint:errcheck // Ignore close error
+	}()
+
+	transport.Relay(conn, upstream, &t.bytesIn, &t.bytesOut)
+}
+
+// traffic returns the tunnel's counters, or nil if the manager does not relay
+// its connections.
+func (t *Tunnel) traffic() *Traffic {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.trafficLocked()
+}
+
+// trafficLocked is traffic for callers that hold t.mu.
+func (t *Tunnel) trafficLocked() *Traffic {
+	if t.listener == nil {
+		return nil
+	}
+	return &Traffic{
+		ActiveConnections: t.active,
+		TotalConnections:  t.totalConns,
+		BytesIn:           t.bytesIn.Load(),
+		BytesOut:          t.bytesOut.Load(),
+		LastActive:        t.lastActive,
+	}
+}
+
+// freeLocalPort asks the kernel for an unused loopback port. Another program
+// can take it before it is bound again, so forwards on a transport.PortBinder
+// let the transport pick the port instead.
+func freeLocalPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find free local port: %w", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close() //nolint:errcheck // Only used to discover the port
+	return port, nil
+}
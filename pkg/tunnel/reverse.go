@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReverseForward describes a remote (ssh -R) forward that exposes a port on
+// this machine to the SSH server.
+type ReverseForward struct {
+	// BindAddress is the server-side listen address. Empty means the server's
+	// loopback interface; other addresses require GatewayPorts in sshd_config.
+	BindAddress string `json:"bindAddress,omitempty"`
+	RemotePort  int    `json:"remotePort"`
+	LocalPort   int    `json:"localPort"`
+}
+
+// ParseReverseForward parses "[bind_address:]remote_port:local_port", e.g.
+// "8000:3000" or "172.17.0.1:8000:3000".
+func ParseReverseForward(spec string) (ReverseForward, error) {
+	var f ReverseForward
+
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		// IPv6 bind address in brackets: [fd00::1]:8000:3000
+		if end := strings.LastIndex(spec, "]:"); strings.HasPrefix(spec, "[") && end > 0 {
+			f.BindAddress = spec[1:end]
+			parts = strings.Split(spec[end+2:], ":")
+		}
+	} else if len(parts) == 3 {
+		f.BindAddress = parts[0]
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return f, fmt.Errorf("invalid reverse forward %q, expected [bind_address:]remote_port:local_port", spec)
+	}
+
+	var err error
+	if f.RemotePort, err = parseForwardPort(parts[0]); err != nil {
+		return f, fmt.Errorf("invalid remote port in %q: %w", spec, err)
+	}
+	if f.LocalPort, err = parseForwardPort(parts[1]); err != nil {
+		return f, fmt.Errorf("invalid local port in %q: %w", spec, err)
+	}
+	return f, nil
+}
+
+func parseForwardPort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
+
+// CreateReverseTunnel exposes f.LocalPort on this machine as f.RemotePort on
+// the SSH server. The forward is supervised like any other tunnel.
+func (m *Manager) CreateReverseTunnel(f ReverseForward) error {
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+
+	if existing, ok := m.reverse[f.RemotePort]; ok {
+		if state := existing.status().State; state != StateFailed && state != StateExpired {
+			if existing.LocalPort != f.LocalPort || existing.BindAddress != f.BindAddress {
+				return fmt.Errorf("remote port %d is already forwarded to local port %d", f.RemotePort, existing.LocalPort)
+			}
+			return nil
+		}
+		// Supervisor gave up on this tunnel or it expired, recreate it
+		existing.cancel()
+		delete(m.reverse, f.RemotePort)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Tunnel{
+		RemotePort:  f.RemotePort,
+		LocalPort:   f.LocalPort,
+		BindAddress: f.BindAddress,
+		Kind:        KindReverse,
+		ctx:         ctx,
+		cancel:      cancel,
+		state:       StateUp,
+		expiresAt:   m.expiry(),
+	}
+
+	if err := m.openTunnel(t); err != nil {
+		cancel()
+		return fmt.Errorf("failed to create reverse tunnel: %w", err)
+	}
+
+	m.reverse[f.RemotePort] = t
+	go m.watchExpiry(t)
+	return nil
+}
+
+// CloseReverseTunnel closes the reverse tunnel bound to remotePort.
+func (m *Manager) CloseReverseTunnel(remotePort int) error {
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+
+	t, ok := m.reverse[remotePort]
+	if !ok {
+		return nil
+	}
+
+	if m.master != nil {
+		m.cancelForward(t)
+	}
+	t.cancel()
+	delete(m.reverse, remotePort)
+	return nil
+}
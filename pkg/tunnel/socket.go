@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SocketForward describes a forward to a Unix domain socket on the SSH server.
+type SocketForward struct {
+	RemoteSocket string `json:"remoteSocket"`
+	// LocalSocket is an optional local socket path. When empty the socket is
+	// forwarded to a local TCP port.
+	LocalSocket string `json:"localSocket,omitempty"`
+}
+
+// ParseSocketForward parses "[local_path:]remote_path", e.g.
+// "/var/run/docker.sock" or "/tmp/docker.sock:/var/run/docker.sock". Both
+// paths are absolute, so the first ":/" separates them and paths may contain
+// other colons.
+func ParseSocketForward(spec string) (SocketForward, error) {
+	var f SocketForward
+
+	if i := strings.Index(spec, ":/"); i >= 0 {
+		f.LocalSocket, f.RemoteSocket = spec[:i], spec[i+1:]
+	} else {
+		f.RemoteSocket = spec
+	}
+
+	if !strings.HasPrefix(f.RemoteSocket, "/") {
+		return f, fmt.Errorf("invalid socket forward %q, remote socket must be an absolute path", spec)
+	}
+	if f.LocalSocket != "" && !strings.HasPrefix(f.LocalSocket, "/") {
+		return f, fmt.Errorf("invalid socket forward %q, local socket must be an absolute path", spec)
+	}
+	return f, nil
+}
+
+// CreateSocketTunnel forwards a Unix socket on the SSH server to a local
+// socket path or, when f.LocalSocket is empty, to a local TCP port which is
+// returned.
+func (m *Manager) CreateSocketTunnel(f SocketForward) (int, error) {
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+
+	preferredPort := 0
+	if existing, ok := m.sockets[f.RemoteSocket]; ok {
+		state := existing.status().State
+		if state != StateFailed && state != StateExpired {
+			return existing.LocalPort, nil
+		}
+		// Supervisor gave up on this tunnel or it expired, recreate it on the same port
+		preferredPort = existing.LocalPort
+		existing.cancel()
+		delete(m.sockets, f.RemoteSocket)
+	}
+
+	localPort := 0
+	if f.LocalSocket == "" {
+		port, err := m.allocateLocalPort(preferredPort)
+		if err != nil {
+			return 0, err
+		}
+		localPort = port
+	} else if err := removeStaleSocket(f.LocalSocket); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Tunnel{
+		LocalPort:    localPort,
+		RemoteSocket: f.RemoteSocket,
+		LocalSocket:  f.LocalSocket,
+		Kind:         KindSocket,
+		ctx:          ctx,
+		cancel:       cancel,
+		state:        StateUp,
+		expiresAt:    m.expiry(),
+	}
+
+	if err := m.openTunnel(t); err != nil {
+		cancel()
+		return 0, fmt.Errorf("failed to forward socket %s: %w", f.RemoteSocket, err)
+	}
+
+	m.sockets[f.RemoteSocket] = t
+	go m.watchExpiry(t)
+	return localPort, nil
+}
+
+// removeStaleSocket removes a socket at path that nothing listens on, since
+// ssh refuses to bind over one left by a previous run. A socket that answers
+// belongs to someone else and is left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		// Missing paths and other files are reported by Bind
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close() //nolint:errcheck // Only dialed to see whether the socket is live
+		return fmt.Errorf("local socket %s is already in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	_ = os.Remove(path) //nolint:errcheck // Bind reports the real error
+	return nil
+}
+
+// CloseSocketTunnel closes the forward to remoteSocket.
+func (m *Manager) CloseSocketTunnel(remoteSocket string) error {
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+
+	t, ok := m.sockets[remoteSocket]
+	if !ok {
+		return nil
+	}
+
+	if m.master != nil {
+		m.cancelForward(t)
+	}
+	t.cancel()
+	delete(m.sockets, remoteSocket)
+	return nil
+}
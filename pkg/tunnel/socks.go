@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+)
+
+// StartSOCKS starts a dynamic (ssh -D) SOCKS5 proxy on the given loopback
+// port. Destinations, including hostnames, are resolved on the SSH server.
+func (m *Manager) StartSOCKS(localPort int) error {
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+
+	if m.socks != nil {
+		if state := m.socks.status().State; state != StateFailed && state != StateExpired {
+			return nil
+		}
+		m.socks.cancel()
+		m.socks = nil
+	}
+
+	if m.localPortInUse(localPort) {
+		return fmt.Errorf("local port %d is already used by a tunnel", localPort)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Tunnel{
+		LocalPort: localPort,
+		Kind:      KindDynamic,
+		ctx:       ctx,
+		cancel:    cancel,
+		state:     StateUp,
+		expiresAt: m.expiry(),
+	}
+
+	if err := m.openTunnel(t); err != nil {
+		cancel()
+		return fmt.Errorf("failed to start SOCKS proxy: %w", err)
+	}
+
+	m.socks = t
+	go m.watchExpiry(t)
+	return nil
+}
+
+// SOCKSPort returns the local port of the SOCKS proxy, or 0 if none is running.
+func (m *Manager) SOCKSPort() int {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+
+	if m.socks == nil {
+		return 0
+	}
+	return m.socks.LocalPort
+}
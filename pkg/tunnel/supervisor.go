@@ -0,0 +1,191 @@
+package tunnel
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// State describes the lifecycle state of a supervised tunnel.
+type State string
+
+const (
+	StateUp           State = "up"
+	StateReconnecting State = "reconnecting"
+	StateFailed       State = "failed"
+)
+
+// ReconnectPolicy controls how dead tunnels are restarted.
+type ReconnectPolicy struct {
+	// MaxRestarts is the number of consecutive failed restarts after which
+	// the tunnel is marked failed. Zero disables reconnecting entirely.
+	MaxRestarts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// StableAfter is how long a restarted process must stay up before the
+	// consecutive failure counter is reset.
+	StableAfter time.Duration
+}
+
+// DefaultReconnectPolicy returns the policy used when none is configured.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		MaxRestarts:    10,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		StableAfter:    30 * time.Second,
+	}
+}
+
+// backoff returns the delay before the given restart attempt (0-based),
+// doubling from InitialBackoff up to MaxBackoff with equal jitter applied.
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 0; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// Status is a point-in-time snapshot of a tunnel's supervision state.
+type Status struct {
+	RemotePort int    `json:"remotePort"`
+	LocalPort  int    `json:"localPort"`
+	TargetHost string `json:"targetHost,omitempty"`
+	// BindAddress is only set for reverse tunnels
+	BindAddress string `json:"bindAddress,omitempty"`
+	// RemoteSocket and LocalSocket are only set for Unix socket forwards
+	RemoteSocket string `json:"remoteSocket,omitempty"`
+	LocalSocket  string `json:"localSocket,omitempty"`
+	Kind         Kind   `json:"kind"`
+	State        State  `json:"state"`
+	Restarts     int    `json:"restarts"`
+	LastError    string `json:"lastError,omitempty"`
+	// ErrorKind classifies LastError when the transport could
+	ErrorKind transport.ErrorKind `json:"errorKind,omitempty"`
+	// Traffic is set for tunnels whose connections the manager relays
+	Traffic *Traffic `json:"traffic,omitempty"`
+	// ExpiresAt is set for tunnels with a TTL
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (t *Tunnel) status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Status{
+		RemotePort: t.RemotePort,
+		LocalPort:  t.LocalPort,
+		Kind:       t.Kind,
+		State:      t.state,
+		Restarts:   t.restarts,
+		Traffic:    t.trafficLocked(),
+	}
+	if s.Kind == "" {
+		s.Kind = KindLocal
+	}
+	switch s.Kind {
+	case KindLocal:
+		s.TargetHost = t.target().Host
+	case KindReverse:
+		s.BindAddress = t.BindAddress
+	case KindSocket:
+		s.RemoteSocket = t.RemoteSocket
+		s.LocalSocket = t.LocalSocket
+	}
+	if t.lastErr != nil {
+		s.LastError = t.lastErr.Error()
+		if kind := transport.KindOf(t.lastErr); kind != transport.KindUnknown {
+			s.ErrorKind = kind
+		}
+	}
+	if !t.expiresAt.IsZero() {
+		expiresAt := t.expiresAt
+		s.ExpiresAt = &expiresAt
+	}
+	return s
+}
+
+func (m *Manager) setState(t *Tunnel, state State, err error) {
+	t.mu.Lock()
+	if t.state == StateExpired {
+		// Expired tunnels are closed for good, late updates from their
+		// supervisor or lazy backend must not revive them
+		t.mu.Unlock()
+		return
+	}
+	t.state = state
+	if err != nil {
+		t.lastErr = err
+	}
+	t.mu.Unlock()
+
+	if m.onStateChange != nil {
+		m.onStateChange(t.status())
+	}
+}
+
+// supervise waits for the tunnel process to exit and restarts it on the same
+// local port until the tunnel is closed or the reconnect policy gives up.
+func (m *Manager) supervise(t *Tunnel, done <-chan error) {
+	attempt := 0
+	startedAt := time.Now()
+
+	for {
+		var err error
+		select {
+		case <-t.ctx.Done():
+			return
+		case err = <-done:
+		}
+
+		if t.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("tunnel process exited unexpectedly with code 0")
+		}
+
+		if time.Since(startedAt) >= m.policy.StableAfter {
+			attempt = 0
+		}
+
+		for {
+			if attempt >= m.policy.MaxRestarts {
+				m.setState(t, StateFailed, err)
+				return
+			}
+
+			m.setState(t, StateReconnecting, err)
+
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-time.After(m.policy.backoff(attempt)):
+			}
+
+			attempt++
+			t.mu.Lock()
+			t.restarts++
+			t.mu.Unlock()
+
+			done, err = m.startProcess(t)
+			if err == nil {
+				break
+			}
+		}
+
+		startedAt = time.Now()
+		m.setState(t, StateUp, nil)
+	}
+}
@@ -0,0 +1,239 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/azizoid/zero-trust-tunnel-dashboard/pkg/transport"
+)
+
+// StateExpired marks a tunnel that was closed because its TTL ran out or it
+// sat idle for too long. It keeps its local port until extended or recreated.
+const StateExpired State = "expired"
+
+// expiryCheckInterval is how often tunnels are checked against their TTL and
+// idle timeout.
+const expiryCheckInterval = time.Second
+
+// SetTTL limits how long tunnels of every kind created afterwards stay open.
+// Zero disables the limit.
+func (m *Manager) SetTTL(ttl time.Duration) {
+	m.ttl = ttl
+}
+
+// SetIdleTimeout closes tunnels that have had no connections for timeout.
+// Only tunnels to remote ports are relayed by the manager, so reverse, socket
+// and SOCKS tunnels are never idle. Zero disables the limit.
+func (m *Manager) SetIdleTimeout(timeout time.Duration) {
+	m.idleTimeout = timeout
+}
+
+// expiry returns when a tunnel opened now expires, zero without a TTL.
+func (m *Manager) expiry() time.Time {
+	if m.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(m.ttl)
+}
+
+// SetTunnelTTL overrides the TTL of the tunnel to targetHost:remotePort,
+// counting from now. Zero removes the limit.
+func (m *Manager) SetTunnelTTL(targetHost string, remotePort int, ttl time.Duration) error {
+	t, err := m.lookupTunnel(targetHost, remotePort)
+	if err != nil {
+		return err
+	}
+	t.setTTL(ttl)
+	return nil
+}
+
+// SetPortTTL is SetTunnelTTL for every tunnel to remotePort, whichever host
+// it connects to, such as a listener bound to 10.0.3.7 rather than
+// localhost. It returns how many tunnels it applied to.
+func (m *Manager) SetPortTTL(remotePort int, ttl time.Duration) (int, error) {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+
+	count := 0
+	for target, t := range m.tunnels {
+		if target.Port == remotePort {
+			t.setTTL(ttl)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("no tunnel to port %d", remotePort)
+	}
+	return count, nil
+}
+
+// setTTL makes t expire ttl from now, or never when ttl is zero.
+func (t *Tunnel) setTTL(ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ttl > 0 {
+		t.expiresAt = time.Now().Add(ttl)
+	} else {
+		t.expiresAt = time.Time{}
+	}
+}
+
+// ExtendTunnel pushes the expiry of the tunnel to targetHost:remotePort back
+// by d and resets its idle timer. An expired tunnel is reopened on its
+// previous local port with d as its TTL. It returns the new expiry, which is
+// zero if the tunnel has no TTL.
+func (m *Manager) ExtendTunnel(targetHost string, remotePort int, d time.Duration) (time.Time, error) {
+	t, err := m.lookupTunnel(targetHost, remotePort)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return m.extend(t, d, func() (*Tunnel, error) {
+		if _, err := m.CreateTunnelTo(targetHost, remotePort); err != nil {
+			return nil, err
+		}
+		return m.lookupTunnel(targetHost, remotePort)
+	})
+}
+
+// ExtendReverseTunnel is ExtendTunnel for the reverse tunnel bound to
+// remotePort on the server.
+func (m *Manager) ExtendReverseTunnel(remotePort int, d time.Duration) (time.Time, error) {
+	m.tunnelsMu.RLock()
+	t, ok := m.reverse[remotePort]
+	m.tunnelsMu.RUnlock()
+	if !ok {
+		return time.Time{}, fmt.Errorf("no reverse tunnel on remote port %d", remotePort)
+	}
+	return m.extend(t, d, func() (*Tunnel, error) {
+		forward := ReverseForward{BindAddress: t.BindAddress, RemotePort: t.RemotePort, LocalPort: t.LocalPort}
+		if err := m.CreateReverseTunnel(forward); err != nil {
+			return nil, err
+		}
+		m.tunnelsMu.RLock()
+		defer m.tunnelsMu.RUnlock()
+		return m.reverse[remotePort], nil
+	})
+}
+
+// ExtendSocketTunnel is ExtendTunnel for the forward to remoteSocket.
+func (m *Manager) ExtendSocketTunnel(remoteSocket string, d time.Duration) (time.Time, error) {
+	m.tunnelsMu.RLock()
+	t, ok := m.sockets[remoteSocket]
+	m.tunnelsMu.RUnlock()
+	if !ok {
+		return time.Time{}, fmt.Errorf("no forward to socket %s", remoteSocket)
+	}
+	return m.extend(t, d, func() (*Tunnel, error) {
+		if _, err := m.CreateSocketTunnel(SocketForward{RemoteSocket: t.RemoteSocket, LocalSocket: t.LocalSocket}); err != nil {
+			return nil, err
+		}
+		m.tunnelsMu.RLock()
+		defer m.tunnelsMu.RUnlock()
+		return m.sockets[remoteSocket], nil
+	})
+}
+
+// ExtendSOCKS is ExtendTunnel for the SOCKS proxy.
+func (m *Manager) ExtendSOCKS(d time.Duration) (time.Time, error) {
+	m.tunnelsMu.RLock()
+	t := m.socks
+	m.tunnelsMu.RUnlock()
+	if t == nil {
+		return time.Time{}, fmt.Errorf("no SOCKS proxy")
+	}
+	return m.extend(t, d, func() (*Tunnel, error) {
+		if err := m.StartSOCKS(t.LocalPort); err != nil {
+			return nil, err
+		}
+		m.tunnelsMu.RLock()
+		defer m.tunnelsMu.RUnlock()
+		return m.socks, nil
+	})
+}
+
+// extend pushes t's expiry back by d, or reopens it with d as its TTL when
+// it already expired.
+func (m *Manager) extend(t *Tunnel, d time.Duration, reopen func() (*Tunnel, error)) (time.Time, error) {
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("extension must be positive, got %v", d)
+	}
+
+	if t.status().State == StateExpired {
+		reopened, err := reopen()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to reopen tunnel: %w", err)
+		}
+		reopened.mu.Lock()
+		defer reopened.mu.Unlock()
+		reopened.expiresAt = time.Now().Add(d)
+		return reopened.expiresAt, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastActive = time.Now()
+	if t.expiresAt.IsZero() {
+		return time.Time{}, nil
+	}
+	if t.expiresAt.Before(time.Now()) {
+		t.expiresAt = time.Now()
+	}
+	t.expiresAt = t.expiresAt.Add(d)
+	return t.expiresAt, nil
+}
+
+func (m *Manager) lookupTunnel(targetHost string, remotePort int) (*Tunnel, error) {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+
+	targetHost = transport.TargetHost(targetHost)
+	t, exists := m.tunnels[Target{Host: targetHost, Port: remotePort}]
+	if !exists {
+		return nil, fmt.Errorf("no tunnel to %s:%d", targetHost, remotePort)
+	}
+	return t, nil
+}
+
+// watchExpiry closes the tunnel once its TTL has passed or it has been idle
+// longer than the manager's idle timeout.
+func (m *Manager) watchExpiry(t *Tunnel) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if reason := m.expiryReason(t, time.Now()); reason != nil {
+			m.expireTunnel(t, reason)
+			return
+		}
+	}
+}
+
+// expiryReason returns why t should be closed at now, or nil if it should not.
+func (m *Manager) expiryReason(t *Tunnel, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.expiresAt.IsZero() && !now.Before(t.expiresAt) {
+		return fmt.Errorf("TTL expired")
+	}
+	if m.idleTimeout > 0 && t.listener != nil && t.active == 0 && now.Sub(t.lastActive) >= m.idleTimeout {
+		return fmt.Errorf("idle for %v", m.idleTimeout)
+	}
+	return nil
+}
+
+// expireTunnel closes the tunnel's forward and listener but keeps it
+// registered so it is reported as expired.
+func (m *Manager) expireTunnel(t *Tunnel, reason error) {
+	if m.master != nil && !t.lazy {
+		m.cancelForward(t)
+	}
+	t.cancel()
+	m.setState(t, StateExpired, reason)
+}